@@ -3,19 +3,372 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os"
+	"reflect"
 	"strconv"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/prometheus/exporter-toolkit/web"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
 )
 
+// argparserExit prints the error (or help) and exits
+func argparserExit(err error) {
+	if argparserIsErrorType(err, flags.ErrHelp) {
+		fmt.Println(err.Error())
+		os.Exit(0)
+	}
+
+	fmt.Fprintf(os.Stderr, "%v%v\n", "[ERROR] ", err.Error())
+	fmt.Println()
+	argparser.WriteHelp(os.Stdout)
+	os.Exit(1)
+}
+
+// argparserIsErrorType checks if err is a go-flags error of errorType
+func argparserIsErrorType(err error, errorType flags.ErrorType) bool {
+	var flagsErr *flags.Error
+	return errors.As(err, &flagsErr) && flagsErr.Type == errorType
+}
+
+// argparserParse parses flags, env vars and the config file into target
+func argparserParse(target *config.Opts) (*flags.Parser, error) {
+	parser := flags.NewParser(target, flags.HelpFlag|flags.PassDoubleDash)
+	_, err := parser.Parse()
+
+	// check if there is an parse error
+	// (required options can also be set by config file, this is checked after loading the config file)
+	if err != nil && (target.Config.Path == "" || !argparserIsErrorType(err, flags.ErrRequired)) {
+		return parser, err
+	}
+
+	// load config file
+	if target.Config.Path != "" {
+		if parser, err = argparserLoadConfigFile(parser, target); err != nil {
+			return parser, err
+		}
+	}
+
+	// check tenants
+	if (target.Azure.Tenant == nil || *target.Azure.Tenant == "") && len(target.Tenants) == 0 {
+		return parser, errors.New(`the required flag "--azure.tenant" was not specified (or set tenants in the config file)`)
+	}
+
+	tenantIDs := []string{}
+	if target.Azure.Tenant != nil && *target.Azure.Tenant != "" {
+		tenantIDs = append(tenantIDs, *target.Azure.Tenant)
+	}
+	for _, tenant := range target.Tenants {
+		if stringInSliceCI(tenant.ID, tenantIDs) {
+			return parser, fmt.Errorf(`tenant "%v" is configured multiple times`, tenant.ID)
+		}
+		tenantIDs = append(tenantIDs, tenant.ID)
+	}
+
+	for _, query := range target.Costs.QueryConfigs {
+		for _, scope := range query.Scopes {
+			if scope.Tenant != "" && !stringInSliceCI(scope.Tenant, tenantIDs) {
+				return parser, fmt.Errorf(`cost query "%v" uses unknown tenant "%v"`, query.Name, scope.Tenant)
+			}
+		}
+	}
+
+	if target.Portscan.Enabled {
+		// validate --portscan-range
+		if _, err := argparserParsePortrange(target); err != nil {
+			return parser, err
+		}
+	}
+
+	if target.Costs.DailyDays < 0 {
+		return parser, errors.New(`--costs.daily.days cannot be negative`)
+	}
+
+	if target.Costs.RequestRetries < 0 {
+		return parser, errors.New(`--costs.request.retries cannot be negative`)
+	}
+
+	if target.Costs.AnomalyDays < 0 {
+		return parser, errors.New(`--costs.anomaly.days cannot be negative`)
+	}
+
+	if target.Costs.AnomalyDays > 0 && target.Costs.AnomalyFactor <= 1 {
+		return parser, errors.New(`--costs.anomaly.factor needs to be greater than one`)
+	}
+
+	// query names used by the anomaly metrics of other queries (azurerm_costs_<query>_anomaly)
+	if target.Costs.AnomalyDays > 0 {
+		queryNames := []string{}
+		for _, query := range target.Costs.Queries {
+			queryNames = append(queryNames, strings.SplitN(query, "=", 2)[0])
+		}
+		for _, query := range target.Costs.QueryConfigs {
+			queryNames = append(queryNames, query.Name)
+		}
+
+		for _, queryName := range queryNames {
+			for _, otherQueryName := range queryNames {
+				if strings.EqualFold(queryName, otherQueryName+"_anomaly") || strings.EqualFold(queryName, otherQueryName+"_anomaly_score") {
+					return parser, fmt.Errorf(`cost query "%v" cannot be used with --costs.anomaly.days (name is used by the anomaly metrics of query "%v")`, queryName, otherQueryName)
+				}
+			}
+		}
+	}
+
+	// check cost export types
+	for _, exportType := range target.Costs.ExportTypes {
+		if _, err := config.NormalizeCostExportType(exportType); err != nil {
+			return parser, fmt.Errorf(`--costs.exporttype: %w`, err)
+		}
+	}
+
+	// check currency normalization
+	if _, err := config.ParseCurrencyRates(target.Costs.CurrencyRates); err != nil {
+		return parser, fmt.Errorf(`--costs.currency.rate: %w`, err)
+	}
+
+	if target.Costs.CurrencyRatesFile != "" {
+		if target.Costs.CurrencyTarget == "" {
+			return parser, errors.New(`--costs.currency.ratesfile needs --costs.currency.target`)
+		}
+
+		if _, err := config.ReadCurrencyRatesFile(target.Costs.CurrencyRatesFile); err != nil {
+			return parser, err
+		}
+	}
+
+	// check quota providers
+	for _, provider := range target.Quota.Providers {
+		if !strings.Contains(provider, ".") || strings.ContainsAny(provider, "/ ") {
+			return parser, fmt.Errorf(`--quota.provider: "%s" is not a resource provider namespace (eg. Microsoft.Compute)`, provider)
+		}
+	}
+
+	if target.Quota.RequestDays < 0 {
+		return parser, errors.New(`--quota.request.days cannot be negative`)
+	}
+
+	// check server auth
+	if target.Server.BearerToken != "" && target.Server.BearerTokenFile != "" {
+		return parser, fmt.Errorf(`--server.auth.bearer-token and --server.auth.bearer-token-file cannot be used together`)
+	}
+
+	if target.Server.BearerTokenFile != "" {
+		content, err := os.ReadFile(target.Server.BearerTokenFile) // #nosec G304 path is set by the user
+		if err != nil {
+			return parser, fmt.Errorf(`--server.auth.bearer-token-file: unable to read "%s": %w`, target.Server.BearerTokenFile, err)
+		}
+		if strings.TrimSpace(string(content)) == "" {
+			return parser, fmt.Errorf(`--server.auth.bearer-token-file: "%s" is empty`, target.Server.BearerTokenFile)
+		}
+	}
+
+	if err := web.Validate(target.Server.WebConfig); err != nil {
+		return parser, fmt.Errorf(`--server.web-config: %w`, err)
+	}
+
+	// check collector config
+	for name := range target.Collectors {
+		if !collectorNameIsValid(name) {
+			return parser, fmt.Errorf(`config file contains unknown collector "%v" (valid collectors: %v)`, name, strings.Join(collectorNames(), ", "))
+		}
+	}
+
+	// check oneshot collectors
+	for _, name := range target.Oneshot.Collectors {
+		if !collectorNameIsValid(name) {
+			return parser, fmt.Errorf(`--oneshot.collector contains unknown collector "%v" (valid collectors: %v)`, name, strings.Join(collectorNames(), ", "))
+		}
+	}
+
+	// check readiness collectors
+	for _, name := range target.Readiness.Collectors {
+		if !collectorNameIsValid(name) {
+			return parser, fmt.Errorf(`--readiness.collector contains unknown collector "%v" (valid collectors: %v)`, name, strings.Join(collectorNames(), ", "))
+		}
+	}
+
+	// deprecated option
+	if len(target.Azure.ResourceGroupTags) > 0 {
+		target.Azure.ResourceTags = target.Azure.ResourceGroupTags
+	}
+
+	// scrape time
+	if target.Scrape.TimeGeneral == nil {
+		target.Scrape.TimeGeneral = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeResource == nil {
+		target.Scrape.TimeResource = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeQuota == nil {
+		target.Scrape.TimeQuota = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeCosts == nil {
+		target.Scrape.TimeCosts = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeCostsForecast == nil {
+		target.Scrape.TimeCostsForecast = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeReservation == nil {
+		target.Scrape.TimeReservation = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeIam == nil {
+		target.Scrape.TimeIam = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeSecurity == nil {
+		target.Scrape.TimeSecurity = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeResourceHealth == nil {
+		target.Scrape.TimeResourceHealth = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimeGraph == nil {
+		target.Scrape.TimeGraph = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimePortscan == nil {
+		target.Scrape.TimePortscan = &target.Scrape.Time
+	}
+
+	if target.Scrape.TimePortscan == nil || target.Scrape.TimePortscan.Seconds() == 0 && target.Portscan.Enabled {
+		return parser, errors.New(`portscan is enabled but has invalid scape time (zero)`)
+	}
+
+	// check deprecated env vars
+	deprecatedEnvVars := map[string]string{
+		"SCRAPE_TIME_CONTAINERREGISTRY": "not supported anymore",
+		"SCRAPE_TIME_CONTAINERINSTANCE": "not supported anymore",
+		"SCRAPE_TIME_EVENTHUB":          "not supported anymore",
+		"SCRAPE_TIME_STORAGE":           "not supported anymore",
+		"SCRAPE_TIME_COMPUTE":           "not supported anymore",
+		"SCRAPE_TIME_NETWORK":           "not supported anymore",
+		"SCRAPE_TIME_DATABASE":          "not supported anymore",
+		"SCRAPE_TIME_COMPUTING":         "deprecated, please use SCRAPE_TIME_COMPUTE",
+	}
+	for envVar, reason := range deprecatedEnvVars {
+		if os.Getenv(envVar) != "" {
+			return parser, fmt.Errorf("env var %v is %v", envVar, reason)
+		}
+	}
+
+	return parser, nil
+}
+
+// load --config file, options set by flags or env vars take precedence over the config file
+func argparserLoadConfigFile(parser *flags.Parser, target *config.Opts) (*flags.Parser, error) {
+	configFile, err := config.ReadFile(target.Config.Path)
+	if err != nil {
+		return parser, err
+	}
+
+	configFileArgs := []string{}
+	for _, fileOption := range configFile.Options {
+		fileError := &config.FileError{Path: configFile.Path, Line: fileOption.Line, Key: fileOption.Name}
+
+		option := parser.FindOptionByLongName(fileOption.Name)
+		if option == nil || option == parser.FindOptionByLongName("config") {
+			fileError.Message = "unknown option"
+			return parser, fileError
+		}
+
+		optionArgs, err := argparserConfigFileOptionArgs(option, fileOption)
+		if err != nil {
+			fileError.Message = err.Error()
+			return parser, fileError
+		}
+
+		// set by flag
+		if option.IsSet() && !option.IsSetDefault() {
+			continue
+		}
+
+		// set by env var
+		if envKey := option.EnvKeyWithNamespace(); envKey != "" {
+			if _, exists := os.LookupEnv(envKey); exists {
+				continue
+			}
+		}
+
+		configFileArgs = append(configFileArgs, optionArgs...)
+	}
+
+	// parse again with config file options in front of the command line arguments
+	*target = config.Opts{}
+	parser = flags.NewParser(target, flags.HelpFlag|flags.PassDoubleDash)
+	if _, err := parser.ParseArgs(append(configFileArgs, os.Args[1:]...)); err != nil {
+		return parser, err
+	}
+
+	target.Costs.QueryConfigs = configFile.Costs.Queries
+	target.Collectors = configFile.Collectors
+	target.Tenants = configFile.Tenants
+
+	return parser, nil
+}
+
+// argparserConfigFileOptionArgs converts a config file option to command line arguments and validates the value
+func argparserConfigFileOptionArgs(option *flags.Option, fileOption config.FileOption) ([]string, error) {
+	optionType := reflect.TypeOf(option.Value())
+	for optionType.Kind() == reflect.Ptr {
+		optionType = optionType.Elem()
+	}
+
+	args := []string{}
+	switch optionType.Kind() {
+	case reflect.Bool:
+		if fileOption.IsList {
+			return nil, errors.New("expected a boolean, got a list")
+		}
+
+		enabled, err := strconv.ParseBool(fileOption.Values[0])
+		if err != nil {
+			return nil, fmt.Errorf(`expected a boolean, got "%s"`, fileOption.Values[0])
+		}
+
+		if enabled {
+			args = append(args, "--"+fileOption.Name)
+		}
+		return args, nil
+	case reflect.Slice, reflect.Map:
+		for _, value := range fileOption.Values {
+			args = append(args, fmt.Sprintf("--%s=%s", fileOption.Name, value))
+		}
+	default:
+		if fileOption.IsList {
+			return nil, errors.New("expected a single value, got a list")
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", fileOption.Name, fileOption.Values[0]))
+	}
+
+	// validate value by parsing it into an empty config
+	validationOpts := config.Opts{}
+	if _, err := flags.NewParser(&validationOpts, flags.None).ParseArgs(args); err != nil && !argparserIsErrorType(err, flags.ErrRequired) {
+		return nil, err
+	}
+
+	return args, nil
+}
+
 // parse --portscan-range
-func argparserParsePortrange() (errorMessage error) {
+func argparserParsePortrange(target *config.Opts) (portranges []Portrange, errorMessage error) {
 	var err error
 	var firstPort int64
 	var lastPort int64
 
-	if len(opts.Portscan.PortRange) > 0 {
-		portscanPortRange = []Portrange{}
+	if len(target.Portscan.PortRange) > 0 {
+		portranges = []Portrange{}
 
-		for _, portrange := range opts.Portscan.PortRange {
+		for _, portrange := range target.Portscan.PortRange {
 			// parse via regexp
 			portscanRangeSubMatch := portrangeRegexp.FindStringSubmatch(portrange)
 
@@ -71,8 +424,8 @@ func argparserParsePortrange() (errorMessage error) {
 			}
 
 			// add to portlist
-			portscanPortRange = append(
-				portscanPortRange,
+			portranges = append(
+				portranges,
 				Portrange{FirstPort: int(firstPort), LastPort: int(lastPort)},
 			)
 		}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+func TestArgparserConfigFileOptionArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		option   config.FileOption
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "bool enabled",
+			option:   config.FileOption{Name: "log.debug", Values: []string{"true"}},
+			expected: []string{"--log.debug"},
+		},
+		{
+			name:     "bool disabled",
+			option:   config.FileOption{Name: "log.debug", Values: []string{"false"}},
+			expected: []string{},
+		},
+		{
+			name:    "bool invalid",
+			option:  config.FileOption{Name: "log.debug", Values: []string{"yes please"}},
+			wantErr: true,
+		},
+		{
+			name:    "bool list",
+			option:  config.FileOption{Name: "log.debug", Values: []string{"true"}, IsList: true},
+			wantErr: true,
+		},
+		{
+			name:     "duration",
+			option:   config.FileOption{Name: "scrape.time", Values: []string{"10m"}},
+			expected: []string{"--scrape.time=10m"},
+		},
+		{
+			name:    "duration invalid",
+			option:  config.FileOption{Name: "scrape.time", Values: []string{"ten minutes"}},
+			wantErr: true,
+		},
+		{
+			name:     "pointer",
+			option:   config.FileOption{Name: "azure.tenant", Values: []string{"tenant-a"}},
+			expected: []string{"--azure.tenant=tenant-a"},
+		},
+		{
+			name:    "single value list",
+			option:  config.FileOption{Name: "azure.tenant", Values: []string{"tenant-a", "tenant-b"}, IsList: true},
+			wantErr: true,
+		},
+		{
+			name:     "list",
+			option:   config.FileOption{Name: "azure.location", Values: []string{"westeurope", "northeurope"}, IsList: true},
+			expected: []string{"--azure.location=westeurope", "--azure.location=northeurope"},
+		},
+		{
+			name:     "list with single value",
+			option:   config.FileOption{Name: "azure.location", Values: []string{"westeurope"}},
+			expected: []string{"--azure.location=westeurope"},
+		},
+		{
+			name:    "invalid choice",
+			option:  config.FileOption{Name: "quota.location.discovery", Values: []string{"everything"}},
+			wantErr: true,
+		},
+	}
+
+	parser := flags.NewParser(&config.Opts{}, flags.None)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			option := parser.FindOptionByLongName(test.option.Name)
+			if option == nil {
+				t.Fatalf("option %s not found", test.option.Name)
+			}
+
+			args, err := argparserConfigFileOptionArgs(option, test.option)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", args)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(args, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, args)
+			}
+		})
+	}
+}
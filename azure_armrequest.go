@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const (
+	// module name used for the azure sdk pipelines of the exporter (user agent)
+	armRequestModuleName = "azure-resourcemanager-exporter"
+)
+
+type (
+	// armListResult is the result page of ARM list apis
+	armListResult struct {
+		Value    []json.RawMessage `json:"value"`
+		NextLink *string           `json:"nextLink"`
+	}
+)
+
+// newArmPipeline creates an azure sdk pipeline for apis which are not available in the azure sdk
+func newArmPipeline(tenant *AzureTenant, clientOptions *arm.ClientOptions) (runtime.Pipeline, error) {
+	return armruntime.NewPipeline(armRequestModuleName, gitTag, tenant.Client.GetCred(), runtime.PipelineOptions{}, clientOptions)
+}
+
+// armGet fetches a single ARM resource (eg. /subscriptions/{id}/providers/Microsoft.Batch/locations/{location}/quotas)
+// and decodes it into target
+func armGet(ctx context.Context, tenant *AzureTenant, path, apiVersion string, target interface{}) error {
+	pipeline, req, err := newArmRequest(ctx, tenant, path, apiVersion)
+	if err != nil {
+		return err
+	}
+
+	resp, err := pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, target)
+}
+
+// armListAll fetches all pages of an ARM list api (eg. /providers/Microsoft.Capacity/reservations)
+// and calls the callback for each value
+func armListAll(ctx context.Context, tenant *AzureTenant, path, apiVersion string, callback func(value json.RawMessage) error) error {
+	pipeline, req, err := newArmRequest(ctx, tenant, path, apiVersion)
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := pipeline.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return runtime.NewResponseError(resp)
+		}
+
+		page := armListResult{}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return err
+		}
+
+		for _, value := range page.Value {
+			if err := callback(value); err != nil {
+				return err
+			}
+		}
+
+		if page.NextLink == nil || *page.NextLink == "" {
+			return nil
+		}
+
+		if req, err = runtime.NewRequest(ctx, http.MethodGet, *page.NextLink); err != nil {
+			return err
+		}
+		req.Raw().Header["Accept"] = []string{"application/json"}
+	}
+}
+
+// newArmRequest creates the pipeline and the GET request for the path of the resource manager endpoint
+func newArmRequest(ctx context.Context, tenant *AzureTenant, path, apiVersion string) (runtime.Pipeline, *policy.Request, error) {
+	clientOptions := tenant.Client.NewArmClientOptions()
+
+	pipeline, err := newArmPipeline(tenant, clientOptions)
+	if err != nil {
+		return pipeline, nil, err
+	}
+
+	endpoint := cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint
+	if service, ok := clientOptions.Cloud.Services[cloud.ResourceManager]; ok {
+		endpoint = service.Endpoint
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(endpoint, path))
+	if err != nil {
+		return pipeline, nil, err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", apiVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	return pipeline, req, nil
+}
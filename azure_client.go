@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	kiotaAuth "github.com/microsoft/kiota-authentication-azure-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/directoryobjects"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/azuresdk/cloudconfig"
+	"github.com/webdevops/go-common/msgraphsdk/msgraphclient"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	// azureClientCacheTtl is the cache duration of subscriptions and resource providers
+	azureClientCacheTtl = 30 * time.Minute
+
+	// msGraphClientCacheTtl is the cache duration of directory objects
+	msGraphClientCacheTtl = 1 * time.Hour
+
+	// msGraphLookupChunkSize is the max number of object ids of a directory object lookup
+	msGraphLookupChunkSize = 999
+)
+
+type (
+	// AzureClient is the Azure ResourceManager client of a tenant, all API calls are using the credential of the tenant
+	// (go-common is only used for the client options of the Azure environment)
+	AzureClient struct {
+		armClient *armclient.ArmClient
+		cred      azcore.TokenCredential
+
+		// subscriptions are limited to these subscriptions (if set)
+		subscriptionFilter []string
+
+		cache *cache.Cache
+	}
+
+	// MsGraphClient is the MS Graph client of a tenant using the credential of the tenant
+	MsGraphClient struct {
+		serviceClient *msgraphsdk.GraphServiceClient
+		adapter       *msgraphsdk.GraphRequestAdapter
+
+		cache *cache.Cache
+	}
+)
+
+func newAzureClient(armClient *armclient.ArmClient, cred azcore.TokenCredential, subscriptionFilter []string) *AzureClient {
+	return &AzureClient{
+		armClient:          armClient,
+		cred:               cred,
+		subscriptionFilter: subscriptionFilter,
+		cache:              cache.New(azureClientCacheTtl, 60*time.Second),
+	}
+}
+
+// GetCred returns the credential of the tenant
+func (c *AzureClient) GetCred() azcore.TokenCredential {
+	return c.cred
+}
+
+// NewArmClientOptions returns the client options for Azure ResourceManager SDK clients
+func (c *AzureClient) NewArmClientOptions() *arm.ClientOptions {
+	return c.armClient.NewArmClientOptions()
+}
+
+// NewAzCoreClientOptions returns the client options for Azure SDK clients
+func (c *AzureClient) NewAzCoreClientOptions() *azcore.ClientOptions {
+	return c.armClient.NewAzCoreClientOptions()
+}
+
+// ListCachedSubscriptionsWithFilter returns the subscriptions of the tenant (cached) with subscription id filter
+func (c *AzureClient) ListCachedSubscriptionsWithFilter(ctx context.Context, subscriptionFilter ...string) (map[string]*armsubscriptions.Subscription, error) {
+	if cached, ok := c.cache.Get("subscriptions"); ok {
+		return filterSubscriptions(cached.(map[string]*armsubscriptions.Subscription), subscriptionFilter), nil
+	}
+
+	client, err := armsubscriptions.NewClient(c.cred, c.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	list := map[string]*armsubscriptions.Subscription{}
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subscription := range result.Value {
+			list[to.String(subscription.SubscriptionID)] = subscription
+		}
+	}
+	list = filterSubscriptions(list, c.subscriptionFilter)
+	c.cache.SetDefault("subscriptions", list)
+
+	return filterSubscriptions(list, subscriptionFilter), nil
+}
+
+// IsResourceProviderRegistered returns if the resource provider is registered in the subscription (providers are cached)
+func (c *AzureClient) IsResourceProviderRegistered(ctx context.Context, subscriptionID, providerNamespace string) (bool, error) {
+	cacheKey := "resourceproviders:" + strings.ToLower(subscriptionID)
+
+	var providers map[string]*armresources.Provider
+	if cached, ok := c.cache.Get(cacheKey); ok {
+		providers = cached.(map[string]*armresources.Provider)
+	} else {
+		client, err := armresources.NewProvidersClient(subscriptionID, c.cred, c.NewArmClientOptions())
+		if err != nil {
+			return false, err
+		}
+
+		providers = map[string]*armresources.Provider{}
+		pager := client.NewListPager(nil)
+		for pager.More() {
+			result, err := pager.NextPage(ctx)
+			if err != nil {
+				return false, err
+			}
+
+			for _, provider := range result.Value {
+				providers[to.StringLower(provider.Namespace)] = provider
+			}
+		}
+		c.cache.SetDefault(cacheKey, providers)
+	}
+
+	if provider, exists := providers[strings.ToLower(providerNamespace)]; exists {
+		return strings.EqualFold(to.String(provider.RegistrationState), "Registered"), nil
+	}
+
+	return false, nil
+}
+
+// filterSubscriptions returns the subscriptions with the subscription ids (all subscriptions if the filter is empty)
+func filterSubscriptions(subscriptions map[string]*armsubscriptions.Subscription, subscriptionFilter []string) map[string]*armsubscriptions.Subscription {
+	if len(subscriptionFilter) == 0 {
+		return subscriptions
+	}
+
+	list := map[string]*armsubscriptions.Subscription{}
+	for subscriptionID, subscription := range subscriptions {
+		if stringInSliceCI(subscriptionID, subscriptionFilter) {
+			list[subscriptionID] = subscription
+		}
+	}
+
+	return list
+}
+
+func newMsGraphClient(cloudName string, cred azcore.TokenCredential) (*MsGraphClient, error) {
+	cloudConfig, err := cloudconfig.NewCloudConfig(cloudName)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{}
+	endpoint := ""
+	if serviceConfig, exists := cloudConfig.Services[cloudconfig.ServiceNameMicrosoftGraph]; exists {
+		scopes = []string{serviceConfig.Audience + "/.default"}
+		endpoint = serviceConfig.Endpoint + "/v1.0"
+	}
+
+	serviceClient, err := msgraphsdk.NewGraphServiceClientWithCredentialsAndHosts(cred, scopes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := kiotaAuth.NewAzureIdentityAuthenticationProvider(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	adapter, err := msgraphsdk.NewGraphRequestAdapter(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint != "" {
+		serviceClient.GetAdapter().SetBaseUrl(endpoint)
+		adapter.SetBaseUrl(endpoint)
+	}
+
+	return &MsGraphClient{
+		serviceClient: serviceClient,
+		adapter:       adapter,
+		cache:         cache.New(msGraphClientCacheTtl, 60*time.Second),
+	}, nil
+}
+
+// ServiceClient returns the MS Graph service client
+func (c *MsGraphClient) ServiceClient() *msgraphsdk.GraphServiceClient {
+	return c.serviceClient
+}
+
+// RequestAdapter returns the MS Graph request adapter (eg. for page iterators)
+func (c *MsGraphClient) RequestAdapter() *msgraphsdk.GraphRequestAdapter {
+	return c.adapter
+}
+
+// LookupPrincipalID returns the directory objects (users, groups, applications and service principals) by object id (cached)
+func (c *MsGraphClient) LookupPrincipalID(ctx context.Context, principalIDs ...string) (map[string]*msgraphclient.DirectoryObject, error) {
+	ret := map[string]*msgraphclient.DirectoryObject{}
+
+	lookupPrincipalIDs := []string{}
+	for _, principalID := range principalIDs {
+		if cached, ok := c.cache.Get("object:" + principalID); ok {
+			ret[principalID] = cached.(*msgraphclient.DirectoryObject)
+		} else {
+			lookupPrincipalIDs = append(lookupPrincipalIDs, principalID)
+		}
+	}
+
+	for i := 0; i < len(lookupPrincipalIDs); i += msGraphLookupChunkSize {
+		end := i + msGraphLookupChunkSize
+		if end > len(lookupPrincipalIDs) {
+			end = len(lookupPrincipalIDs)
+		}
+
+		body := directoryobjects.NewGetByIdsPostRequestBody()
+		body.SetIds(lookupPrincipalIDs[i:end])
+
+		result, err := c.serviceClient.DirectoryObjects().GetByIds().Post(ctx, body, nil)
+		if err != nil {
+			return ret, fmt.Errorf("unable to lookup directory objects: %w", err)
+		}
+
+		for _, row := range result.GetValue() {
+			object := &msgraphclient.DirectoryObject{
+				ObjectID: to.String(row.GetId()),
+				Type:     "unknown",
+			}
+
+			switch v := row.(type) {
+			case models.Userable:
+				object.Type = "user"
+				object.DisplayName = to.String(v.GetDisplayName())
+			case models.Groupable:
+				object.Type = "group"
+				object.DisplayName = to.String(v.GetDisplayName())
+			case models.Applicationable:
+				object.Type = "application"
+				object.DisplayName = to.String(v.GetDisplayName())
+				object.ApplicationID = to.String(v.GetAppId())
+			case models.ServicePrincipalable:
+				object.Type = "serviceprincipal"
+				object.DisplayName = to.String(v.GetDisplayName())
+				object.ApplicationID = to.String(v.GetAppId())
+				object.ServicePrincipalType = to.String(v.GetServicePrincipalType())
+
+				if alternativeNames := v.GetAlternativeNames(); strings.EqualFold(object.ServicePrincipalType, "ManagedIdentity") && len(alternativeNames) >= 2 {
+					object.ManagedIdentity = alternativeNames[1]
+				}
+			}
+
+			ret[object.ObjectID] = object
+			c.cache.SetDefault("object:"+object.ObjectID, object)
+		}
+	}
+
+	return ret, nil
+}
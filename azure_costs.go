@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+const (
+	costsRateLimitHeaderPrefix = "x-ms-ratelimit-microsoft.costmanagement-"
+)
+
+type (
+	// CostsRateLimiter delays the Cost Management API requests, the delay is increased after throttled requests
+	// and slowly decreased to --costs.request.delay again after successful requests
+	CostsRateLimiter struct {
+		lock  sync.Mutex
+		delay time.Duration
+	}
+
+	// costsRateLimitPolicy retries throttled (429) Cost Management API requests honoring the retry-after headers
+	costsRateLimitPolicy struct {
+		// opts of the collector run which created the client
+		opts *config.Opts
+	}
+)
+
+var (
+	costsRateLimiter = &CostsRateLimiter{}
+
+	prometheusCostsRateLimit struct {
+		throttled *prometheus.CounterVec
+		remaining *prometheus.GaugeVec
+		delay     prometheus.Gauge
+	}
+)
+
+func initCostsRateLimitMetrics() {
+	prometheusCostsRateLimit.throttled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_costs_ratelimit_throttled_total",
+			Help: "Azure ResourceManager costmanagement throttled (429) API calls",
+		},
+		[]string{
+			"api",
+			"retried",
+		},
+	)
+	prometheus.MustRegister(prometheusCostsRateLimit.throttled)
+
+	prometheusCostsRateLimit.remaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_ratelimit_remaining",
+			Help: "Azure ResourceManager costmanagement remaining API quota (from the x-ms-ratelimit-microsoft.costmanagement-*-remaining headers)",
+		},
+		[]string{
+			"api",
+			"type",
+			"window",
+		},
+	)
+	prometheus.MustRegister(prometheusCostsRateLimit.remaining)
+
+	prometheusCostsRateLimit.delay = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_ratelimit_delay_seconds",
+			Help: "Azure ResourceManager costmanagement current delay between API calls (adaptive backoff)",
+		},
+	)
+	prometheus.MustRegister(prometheusCostsRateLimit.delay)
+}
+
+// newCostsClientOptions returns the client options for Cost Management API clients
+// (throttled requests are handled by costsRateLimitPolicy instead of the azure sdk retry policy)
+func newCostsClientOptions(tenant *AzureTenant, opts *config.Opts) *arm.ClientOptions {
+	clientOptions := tenant.Client.NewArmClientOptions()
+	clientOptions.PerCallPolicies = append(clientOptions.PerCallPolicies, &costsRateLimitPolicy{opts: opts})
+	clientOptions.Retry.StatusCodes = []int{
+		http.StatusRequestTimeout,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+	return clientOptions
+}
+
+// costsQueryUsage runs the query and fetches all result pages (nextLink)
+func costsQueryUsage(ctx context.Context, tenant *AzureTenant, opts *config.Opts, scope string, params armcostmanagement.QueryDefinition) (*armcostmanagement.QueryProperties, error) {
+	clientOptions := newCostsClientOptions(tenant, opts)
+
+	client, err := armcostmanagement.NewQueryClient(tenant.Client.GetCred(), clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.Usage(ctx, scope, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Properties == nil {
+		return nil, nil
+	}
+
+	properties := result.Properties
+	if properties.NextLink == nil || *properties.NextLink == "" {
+		return properties, nil
+	}
+
+	pipeline, err := newArmPipeline(tenant, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	for nextLink := properties.NextLink; nextLink != nil && *nextLink != ""; {
+		// avoid rate limit
+		costsRateLimiter.Wait(ctx, opts)
+
+		req, err := runtime.NewRequest(ctx, http.MethodPost, *nextLink)
+		if err != nil {
+			return nil, err
+		}
+		req.Raw().Header["Accept"] = []string{"application/json"}
+		if err := runtime.MarshalAsJSON(req, params); err != nil {
+			return nil, err
+		}
+
+		resp, err := pipeline.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, runtime.NewResponseError(resp)
+		}
+
+		page := armcostmanagement.QueryResult{}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return nil, err
+		}
+
+		if page.Properties == nil {
+			break
+		}
+
+		properties.Rows = append(properties.Rows, page.Properties.Rows...)
+		nextLink = page.Properties.NextLink
+	}
+
+	properties.NextLink = nil
+	return properties, nil
+}
+
+// Wait sleeps for the current delay, at least --costs.request.delay of the opts of the run (or until the context is canceled)
+func (l *CostsRateLimiter) Wait(ctx context.Context, opts *config.Opts) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(l.currentDelay(opts)):
+	}
+}
+
+func (l *CostsRateLimiter) currentDelay(opts *config.Opts) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if requestDelay := opts.Costs.RequestDelay; l.delay < requestDelay {
+		l.delay = requestDelay
+	}
+
+	return l.delay
+}
+
+// success decreases the delay slowly down to --costs.request.delay
+func (l *CostsRateLimiter) success(opts *config.Opts) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.delay = l.delay * 3 / 4
+	if requestDelay := opts.Costs.RequestDelay; l.delay < requestDelay {
+		l.delay = requestDelay
+	}
+	prometheusCostsRateLimit.delay.Set(l.delay.Seconds())
+}
+
+// throttled doubles the delay (at least the retry-after of the api, max --costs.request.delay.max)
+// and returns the time to wait before the request can be retried
+func (l *CostsRateLimiter) throttled(opts *config.Opts, retryAfter time.Duration) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.delay *= 2
+	if l.delay < opts.Costs.RequestDelay {
+		l.delay = opts.Costs.RequestDelay
+	}
+	if l.delay < retryAfter {
+		l.delay = retryAfter
+	}
+	if opts.Costs.RequestDelayMax > 0 && l.delay > opts.Costs.RequestDelayMax {
+		l.delay = opts.Costs.RequestDelayMax
+	}
+	prometheusCostsRateLimit.delay.Set(l.delay.Seconds())
+
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return l.delay
+}
+
+func (p *costsRateLimitPolicy) Do(req *policy.Request) (*http.Response, error) {
+	api := costsRateLimitApiName(req.Raw().URL.Path)
+
+	for try := 0; ; try++ {
+		resp, err := req.Clone(req.Raw().Context()).Next()
+		if err != nil {
+			return resp, err
+		}
+
+		costsRateLimitObserveRemaining(api, resp)
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			// other errors (eg. 5xx) are not reducing the delay
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				costsRateLimiter.success(p.opts)
+			}
+			return resp, nil
+		}
+
+		wait := costsRateLimiter.throttled(p.opts, costsRateLimitRetryAfter(resp))
+		if try >= p.opts.Costs.RequestRetries {
+			prometheusCostsRateLimit.throttled.With(prometheus.Labels{"api": api, "retried": "false"}).Inc()
+			return resp, nil
+		}
+		prometheusCostsRateLimit.throttled.With(prometheus.Labels{"api": api, "retried": "true"}).Inc()
+
+		log.WithField("api", api).Warnf("costmanagement api request was throttled, retrying in %v", wait.String())
+
+		// the response of the throttled request isn't returned, close it before retrying
+		runtime.Drain(resp)
+		if err := req.RewindBody(); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-req.Raw().Context().Done():
+			return nil, req.Raw().Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// costsRateLimitApiName returns the name of the costmanagement api from the request path (eg. "query" or "forecast")
+func costsRateLimitApiName(path string) string {
+	path = strings.ToLower(path)
+	if pos := strings.LastIndex(path, "/microsoft.costmanagement/"); pos >= 0 {
+		return strings.Split(path[pos+len("/microsoft.costmanagement/"):], "/")[0]
+	}
+	return "unknown"
+}
+
+// costsRateLimitRetryAfter returns the highest retry-after of the Retry-After and
+// x-ms-ratelimit-microsoft.costmanagement-*-retry-after headers (seconds)
+func costsRateLimitRetryAfter(resp *http.Response) (retryAfter time.Duration) {
+	for name, values := range resp.Header {
+		name = strings.ToLower(name)
+		if name != "retry-after" && !(strings.HasPrefix(name, costsRateLimitHeaderPrefix) && strings.HasSuffix(name, "-retry-after")) {
+			continue
+		}
+
+		for _, value := range values {
+			if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && time.Duration(seconds)*time.Second > retryAfter {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return
+}
+
+// costsRateLimitObserveRemaining sets the remaining quota metrics from the x-ms-ratelimit-microsoft.costmanagement-*-remaining
+// headers, values are either a number or a list of windows (eg. "QueriesPerHour:96,QueriesPerMin:16,QueriesPerTenSec:4")
+func costsRateLimitObserveRemaining(api string, resp *http.Response) {
+	for name, values := range resp.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, costsRateLimitHeaderPrefix) || !strings.HasSuffix(name, "-remaining") {
+			continue
+		}
+
+		limitType := strings.TrimSuffix(strings.TrimPrefix(name, costsRateLimitHeaderPrefix), "-remaining")
+
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				window := ""
+				if strings.Contains(part, ":") {
+					windowParts := strings.SplitN(part, ":", 2)
+					window = strings.TrimSpace(windowParts[0])
+					part = windowParts[1]
+				}
+
+				if remaining, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil {
+					prometheusCostsRateLimit.remaining.With(prometheus.Labels{
+						"api":    api,
+						"type":   limitType,
+						"window": window,
+					}).Set(remaining)
+				}
+			}
+		}
+	}
+}
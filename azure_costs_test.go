@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCostsRateLimitRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string][]string
+		expected time.Duration
+	}{
+		{
+			name:     "no headers",
+			headers:  map[string][]string{},
+			expected: 0,
+		},
+		{
+			name:     "retry-after",
+			headers:  map[string][]string{"Retry-After": {"30"}},
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "retry-after with whitespace",
+			headers:  map[string][]string{"Retry-After": {" 15 "}},
+			expected: 15 * time.Second,
+		},
+		{
+			name: "costmanagement headers",
+			headers: map[string][]string{
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Qpu-Retry-After":    {"10"},
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Entity-Retry-After": {"60"},
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Tenant-Retry-After": {"20"},
+			},
+			expected: 60 * time.Second,
+		},
+		{
+			name: "highest of retry-after and costmanagement headers",
+			headers: map[string][]string{
+				"Retry-After": {"45"},
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Qpu-Retry-After": {"10"},
+			},
+			expected: 45 * time.Second,
+		},
+		{
+			name: "other headers are ignored",
+			headers: map[string][]string{
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Qpu-Remaining": {"120"},
+				"X-Ms-Ratelimit-Remaining-Subscription-Reads":           {"11999"},
+			},
+			expected: 0,
+		},
+		{
+			name: "invalid values are ignored",
+			headers: map[string][]string{
+				"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT", "abc"},
+				"X-Ms-Ratelimit-Microsoft.costmanagement-Qpu-Retry-After": {"5"},
+			},
+			expected: 5 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			for name, values := range test.headers {
+				for _, value := range values {
+					resp.Header.Add(name, value)
+				}
+			}
+
+			if retryAfter := costsRateLimitRetryAfter(resp); retryAfter != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, retryAfter)
+			}
+		})
+	}
+}
+
+func TestCostsRateLimitApiName(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{
+			path:     "/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.CostManagement/query",
+			expected: "query",
+		},
+		{
+			path:     "/providers/Microsoft.Management/managementGroups/root/providers/Microsoft.CostManagement/forecast",
+			expected: "forecast",
+		},
+		{
+			path:     "/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.CostManagement/alerts/alert-a",
+			expected: "alerts",
+		},
+		{
+			path:     "/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.Consumption/budgets",
+			expected: "unknown",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if api := costsRateLimitApiName(test.path); api != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, api)
+			}
+		})
+	}
+}
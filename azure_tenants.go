@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/remeh/sizedwaitgroup"
+	log "github.com/sirupsen/logrus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	commonAzidentity "github.com/webdevops/go-common/azuresdk/azidentity"
+	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+type (
+	// AzureTenant is an Azure tenant with its own Azure client (credentials) and subscription filter
+	AzureTenant struct {
+		TenantID string
+		Client   *AzureClient
+
+		// cloudName is the Azure environment of the tenant (--azure.environment)
+		cloudName string
+
+		// subscriptions are limited to these management groups (resolved on each collection)
+		managementGroups             []string
+		managementGroupSubscriptions map[string]string
+		managementGroupLock          sync.RWMutex
+
+		msGraphClient *MsGraphClient
+		msGraphLock   sync.Mutex
+	}
+
+	// AzureTenantSubscription is a subscription and the tenant used for accessing it
+	AzureTenantSubscription struct {
+		Tenant       *AzureTenant
+		Subscription *armsubscriptions.Subscription
+	}
+
+	// AzureSubscriptionsIterator iterates over the subscriptions of all tenants
+	AzureSubscriptionsIterator struct {
+		tenants            []*AzureTenant
+		subscriptionFilter []string
+		collectorConfig    *config.CollectorConfig
+		concurrency        int
+	}
+)
+
+// newAzureTenants creates the tenant from --azure.tenant (using the process env for authentication)
+// and the tenants from the config file
+func newAzureTenants(opts *config.Opts) ([]*AzureTenant, error) {
+	tenants := []*AzureTenant{}
+
+	if opts.Azure.Tenant != nil && *opts.Azure.Tenant != "" {
+		tenant, err := newAzureTenant(*opts.Azure.Environment, *opts.Azure.Tenant, opts.Azure.Subscription, opts.Azure.ManagementGroup, nil)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	for _, tenantConfig := range opts.Tenants {
+		tenant, err := newAzureTenant(*opts.Azure.Environment, tenantConfig.ID, tenantConfig.Subscriptions, tenantConfig.ManagementGroups, &tenantConfig)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, nil
+}
+
+// getAzureTenant returns the tenant with the tenant id (empty = first tenant) or nil if the tenant doesn't exist
+func getAzureTenant(tenants []*AzureTenant, tenantID string) *AzureTenant {
+	for _, tenant := range tenants {
+		if tenantID == "" || strings.EqualFold(tenant.TenantID, tenantID) {
+			return tenant
+		}
+	}
+
+	return nil
+}
+
+// newAzureTenant creates a tenant, the credential is created from tenantConfig (nil = process env)
+func newAzureTenant(cloudName, tenantID string, subscriptions, managementGroups []string, tenantConfig *config.TenantConfig) (*AzureTenant, error) {
+	// go-common client is only used for the client options of the Azure environment
+	armClient, err := armclient.NewArmClientWithCloudName(cloudName, log.StandardLogger())
+	if err != nil {
+		return nil, err
+	}
+	armClient.SetUserAgent(UserAgent + gitTag)
+
+	var cred azcore.TokenCredential
+	if tenantConfig != nil {
+		cred, err = newAzureTenantCredential(*tenantConfig, *armClient.NewAzCoreClientOptions())
+	} else {
+		cred, err = commonAzidentity.NewAzDefaultCredential(armClient.NewAzCoreClientOptions())
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`unable to create credentials for tenant "%v": %w`, tenantID, err)
+	}
+
+	return &AzureTenant{
+		TenantID:         strings.ToLower(tenantID),
+		Client:           newAzureClient(armClient, cred, subscriptions),
+		cloudName:        cloudName,
+		managementGroups: managementGroups,
+	}, nil
+}
+
+// newAzureTenantCredential creates the credential of a tenant from the config file
+func newAzureTenantCredential(tenantConfig config.TenantConfig, clientOptions azcore.ClientOptions) (azcore.TokenCredential, error) {
+	credentials := tenantConfig.Credentials
+
+	switch strings.ToLower(credentials.Type) {
+	case config.TenantCredentialsAzCli:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: tenantConfig.ID,
+		})
+	case config.TenantCredentialsWorkloadIdentity:
+		// token file is rotated, read it for each new token
+		tokenFile := credentials.FederatedTokenFile
+		return azidentity.NewClientAssertionCredential(tenantConfig.ID, credentials.ClientID, func(ctx context.Context) (string, error) {
+			content, err := os.ReadFile(tokenFile) // #nosec G304 path is set by the user
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(content)), nil
+		}, &azidentity.ClientAssertionCredentialOptions{ClientOptions: clientOptions})
+	case config.TenantCredentialsManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if credentials.ClientID != "" {
+			options.ID = azidentity.ClientID(credentials.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case config.TenantCredentialsClientSecret:
+		clientSecret, err := credentials.Secret()
+		if err != nil {
+			return nil, err
+		}
+		return azidentity.NewClientSecretCredential(tenantConfig.ID, credentials.ClientID, clientSecret, &azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case config.TenantCredentialsClientCertificate:
+		content, err := os.ReadFile(credentials.ClientCertificatePath) // #nosec G304 path is set by the user
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client certificate: %w", err)
+		}
+
+		certs, key, err := azidentity.ParseCertificates(content, []byte(credentials.ClientCertificatePassword))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client certificate: %w", err)
+		}
+		return azidentity.NewClientCertificateCredential(tenantConfig.ID, credentials.ClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+	default:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      tenantConfig.ID,
+		})
+	}
+}
+
+// MsGraphClient returns the MS Graph client of the tenant (created on first use)
+func (t *AzureTenant) MsGraphClient() (*MsGraphClient, error) {
+	t.msGraphLock.Lock()
+	defer t.msGraphLock.Unlock()
+
+	if t.msGraphClient == nil {
+		client, err := newMsGraphClient(t.cloudName, t.Client.GetCred())
+		if err != nil {
+			return nil, fmt.Errorf(`unable to create MS Graph client for tenant "%v": %w`, t.TenantID, err)
+		}
+
+		t.msGraphClient = client
+	}
+
+	return t.msGraphClient, nil
+}
+
+// SubscriptionTenantID returns the tenant id of a subscription (eg. customer tenant for Lighthouse subscriptions)
+func (t *AzureTenant) SubscriptionTenantID(subscription *armsubscriptions.Subscription) string {
+	if subscription.TenantID != nil && *subscription.TenantID != "" {
+		return to.StringLower(subscription.TenantID)
+	}
+
+	return t.TenantID
+}
+
+// ManagementGroup returns the management group (direct parent) of a subscription,
+// empty if the subscriptions of the tenant are not selected by management groups
+func (t *AzureTenant) ManagementGroup(subscriptionID string) string {
+	t.managementGroupLock.RLock()
+	defer t.managementGroupLock.RUnlock()
+
+	return t.managementGroupSubscriptions[strings.ToLower(subscriptionID)]
+}
+
+// ManagementGroups returns the configured management groups of the tenant
+func (t *AzureTenant) ManagementGroups() []string {
+	return t.managementGroups
+}
+
+// refreshManagementGroupSubscriptions resolves the subscriptions of the management groups of the tenant (recursive),
+// returns subscription id -> management group (direct parent) or nil if the tenant has no management groups
+func (t *AzureTenant) refreshManagementGroupSubscriptions(ctx context.Context) (map[string]string, error) {
+	if len(t.managementGroups) == 0 {
+		return nil, nil
+	}
+
+	client, err := armmanagementgroups.NewClient(t.Client.GetCred(), t.Client.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := map[string]string{}
+	for _, managementGroup := range t.managementGroups {
+		pager := client.NewGetDescendantsPager(managementGroup, nil)
+
+		for pager.More() {
+			result, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf(`unable to resolve management group "%v": %w`, managementGroup, err)
+			}
+
+			for _, descendant := range result.Value {
+				// only subscriptions, management groups are resolved by the api (all descendants)
+				if !strings.HasPrefix(to.StringLower(descendant.ID), "/subscriptions/") {
+					continue
+				}
+
+				parentManagementGroup := strings.ToLower(managementGroup)
+				if descendant.Properties != nil && descendant.Properties.Parent != nil && descendant.Properties.Parent.ID != nil {
+					parentID := to.StringLower(descendant.Properties.Parent.ID)
+					parentManagementGroup = parentID[strings.LastIndex(parentID, "/")+1:]
+				}
+
+				subscriptions[to.StringLower(descendant.Name)] = parentManagementGroup
+			}
+		}
+	}
+
+	t.managementGroupLock.Lock()
+	t.managementGroupSubscriptions = subscriptions
+	t.managementGroupLock.Unlock()
+
+	return subscriptions, nil
+}
+
+// NewAzureSubscriptionsIterator creates an iterator for the subscriptions of the tenants (optional filtered by subscription ids)
+func NewAzureSubscriptionsIterator(tenants []*AzureTenant, subscriptionID ...string) *AzureSubscriptionsIterator {
+	return &AzureSubscriptionsIterator{
+		tenants:            tenants,
+		subscriptionFilter: subscriptionID,
+		concurrency:        armclient.IteratorDefaultConcurrency,
+	}
+}
+
+// NewAzureSubscriptionsIteratorForCollector creates an iterator for the subscriptions of the tenants
+// narrowed by the subscriptions and include/exclude filters of the collector config
+func NewAzureSubscriptionsIteratorForCollector(tenants []*AzureTenant, collectorConfig config.CollectorConfig) *AzureSubscriptionsIterator {
+	iterator := NewAzureSubscriptionsIterator(tenants, collectorConfig.Subscriptions...)
+	iterator.collectorConfig = &collectorConfig
+	return iterator
+}
+
+// ListSubscriptions returns the subscriptions of all tenants, subscriptions visible in multiple tenants are only returned once
+// (if the subscriptions of a tenant cannot be listed the other tenants are returned with the error)
+func (i *AzureSubscriptionsIterator) ListSubscriptions() ([]AzureTenantSubscription, error) {
+	var listErr error
+	list := []AzureTenantSubscription{}
+	subscriptionIDs := map[string]bool{}
+
+	for _, tenant := range i.tenants {
+		managementGroupSubscriptions, err := tenant.refreshManagementGroupSubscriptions(context.Background())
+		if err != nil {
+			if listErr == nil {
+				listErr = fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
+			} else {
+				log.WithField("tenantID", tenant.TenantID).Error(err)
+			}
+			continue
+		}
+
+		subscriptions, err := tenant.Client.ListCachedSubscriptionsWithFilter(context.Background(), i.subscriptionFilter...)
+		if err != nil {
+			if listErr == nil {
+				listErr = fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
+			} else {
+				log.WithField("tenantID", tenant.TenantID).Error(err)
+			}
+			continue
+		}
+
+		tenantList := []AzureTenantSubscription{}
+		for _, subscription := range subscriptions {
+			subscriptionID := to.StringLower(subscription.SubscriptionID)
+			if subscriptionIDs[subscriptionID] {
+				continue
+			}
+
+			if managementGroupSubscriptions != nil {
+				if _, exists := managementGroupSubscriptions[subscriptionID]; !exists {
+					continue
+				}
+			}
+
+			if i.collectorConfig != nil && !i.collectorConfig.MatchesSubscription(subscriptionID, to.String(subscription.DisplayName), subscription.Tags) {
+				continue
+			}
+			subscriptionIDs[subscriptionID] = true
+
+			tenantList = append(tenantList, AzureTenantSubscription{Tenant: tenant, Subscription: subscription})
+		}
+
+		sort.Slice(tenantList, func(a, b int) bool {
+			return to.String(tenantList[a].Subscription.SubscriptionID) < to.String(tenantList[b].Subscription.SubscriptionID)
+		})
+		list = append(list, tenantList...)
+	}
+
+	return list, listErr
+}
+
+// ForEach runs callback for each subscription of all tenants without concurrency
+func (i *AzureSubscriptionsIterator) ForEach(logger *log.Entry, callback func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry)) error {
+	list, err := i.ListSubscriptions()
+
+	for _, row := range list {
+		callback(row.Tenant, row.Subscription, i.subscriptionLogger(logger, row))
+	}
+
+	return err
+}
+
+// ForEachAsync runs callback for each subscription of all tenants with concurrency,
+// panics are collected and passed after all subscriptions are processed (same as go-common SubscriptionsIterator)
+func (i *AzureSubscriptionsIterator) ForEachAsync(logger *log.Entry, callback func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry)) error {
+	list, err := i.ListSubscriptions()
+
+	panicList := []string{}
+	panicLock := sync.Mutex{}
+	wg := sizedwaitgroup.New(i.concurrency)
+
+	for _, row := range list {
+		wg.Add()
+
+		go func(row AzureTenantSubscription) {
+			defer wg.Done()
+			contextLogger := i.subscriptionLogger(logger, row)
+
+			defer func() {
+				if r := recover(); r != nil {
+					message := fmt.Sprintf("%v", r)
+					if entry, ok := r.(*log.Entry); ok {
+						message = entry.Message
+					}
+
+					contextLogger.Errorf("panic: %v", message)
+					panicLock.Lock()
+					panicList = append(panicList, message)
+					panicLock.Unlock()
+				}
+			}()
+
+			callback(row.Tenant, row.Subscription, contextLogger)
+		}(row)
+	}
+
+	wg.Wait()
+
+	if len(panicList) >= 1 {
+		panic("caught panics while processing AzureSubscriptionsIterator.ForEachAsync: \n" + strings.Join(panicList, "\n-------------------------------------------------------------------------------\n"))
+	}
+
+	return err
+}
+
+func (i *AzureSubscriptionsIterator) subscriptionLogger(logger *log.Entry, row AzureTenantSubscription) *log.Entry {
+	return logger.WithFields(log.Fields{
+		"tenantID":         row.Tenant.TenantID,
+		"subscriptionID":   to.String(row.Subscription.SubscriptionID),
+		"subscriptionName": to.String(row.Subscription.DisplayName),
+	})
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/remeh/sizedwaitgroup"
+	log "github.com/sirupsen/logrus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+)
+
+const (
+	// collectorPanicThreshold is the number of consecutive panicking runs after which the exporter is stopped
+	collectorPanicThreshold = 5
+)
+
+type (
+	// Collector runs the processor of a collector and sets the metrics of its metric lists after each run,
+	// the metrics are registered in the registry of the collector
+	Collector struct {
+		Name string
+
+		context context.Context
+		logger  *log.Entry
+
+		scrapeTime     time.Duration
+		sleepTime      time.Duration
+		nextScrapeTime *time.Time
+
+		cache               *collectorCache
+		cacheRestoreEnabled bool
+
+		panicCounter int
+
+		// lock is held while the metrics are set, gathering waits for it to avoid partially set metrics
+		lock     sync.RWMutex
+		registry *prometheus.Registry
+		metrics  *CollectorMetrics
+
+		waitGroup *sizedwaitgroup.SizedWaitGroup
+
+		processor CollectorProcessorInterface
+	}
+
+	// CollectorProcessorInterface is implemented by the processors of the collectors
+	CollectorProcessorInterface interface {
+		Setup(collector *Collector)
+		Reset()
+		Collect(callback chan<- func())
+	}
+
+	// CollectorMetrics are the metric lists of a collector (stored in the cache with their expiry)
+	CollectorMetrics struct {
+		Expiry *time.Time                      `json:"expiry"`
+		List   map[string]*CollectorMetricList `json:"metrics"`
+	}
+
+	// CollectorMetricList collects the metrics of a metric vec during a run
+	CollectorMetricList struct {
+		*prometheusCommon.MetricList
+
+		vec   interface{}
+		reset bool
+	}
+
+	collectorCache struct {
+		raw string
+
+		// path of file caches
+		path string
+
+		// container, blob and client of azblob caches
+		container string
+		blob      string
+		client    *azblob.Client
+	}
+)
+
+func newCollector(ctx context.Context, name string, scrapeTime time.Duration, registry *prometheus.Registry, processor CollectorProcessorInterface) *Collector {
+	waitGroup := sizedwaitgroup.New(-1)
+
+	c := &Collector{
+		Name:                name,
+		context:             ctx,
+		logger:              log.WithField("collector", name),
+		scrapeTime:          scrapeTime,
+		sleepTime:           scrapeTime,
+		cacheRestoreEnabled: true,
+		registry:            registry,
+		metrics:             newCollectorMetrics(),
+		waitGroup:           &waitGroup,
+		processor:           processor,
+	}
+	processor.Setup(c)
+
+	return c
+}
+
+func newCollectorMetrics() *CollectorMetrics {
+	return &CollectorMetrics{
+		List: map[string]*CollectorMetricList{},
+	}
+}
+
+// RegisterMetricList registers the metric vec in the registry of the collector, the metric list is set to the vec
+// after each run (vec is reset before if reset is true)
+func (c *Collector) RegisterMetricList(name string, vec interface{}, reset bool) *CollectorMetricList {
+	switch vec := vec.(type) {
+	case *prometheus.GaugeVec:
+		c.registry.MustRegister(vec)
+	case *prometheus.HistogramVec:
+		c.registry.MustRegister(vec)
+	case *prometheus.SummaryVec:
+		c.registry.MustRegister(vec)
+	default:
+		panic(`not allowed prometheus metric vec found`)
+	}
+
+	c.metrics.List[name] = &CollectorMetricList{
+		MetricList: prometheusCommon.NewMetricsList(),
+		vec:        vec,
+		reset:      reset,
+	}
+
+	return c.metrics.List[name]
+}
+
+// GetMetricList returns a registered metric list
+func (c *Collector) GetMetricList(name string) *CollectorMetricList {
+	return c.metrics.List[name]
+}
+
+// SetCache sets the cache of the metric lists (path, file://path or azblob://storageaccount.blob.core.windows.net/container/blob),
+// the metrics are restored from the cache on the first run if they are not expired yet
+func (c *Collector) SetCache(cache *string) {
+	if cache == nil {
+		c.cache = nil
+		return
+	}
+
+	c.cache = &collectorCache{raw: *cache}
+
+	switch {
+	case strings.HasPrefix(*cache, "azblob://"):
+		cacheUrl, err := url.Parse(*cache)
+		if err != nil {
+			c.logger.Panic(err)
+		}
+
+		pathParts := strings.SplitN(strings.TrimPrefix(cacheUrl.Path, "/"), "/", 2)
+		if len(pathParts) < 2 {
+			c.logger.Panicf(`azblob path needs to be specified as azblob://storageaccount.blob.core.windows.net/container/blob, got: %v`, *cache)
+		}
+		c.cache.container = pathParts[0]
+		c.cache.blob = pathParts[1]
+
+		azureClient, err := armclient.NewArmClientFromEnvironment(c.logger.Logger)
+		if err != nil {
+			c.logger.Panic(err)
+		}
+
+		azblobOpts := azblob.ClientOptions{ClientOptions: *azureClient.NewAzCoreClientOptions()}
+		client, err := azblob.NewClient(fmt.Sprintf(`https://%v/`, cacheUrl.Hostname()), azureClient.GetCred(), &azblobOpts)
+		if err != nil {
+			c.logger.Panic(err)
+		}
+		c.cache.client = client
+	default:
+		c.cache.path = strings.TrimPrefix(*cache, "file://")
+	}
+}
+
+// GetNextScrapeTime returns the time of the next run (later than the scrape time if the metrics were restored from the cache)
+func (c *Collector) GetNextScrapeTime() *time.Time {
+	return c.nextScrapeTime
+}
+
+// Gather gathers the metrics of the collector, waits until the metrics of a finished run are set
+func (c *Collector) Gather() ([]*dto.MetricFamily, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.registry.Gather()
+}
+
+// run collects the metrics (or restores them from the cache on the first run) and sets them,
+// returns an error if the processor panicked
+func (c *Collector) run() error {
+	c.sleepTime = c.scrapeTime
+	c.cleanupMetricLists()
+
+	startTime := time.Now()
+	c.logger.Info("starting metrics collection")
+
+	var err error
+	if c.restoreCache() {
+		c.setMetrics(nil)
+	} else {
+		var callbacks []func()
+		callbacks, err = c.collect()
+		c.setMetrics(callbacks)
+		if err == nil {
+			c.saveCache()
+		}
+	}
+	c.cleanupMetricLists()
+
+	nextScrapeTime := time.Now().Add(c.sleepTime)
+	c.nextScrapeTime = &nextScrapeTime
+
+	c.logger.WithFields(log.Fields{
+		"duration": time.Since(startTime).Seconds(),
+		"nextRun":  nextScrapeTime.UTC(),
+	}).Infof("finished metrics collection, next run in %s", c.sleepTime.String())
+
+	return err
+}
+
+// collect runs the processor and returns the callbacks which set the metrics, panics of the processor are
+// returned as error (the exporter is stopped if the collector panics too often in a row)
+func (c *Collector) collect() (callbacks []func(), err error) {
+	callbackChannel := make(chan func())
+
+	go func() {
+		defer close(callbackChannel)
+		defer func() {
+			if r := recover(); r != nil {
+				c.panicCounter++
+				if c.panicCounter > collectorPanicThreshold {
+					panic(r)
+				}
+
+				message := fmt.Sprintf("%v", r)
+				if entry, ok := r.(*log.Entry); ok {
+					message = entry.Message
+				}
+				c.logger.Errorf("panic occurred (panic threshold %v of %v): %v", c.panicCounter, collectorPanicThreshold, message)
+				err = errors.New(message)
+			}
+		}()
+
+		c.processor.Collect(callbackChannel)
+		c.waitGroup.Wait()
+		c.panicCounter = 0
+	}()
+
+	for callback := range callbackChannel {
+		callbacks = append(callbacks, callback)
+	}
+
+	return callbacks, err
+}
+
+// setMetrics resets the metrics and sets them from the callbacks and metric lists
+func (c *Collector) setMetrics(callbacks []func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.processor.Reset()
+
+	for _, metric := range c.metrics.List {
+		if metric.reset {
+			switch vec := metric.vec.(type) {
+			case *prometheus.GaugeVec:
+				vec.Reset()
+			case *prometheus.HistogramVec:
+				vec.Reset()
+			case *prometheus.SummaryVec:
+				vec.Reset()
+			}
+		}
+	}
+
+	for _, callback := range callbacks {
+		callback()
+	}
+
+	for _, metric := range c.metrics.List {
+		switch vec := metric.vec.(type) {
+		case *prometheus.GaugeVec:
+			metric.GaugeSet(vec)
+		case *prometheus.HistogramVec:
+			metric.HistogramSet(vec)
+		case *prometheus.SummaryVec:
+			metric.SummarySet(vec)
+		}
+	}
+}
+
+func (c *Collector) cleanupMetricLists() {
+	for _, metric := range c.metrics.List {
+		metric.MetricList.Reset()
+	}
+}
+
+// restoreCache restores the metric lists from the cache (first run only), returns true if the metrics were restored
+func (c *Collector) restoreCache() bool {
+	if c.cache == nil || !c.cacheRestoreEnabled {
+		return false
+	}
+	c.cacheRestoreEnabled = false
+
+	content, exists := c.cache.read(c.context)
+	if !exists {
+		return false
+	}
+
+	c.logger.Infof(`trying to restore state from cache: %s`, c.cache.raw)
+
+	restoredMetrics := newCollectorMetrics()
+	if err := json.Unmarshal(content, restoredMetrics); err != nil {
+		c.logger.Warnf(`unable to decode cache: %v`, err.Error())
+		return false
+	}
+
+	if restoredMetrics.Expiry == nil || !restoredMetrics.Expiry.After(time.Now()) {
+		c.logger.Infof(`ignoring cached state, already expired`)
+		return false
+	}
+
+	c.metrics.Expiry = restoredMetrics.Expiry
+	for name, restoredMetricList := range restoredMetrics.List {
+		if restoredMetricList == nil || restoredMetricList.MetricList == nil || restoredMetricList.List == nil {
+			continue
+		}
+
+		if metricList, exists := c.metrics.List[name]; exists {
+			metricList.List = restoredMetricList.List
+			metricList.Init()
+		}
+	}
+
+	c.sleepTime = time.Until(*c.metrics.Expiry) + 1*time.Minute
+	c.logger.Infof(`restored state from cache: "%s" (expiring %s)`, c.cache.raw, c.metrics.Expiry.UTC().String())
+
+	return true
+}
+
+// saveCache stores the metric lists in the cache, they expire with the next run
+func (c *Collector) saveCache() {
+	if c.cache == nil {
+		return
+	}
+
+	expiry := time.Now().Add(c.sleepTime)
+	c.metrics.Expiry = &expiry
+
+	jsonData, err := json.Marshal(c.metrics)
+	if err != nil {
+		c.logger.Errorf(`unable to encode cache: %v`, err)
+		return
+	}
+
+	if err := c.cache.store(c.context, jsonData); err != nil {
+		c.logger.Errorf(`unable to save state to cache: %v`, err)
+		return
+	}
+
+	c.logger.Infof(`saved state to cache: %s (expiring %s)`, c.cache.raw, c.metrics.Expiry.UTC().String())
+}
+
+func (cache *collectorCache) read(ctx context.Context) ([]byte, bool) {
+	if cache.client != nil {
+		response, err := cache.client.DownloadStream(ctx, cache.container, cache.blob, nil)
+		if err != nil {
+			return nil, false
+		}
+		defer response.Body.Close() // nolint:errcheck
+
+		content, err := io.ReadAll(response.Body)
+		return content, err == nil
+	}
+
+	content, err := os.ReadFile(cache.path) // #nosec inside container
+	return content, err == nil
+}
+
+func (cache *collectorCache) store(ctx context.Context, content []byte) error {
+	if cache.client != nil {
+		_, err := cache.client.UploadBuffer(ctx, cache.container, cache.blob, content, nil)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0700); err != nil {
+		return err
+	}
+
+	return cacheSaveToPath(cache.path, json.RawMessage(content))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// CollectorSubscriptionStatus tracks the errors of the Azure API calls of a collector run for one subscription
+	CollectorSubscriptionStatus struct {
+		collector      *Collector
+		subscriptionID string
+		logger         *log.Entry
+
+		lock       sync.Mutex
+		errorCount int
+	}
+)
+
+var (
+	prometheusCollectorStatus struct {
+		errors      *prometheus.CounterVec
+		lastSuccess *prometheus.GaugeVec
+	}
+)
+
+func initCollectorStatusMetrics() {
+	prometheusCollectorStatus.errors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_collector_errors_total",
+			Help: "Azure ResourceManager collector errors of Azure API calls",
+		},
+		[]string{
+			"collector",
+			"subscriptionID",
+			"api",
+			"code",
+		},
+	)
+	prometheus.MustRegister(prometheusCollectorStatus.errors)
+
+	prometheusCollectorStatus.lastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_collector_last_success_timestamp_seconds",
+			Help: "Azure ResourceManager collector timestamp of the last collection without errors",
+		},
+		[]string{
+			"collector",
+			"subscriptionID",
+		},
+	)
+	prometheus.MustRegister(prometheusCollectorStatus.lastSuccess)
+}
+
+func newCollectorSubscriptionStatus(c *Collector, subscriptionID string, logger *log.Entry) *CollectorSubscriptionStatus {
+	if managedCollector := collectorManager.GetByCollector(c); managedCollector != nil && subscriptionID != "" {
+		managedCollector.addRunSubscription(subscriptionID)
+	}
+
+	return &CollectorSubscriptionStatus{
+		collector:      c,
+		subscriptionID: subscriptionID,
+		logger:         logger,
+	}
+}
+
+// Check logs and counts the error of an Azure API call, returns false if there was an error
+func (s *CollectorSubscriptionStatus) Check(api string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	collectorErrorHandle(s.logger, s.collector, s.subscriptionID, api, err)
+
+	s.lock.Lock()
+	s.errorCount++
+	s.lock.Unlock()
+
+	return false
+}
+
+// Finish sets the last success timestamp if all Azure API calls of the subscription were successful
+func (s *CollectorSubscriptionStatus) Finish() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.errorCount == 0 {
+		prometheusCollectorStatus.lastSuccess.With(prometheus.Labels{
+			"collector":      s.collector.Name,
+			"subscriptionID": s.subscriptionID,
+		}).SetToCurrentTime()
+	}
+}
+
+// collectorErrorHandle logs and counts the error of an Azure API call, the error is also shown in the collector status
+// (errors without subscription are affecting the whole collector and are marking the collector run as failed)
+func collectorErrorHandle(logger *log.Entry, c *Collector, subscriptionID, api string, err error) {
+	code := collectorErrorCode(err)
+
+	if managedCollector := collectorManager.GetByCollector(c); managedCollector != nil {
+		managedCollector.addRunError(subscriptionID, api, err)
+	}
+
+	logger.WithFields(log.Fields{
+		"api":  api,
+		"code": code,
+	}).Error(err)
+
+	prometheusCollectorStatus.errors.With(prometheus.Labels{
+		"collector":      c.Name,
+		"subscriptionID": subscriptionID,
+		"api":            api,
+		"code":           code,
+	}).Inc()
+}
+
+// collectorWarningHandle logs and counts the error of an Azure API call which only affects a part of the metrics
+// (eg. details of a single resource), the collector run isn't marked as failed
+func collectorWarningHandle(logger *log.Entry, c *Collector, api string, err error) {
+	code := collectorErrorCode(err)
+
+	logger.WithFields(log.Fields{
+		"api":  api,
+		"code": code,
+	}).Warn(err)
+
+	prometheusCollectorStatus.errors.With(prometheus.Labels{
+		"collector":      c.Name,
+		"subscriptionID": "",
+		"api":            api,
+		"code":           code,
+	}).Inc()
+}
+
+// collectorErrorCode returns the http status code of Azure API errors
+func collectorErrorCode(err error) string {
+	var responseErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &responseErr):
+		return strconv.Itoa(responseErr.StatusCode)
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
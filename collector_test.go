@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testCollectorProcessor struct {
+	CollectorProcessor
+
+	gauge    *prometheus.GaugeVec
+	value    float64
+	panicMsg string
+}
+
+func (p *testCollectorProcessor) Setup(collector *Collector) {
+	p.CollectorProcessor.Setup(collector)
+
+	p.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_value"}, []string{"name"})
+	p.Collector.RegisterMetricList("value", p.gauge, true)
+}
+
+func (p *testCollectorProcessor) Reset() {}
+
+func (p *testCollectorProcessor) Collect(callback chan<- func()) {
+	if p.panicMsg != "" {
+		panic(p.panicMsg)
+	}
+
+	p.Collector.GetMetricList("value").Add(prometheus.Labels{"name": "test"}, p.value)
+}
+
+func TestCollectorRun(t *testing.T) {
+	processor := &testCollectorProcessor{value: 10}
+	c := newCollector(context.Background(), "test", time.Minute, prometheus.NewRegistry(), processor)
+
+	if err := c.run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value := testutil.ToFloat64(processor.gauge.WithLabelValues("test")); value != 10 {
+		t.Errorf("expected 10, got %v", value)
+	}
+
+	if nextScrapeTime := c.GetNextScrapeTime(); nextScrapeTime == nil || time.Until(*nextScrapeTime) > time.Minute {
+		t.Errorf("expected next scrape time within scrape time, got %v", nextScrapeTime)
+	}
+
+	processor.panicMsg = "failed"
+	if err := c.run(); err == nil || err.Error() != "failed" {
+		t.Errorf("expected panic as error, got %v", err)
+	}
+
+	if count := testutil.CollectAndCount(processor.gauge); count != 0 {
+		t.Errorf("expected metrics to be reset after panic, got %v", count)
+	}
+}
+
+func TestCollectorRunCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "cache", "test.json")
+
+	processor := &testCollectorProcessor{value: 10}
+	c := newCollector(context.Background(), "test", time.Hour, prometheus.NewRegistry(), processor)
+	c.SetCache(&cachePath)
+	if err := c.run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// metrics are restored from the cache on the first run (processor would panic)
+	restoredProcessor := &testCollectorProcessor{panicMsg: "not restored"}
+	restored := newCollector(context.Background(), "test", time.Hour, prometheus.NewRegistry(), restoredProcessor)
+	restored.SetCache(&cachePath)
+	if err := restored.run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value := testutil.ToFloat64(restoredProcessor.gauge.WithLabelValues("test")); value != 10 {
+		t.Errorf("expected restored value 10, got %v", value)
+	}
+
+	// next run is after the expiry of the cache
+	if nextScrapeTime := restored.GetNextScrapeTime(); nextScrapeTime == nil || time.Until(*nextScrapeTime) < 59*time.Minute {
+		t.Errorf("expected next scrape time after the cache expiry, got %v", nextScrapeTime)
+	}
+
+	// second run is collected again
+	if err := restored.run(); err == nil {
+		t.Error("expected collect after first run")
+	}
+}
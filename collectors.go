@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/remeh/sizedwaitgroup"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+type (
+	// CollectorDefinition describes a collector which is created from the current config
+	CollectorDefinition struct {
+		Name string
+
+		// ScrapeTime returns the scrape time of the collector, collector is disabled if nil or zero
+		ScrapeTime func(o *config.Opts) *time.Duration
+
+		// Config returns collector specific settings, changes will recreate the collector on reload
+		Config func(o *config.Opts) interface{}
+
+		// Init is called before the collector is created (eg. for additional connections)
+		Init func(c *RuntimeConfig)
+
+		// Processor creates a new processor for the collector
+		Processor func() CollectorProcessorInterface
+	}
+
+	// CollectorManager manages the running collectors
+	CollectorManager struct {
+		lock        sync.RWMutex
+		collectors  map[string]*ManagedCollector
+		initialized bool
+	}
+
+	// ManagedCollector is a collector with its own prometheus registry which can be stopped and replaced on reload
+	ManagedCollector struct {
+		Definition *CollectorDefinition
+		Collector  *Collector
+		Registry   *prometheus.Registry
+		ScrapeTime time.Duration
+		ConfigHash string
+
+		// runConfig is the config snapshot used by the current run
+		runConfig atomic.Pointer[RuntimeConfig]
+
+		runLock sync.Mutex
+
+		statusLock sync.RWMutex
+		status     CollectorStatus
+		runErrors  []string
+		runFailed  bool
+
+		// runSubscriptions are the subscriptions of the current run (value is true if the subscription had errors)
+		runSubscriptions map[string]bool
+
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	// CollectorStatus is the status of a collector and its last run
+	CollectorStatus struct {
+		Name                string     `json:"name"`
+		Enabled             bool       `json:"enabled"`
+		Interval            string     `json:"interval,omitempty"`
+		Ready               bool       `json:"ready"`
+		Running             bool       `json:"running"`
+		LastRunStart        *time.Time `json:"lastRunStart,omitempty"`
+		LastRunEnd          *time.Time `json:"lastRunEnd,omitempty"`
+		LastRunDuration     *float64   `json:"lastRunDurationSeconds,omitempty"`
+		LastRunSuccessful   bool       `json:"lastRunSuccessful"`
+		Subscriptions       int        `json:"subscriptions"`
+		FailedSubscriptions int        `json:"failedSubscriptions"`
+		LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+		Error               string     `json:"error,omitempty"`
+		NextRun             *time.Time `json:"nextRun,omitempty"`
+	}
+
+	// CollectorProcessor is the base of all processors and provides the config snapshot of the collector run
+	CollectorProcessor struct {
+		Collector        *Collector
+		managedCollector *ManagedCollector
+	}
+)
+
+var (
+	collectorDefinitions = []*CollectorDefinition{
+		{
+			Name:       "General",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeGeneral },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmGeneral{} },
+		},
+		{
+			Name:       "Resource",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeResource },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmResources{} },
+		},
+		{
+			Name:       "Quota",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeQuota },
+			Config:     func(o *config.Opts) interface{} { return o.Quota },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmQuota{} },
+		},
+		{
+			Name:       "Costs",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeCosts },
+			Config:     func(o *config.Opts) interface{} { return o.Costs },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmCosts{} },
+		},
+		{
+			Name:       "CostsForecast",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeCostsForecast },
+			Config:     func(o *config.Opts) interface{} { return o.Costs },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmCostsForecast{} },
+		},
+		{
+			Name:       "Reservation",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeReservation },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmReservation{} },
+		},
+		{
+			Name:       "Security",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeSecurity },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmSecurity{} },
+		},
+		{
+			Name:       "ResourceHealth",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeResourceHealth },
+			Config:     func(o *config.Opts) interface{} { return o.ResourceHealth },
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmHealth{} },
+		},
+		{
+			Name:       "IAM",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeIam },
+			Init:       initMsGraphConnection,
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorAzureRmIam{} },
+		},
+		{
+			Name:       "GraphApps",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return o.Scrape.TimeGraph },
+			Config:     func(o *config.Opts) interface{} { return o.Graph },
+			Init:       initMsGraphConnection,
+			Processor:  func() CollectorProcessorInterface { return &MetricsCollectorGraphApps{} },
+		},
+		{
+			Name: "Portscan",
+			ScrapeTime: func(o *config.Opts) *time.Duration {
+				if !o.Portscan.Enabled {
+					return nil
+				}
+				return o.Scrape.TimePortscan
+			},
+			Config:    func(o *config.Opts) interface{} { return o.Portscan },
+			Processor: func() CollectorProcessorInterface { return &MetricsCollectorPortscanner{} },
+		},
+	}
+
+	collectorManager = NewCollectorManager()
+)
+
+func collectorNames() (list []string) {
+	for _, definition := range collectorDefinitions {
+		list = append(list, definition.Name)
+	}
+	return
+}
+
+func collectorNameIsValid(name string) bool {
+	for _, definition := range collectorDefinitions {
+		if strings.EqualFold(definition.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *CollectorDefinition) scrapeTime(o *config.Opts) time.Duration {
+	if scrapeTime := d.ScrapeTime(o); scrapeTime != nil {
+		return *scrapeTime
+	}
+	return 0
+}
+
+// configHash returns a fingerprint of all settings used by the collector
+func (d *CollectorDefinition) configHash(o *config.Opts) string {
+	settings := map[string]interface{}{
+		"scrapeTime": d.scrapeTime(o),
+		"azure":      o.Azure,
+		"tenants":    o.Tenants,
+		"cache":      o.Cache,
+		"collector":  o.GetCollectorConfig(d.Name),
+	}
+
+	if d.Config != nil {
+		settings["config"] = d.Config(o)
+	}
+
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return string(jsonData)
+}
+
+func NewCollectorManager() *CollectorManager {
+	return &CollectorManager{
+		collectors: map[string]*ManagedCollector{},
+	}
+}
+
+// Apply starts, replaces and stops collectors according to the config
+// collectors with unchanged settings are kept running, if a collector cannot be created nothing is changed
+func (m *CollectorManager) Apply(runtimeConfig *RuntimeConfig) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	createdCollectors := []*ManagedCollector{}
+	removedCollectors := []*ManagedCollector{}
+
+	for _, definition := range collectorDefinitions {
+		contextLogger := log.WithField("collector", definition.Name)
+		scrapeTime := definition.scrapeTime(runtimeConfig.Opts)
+		currentCollector := m.collectors[definition.Name]
+
+		if scrapeTime.Seconds() <= 0 {
+			if currentCollector != nil {
+				removedCollectors = append(removedCollectors, currentCollector)
+			}
+
+			if currentCollector != nil || !m.initialized {
+				contextLogger.Infof("collector disabled")
+			}
+			continue
+		}
+
+		configHash := definition.configHash(runtimeConfig.Opts)
+		if currentCollector != nil && currentCollector.ConfigHash == configHash {
+			// settings not changed, keep collector running
+			continue
+		}
+
+		managedCollector, err := newManagedCollector(definition, runtimeConfig, scrapeTime, configHash)
+		if err != nil {
+			for _, createdCollector := range createdCollectors {
+				createdCollector.Stop()
+			}
+			return fmt.Errorf(`unable to create collector "%v": %w`, definition.Name, err)
+		}
+		createdCollectors = append(createdCollectors, managedCollector)
+
+		if currentCollector != nil {
+			removedCollectors = append(removedCollectors, currentCollector)
+		}
+	}
+
+	for _, managedCollector := range removedCollectors {
+		managedCollector.Stop()
+		delete(m.collectors, managedCollector.Definition.Name)
+		log.WithField("collector", managedCollector.Definition.Name).Infof("collector stopped")
+	}
+
+	for _, managedCollector := range createdCollectors {
+		m.collectors[managedCollector.Definition.Name] = managedCollector
+		managedCollector.Start()
+		log.WithField("collector", managedCollector.Definition.Name).Infof("collector started (scrape time %v)", managedCollector.ScrapeTime.String())
+	}
+
+	m.initialized = true
+
+	return nil
+}
+
+// Get returns a running collector by name (case-insensitive)
+func (m *CollectorManager) Get(name string) *ManagedCollector {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for collectorName, managedCollector := range m.collectors {
+		if strings.EqualFold(collectorName, name) {
+			return managedCollector
+		}
+	}
+
+	return nil
+}
+
+// GetByCollector returns the running collector of a collector
+func (m *CollectorManager) GetByCollector(c *Collector) *ManagedCollector {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, managedCollector := range m.collectors {
+		if managedCollector.Collector == c {
+			return managedCollector
+		}
+	}
+
+	return nil
+}
+
+// List returns all running collectors (ordered by name)
+func (m *CollectorManager) List() []*ManagedCollector {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	list := []*ManagedCollector{}
+	for _, managedCollector := range m.collectors {
+		list = append(list, managedCollector)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Definition.Name < list[j].Definition.Name
+	})
+
+	return list
+}
+
+// Status returns the status of all collectors (including disabled collectors)
+func (m *CollectorManager) Status() []CollectorStatus {
+	list := []CollectorStatus{}
+	for _, definition := range collectorDefinitions {
+		if managedCollector := m.Get(definition.Name); managedCollector != nil {
+			list = append(list, managedCollector.Status())
+		} else {
+			list = append(list, CollectorStatus{Name: definition.Name})
+		}
+	}
+
+	return list
+}
+
+// Ready checks if all required collectors finished a successful run
+// (all enabled collectors if no collectors are required), returns the list of collectors which are not ready
+func (m *CollectorManager) Ready(requiredCollectors []string) (bool, []string) {
+	notReady := []string{}
+	for _, status := range m.Status() {
+		if !status.Enabled || status.Ready {
+			continue
+		}
+
+		if len(requiredCollectors) > 0 && !stringInSliceCI(status.Name, requiredCollectors) {
+			continue
+		}
+
+		notReady = append(notReady, status.Name)
+	}
+
+	return len(notReady) == 0, notReady
+}
+
+// RunOnce creates the enabled collectors (or only the selected collectors) and runs them once without scheduling,
+// returns the errors of failed collector runs (including runs with failed subscriptions)
+func (m *CollectorManager) RunOnce(runtimeConfig *RuntimeConfig, selectedCollectors []string) (map[string]error, error) {
+	m.lock.Lock()
+	for _, definition := range collectorDefinitions {
+		if len(selectedCollectors) > 0 && !stringInSliceCI(definition.Name, selectedCollectors) {
+			continue
+		}
+
+		scrapeTime := definition.scrapeTime(runtimeConfig.Opts)
+		if scrapeTime.Seconds() <= 0 {
+			if len(selectedCollectors) > 0 {
+				m.lock.Unlock()
+				return nil, fmt.Errorf(`collector "%v" is disabled`, definition.Name)
+			}
+			continue
+		}
+
+		managedCollector, err := newManagedCollector(definition, runtimeConfig, scrapeTime, definition.configHash(runtimeConfig.Opts))
+		if err != nil {
+			m.lock.Unlock()
+			return nil, fmt.Errorf(`unable to create collector "%v": %w`, definition.Name, err)
+		}
+		m.collectors[definition.Name] = managedCollector
+	}
+	m.initialized = true
+	m.lock.Unlock()
+
+	collectorList := m.List()
+	if len(collectorList) == 0 {
+		return nil, errors.New("no collectors enabled")
+	}
+
+	runErrors := map[string]error{}
+	runErrorsLock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, managedCollector := range collectorList {
+		wg.Add(1)
+		go func(managedCollector *ManagedCollector) {
+			defer wg.Done()
+			if err := managedCollector.Run(); err != nil {
+				runErrorsLock.Lock()
+				runErrors[managedCollector.Definition.Name] = err
+				runErrorsLock.Unlock()
+			}
+		}(managedCollector)
+	}
+	wg.Wait()
+
+	return runErrors, nil
+}
+
+// Gather implements prometheus.Gatherer and gathers the default registry and the registries of all running collectors
+func (m *CollectorManager) Gather() ([]*dto.MetricFamily, error) {
+	gatherers := prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		prometheus.GathererFunc(m.GatherCollectors),
+	}
+
+	return gatherers.Gather()
+}
+
+// GatherCollectors gathers the registries of all running collectors (without exporter metrics)
+func (m *CollectorManager) GatherCollectors() ([]*dto.MetricFamily, error) {
+	gatherers := prometheus.Gatherers{}
+	for _, managedCollector := range m.List() {
+		gatherers = append(gatherers, managedCollector.Collector)
+	}
+
+	return gatherers.Gather()
+}
+
+func newManagedCollector(definition *CollectorDefinition, runtimeConfig *RuntimeConfig, scrapeTime time.Duration, configHash string) (managedCollector *ManagedCollector, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case *log.Entry:
+				err = fmt.Errorf("%v", v.Message)
+			default:
+				err = fmt.Errorf("%v", v)
+			}
+		}
+	}()
+
+	if definition.Init != nil {
+		definition.Init(runtimeConfig)
+	}
+
+	managedCollector = &ManagedCollector{
+		Definition: definition,
+		Registry:   prometheus.NewRegistry(),
+		ScrapeTime: scrapeTime,
+		ConfigHash: configHash,
+		status: CollectorStatus{
+			Name:     definition.Name,
+			Enabled:  true,
+			Interval: scrapeTime.String(),
+		},
+	}
+	managedCollector.runConfig.Store(runtimeConfig)
+	managedCollector.ctx, managedCollector.cancel = context.WithCancel(context.Background())
+
+	processor := definition.Processor()
+	if baseProcessor, ok := processor.(interface{ setManagedCollector(*ManagedCollector) }); ok {
+		baseProcessor.setManagedCollector(managedCollector)
+	}
+	managedCollector.Collector = newCollector(managedCollector.ctx, definition.Name, scrapeTime, managedCollector.Registry, processor)
+
+	return managedCollector, nil
+}
+
+// Start schedules the collector in background until it's stopped
+func (c *ManagedCollector) Start() {
+	go func() {
+		// randomize collector start times
+		startupWaitTime := time.Duration((rand.Float64()*5)+5) * time.Second // #nosec:G404 random value only used for startup time
+		c.setNextRun(startupWaitTime)
+		if !c.sleep(startupWaitTime) {
+			return
+		}
+
+		for {
+			c.Run() // nolint:errcheck
+
+			sleepTime := c.ScrapeTime
+			if nextScrapeTime := c.Collector.GetNextScrapeTime(); nextScrapeTime != nil {
+				sleepTime = time.Until(*nextScrapeTime)
+			}
+
+			c.setNextRun(sleepTime)
+			if !c.sleep(sleepTime) {
+				return
+			}
+		}
+	}()
+}
+
+// Run runs the collector and returns the error if the run failed, waits if the collector is already running
+func (c *ManagedCollector) Run() error {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+
+	return c.collect()
+}
+
+// TryRun runs the collector if it's not already running, returns false if the collector is already running
+func (c *ManagedCollector) TryRun() (bool, error) {
+	if !c.runLock.TryLock() {
+		return false, nil
+	}
+	defer c.runLock.Unlock()
+
+	return true, c.collect()
+}
+
+// TryRunAsync starts a run of the collector in background if it's not already running,
+// the returned channel receives the result of the run (nil if the collector is already running)
+func (c *ManagedCollector) TryRunAsync() <-chan error {
+	if !c.runLock.TryLock() {
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		defer c.runLock.Unlock()
+		result <- c.collect()
+	}()
+
+	return result
+}
+
+// collect runs the collector and updates the status, needs the run lock
+func (c *ManagedCollector) collect() error {
+	if c.ctx.Err() != nil {
+		return errors.New("collector was stopped")
+	}
+
+	// use the live config for this run, unless the settings of the collector were changed
+	// (collector is replaced by the config reload then)
+	if runtimeConfig := currentConfig(); runtimeConfig != nil && c.Definition.configHash(runtimeConfig.Opts) == c.ConfigHash {
+		c.runConfig.Store(runtimeConfig)
+	}
+
+	startTime := time.Now()
+	c.statusLock.Lock()
+	c.status.Running = true
+	c.status.LastRunStart = &startTime
+	c.runErrors = []string{}
+	c.runFailed = false
+	c.runSubscriptions = map[string]bool{}
+	c.statusLock.Unlock()
+
+	if err := c.Collector.run(); err != nil {
+		c.statusLock.Lock()
+		c.runErrors = append(c.runErrors, "panic: "+err.Error())
+		c.runFailed = true
+		c.statusLock.Unlock()
+	}
+
+	endTime := time.Now()
+	duration := endTime.Sub(startTime).Seconds()
+
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	c.status.Running = false
+	c.status.LastRunEnd = &endTime
+	c.status.LastRunDuration = &duration
+	failedSubscriptions := c.failedSubscriptionCount()
+	c.status.Subscriptions = len(c.runSubscriptions)
+	c.status.FailedSubscriptions = failedSubscriptions
+	c.status.LastRunSuccessful = !c.runFailed && failedSubscriptions == 0
+	c.status.Error = ""
+	if len(c.runErrors) > 0 {
+		c.status.Error = c.runErrors[0]
+		if len(c.runErrors) > 1 {
+			c.status.Error += fmt.Sprintf(" (and %v more errors)", len(c.runErrors)-1)
+		}
+	}
+
+	// collector is ready if the last run wasn't failed and at least one subscription was collected without errors
+	c.status.Ready = !c.runFailed && (failedSubscriptions == 0 || failedSubscriptions < len(c.runSubscriptions))
+
+	if c.runFailed {
+		return errors.New(c.status.Error)
+	}
+
+	if failedSubscriptions > 0 {
+		return fmt.Errorf("%v of %v subscriptions failed: %v", failedSubscriptions, len(c.runSubscriptions), c.status.Error)
+	}
+
+	c.status.LastSuccess = &endTime
+	return nil
+}
+
+// failedSubscriptionCount returns the number of subscriptions with errors in the current run, needs the status lock
+func (c *ManagedCollector) failedSubscriptionCount() (count int) {
+	for _, failed := range c.runSubscriptions {
+		if failed {
+			count++
+		}
+	}
+	return
+}
+
+// Status returns the current status of the collector
+func (c *ManagedCollector) Status() CollectorStatus {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+
+	return c.status
+}
+
+func (c *ManagedCollector) setNextRun(duration time.Duration) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	nextRun := time.Now().Add(duration)
+	c.status.NextRun = &nextRun
+}
+
+// addRunSubscription adds a subscription to the current run (used for the failed subscription count)
+func (c *ManagedCollector) addRunSubscription(subscriptionID string) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	if _, exists := c.runSubscriptions[subscriptionID]; !exists && c.runSubscriptions != nil {
+		c.runSubscriptions[subscriptionID] = false
+	}
+}
+
+// addRunError adds an error to the current run, errors without subscription are marking the run as failed,
+// errors of subscriptions are marking the subscription as failed
+func (c *ManagedCollector) addRunError(subscriptionID, api string, err error) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	message := fmt.Sprintf("%v: %v", api, err)
+	if subscriptionID != "" {
+		message = fmt.Sprintf("subscription %v: %v", subscriptionID, message)
+		if c.runSubscriptions != nil {
+			c.runSubscriptions[subscriptionID] = true
+		}
+	} else {
+		c.runFailed = true
+	}
+
+	c.runErrors = append(c.runErrors, message)
+}
+
+// RunConfig returns the config snapshot of the current run
+func (c *ManagedCollector) RunConfig() *RuntimeConfig {
+	return c.runConfig.Load()
+}
+
+// Setup sets the collector of the processor, processors are registering their metric lists here
+func (p *CollectorProcessor) Setup(collector *Collector) {
+	p.Collector = collector
+}
+
+// Logger returns the logger of the collector
+func (p *CollectorProcessor) Logger() *log.Entry {
+	return p.Collector.logger
+}
+
+// Context returns the context of the collector, it's cancelled if the collector is stopped
+func (p *CollectorProcessor) Context() context.Context {
+	return p.Collector.context
+}
+
+// WaitGroup returns the wait group of the collector, a run is finished after all goroutines of the wait group are done
+func (p *CollectorProcessor) WaitGroup() *sizedwaitgroup.SizedWaitGroup {
+	return p.Collector.waitGroup
+}
+
+func (p *CollectorProcessor) setManagedCollector(managedCollector *ManagedCollector) {
+	p.managedCollector = managedCollector
+}
+
+// RunConfig returns the config snapshot of the current collector run
+func (p *CollectorProcessor) RunConfig() *RuntimeConfig {
+	if p.managedCollector == nil {
+		return currentConfig()
+	}
+	return p.managedCollector.RunConfig()
+}
+
+// Opts returns the options of the current collector run
+func (p *CollectorProcessor) Opts() *config.Opts {
+	return p.RunConfig().Opts
+}
+
+// AzureTenants returns the tenants of the current collector run
+func (p *CollectorProcessor) AzureTenants() []*AzureTenant {
+	return p.RunConfig().AzureTenants
+}
+
+// SubscriptionsIterator returns the subscription iterator of the collector (narrowed by the subscription filters from the collector config)
+func (p *CollectorProcessor) SubscriptionsIterator() *AzureSubscriptionsIterator {
+	runtimeConfig := p.RunConfig()
+	return NewAzureSubscriptionsIteratorForCollector(runtimeConfig.AzureTenants, runtimeConfig.Opts.GetCollectorConfig(p.Collector.Name))
+}
+
+// Stop stops the collector, a running collection is cancelled
+func (c *ManagedCollector) Stop() {
+	c.cancel()
+}
+
+func (c *ManagedCollector) sleep(duration time.Duration) bool {
+	select {
+	case <-time.After(duration):
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+type testSetupPanicProcessor struct {
+	testCollectorProcessor
+}
+
+func (p *testSetupPanicProcessor) Setup(collector *Collector) {
+	panic("setup failed")
+}
+
+func TestCollectorManagerApply(t *testing.T) {
+	scrapeTime := time.Hour
+	failingScrapeTime := time.Duration(0)
+
+	previousDefinitions := collectorDefinitions
+	defer func() { collectorDefinitions = previousDefinitions }()
+	collectorDefinitions = []*CollectorDefinition{
+		{
+			Name:       "Test",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return &scrapeTime },
+			Processor:  func() CollectorProcessorInterface { return &testCollectorProcessor{} },
+		},
+		{
+			Name:       "Failing",
+			ScrapeTime: func(o *config.Opts) *time.Duration { return &failingScrapeTime },
+			Processor:  func() CollectorProcessorInterface { return &testSetupPanicProcessor{} },
+		},
+	}
+
+	manager := NewCollectorManager()
+	runtimeConfig := &RuntimeConfig{Opts: &config.Opts{}}
+
+	// collector is started
+	if err := manager.Apply(runtimeConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	started := manager.Get("test")
+	if started == nil {
+		t.Fatal("expected collector to be started")
+	}
+	defer started.Stop()
+
+	// unchanged settings are keeping the collector
+	if err := manager.Apply(runtimeConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manager.Get("test") != started {
+		t.Error("expected collector to be kept")
+	}
+
+	// changed settings are replacing the collector
+	scrapeTime = 2 * time.Hour
+	if err := manager.Apply(runtimeConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	replaced := manager.Get("test")
+	if replaced == nil || replaced == started || replaced.ScrapeTime != scrapeTime {
+		t.Fatalf("expected collector to be replaced, got %+v", replaced)
+	}
+	defer replaced.Stop()
+	if started.ctx.Err() == nil {
+		t.Error("expected replaced collector to be stopped")
+	}
+
+	// failing collector doesn't change the running collectors
+	scrapeTime = 3 * time.Hour
+	failingScrapeTime = time.Hour
+	if err := manager.Apply(runtimeConfig); err == nil {
+		t.Fatal("expected error")
+	}
+	if manager.Get("test") != replaced || manager.Get("failing") != nil || replaced.ctx.Err() != nil {
+		t.Error("expected running collectors to be unchanged")
+	}
+
+	// disabled collector is removed
+	scrapeTime = 0
+	failingScrapeTime = 0
+	if err := manager.Apply(runtimeConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manager.Get("test") != nil || len(manager.List()) != 0 {
+		t.Error("expected collector to be removed")
+	}
+	if replaced.ctx.Err() == nil {
+		t.Error("expected removed collector to be stopped")
+	}
+
+	status := manager.Status()
+	if len(status) != 2 || status[0].Enabled || status[1].Enabled {
+		t.Errorf("expected disabled collectors in status, got %+v", status)
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type (
+	CollectorConfig struct {
+		// Subscriptions limits the collector to these subscription ids (only subscriptions allowed by --azure.subscription)
+		Subscriptions []string `yaml:"subscriptions" json:"subscriptions,omitempty"`
+
+		// Include and Exclude narrow the subscriptions of the collector (exclude wins)
+		Include *SubscriptionFilter `yaml:"include" json:"include,omitempty"`
+		Exclude *SubscriptionFilter `yaml:"exclude" json:"exclude,omitempty"`
+
+		// Locations overrides --azure.location for the collector
+		Locations []string `yaml:"locations" json:"locations,omitempty"`
+	}
+
+	// SubscriptionFilter matches subscriptions by id, name (regexp) or tag value (regexp),
+	// a subscription matches if any of the ids, names or tags match
+	SubscriptionFilter struct {
+		IDs   []string          `yaml:"ids"   json:"ids,omitempty"`
+		Names []string          `yaml:"names" json:"names,omitempty"`
+		Tags  map[string]string `yaml:"tags"  json:"tags,omitempty"`
+
+		// regexps of names and tags, compiled once on first use
+		compileOnce  sync.Once
+		compileError error
+		nameRegexps  []*regexp.Regexp
+		tagRegexps   map[string]*regexp.Regexp
+	}
+)
+
+func (c *CollectorConfig) Validate() error {
+	if c.Include != nil {
+		if err := c.Include.Validate(); err != nil {
+			return fmt.Errorf("invalid include filter: %w", err)
+		}
+	}
+
+	if c.Exclude != nil {
+		if err := c.Exclude.Validate(); err != nil {
+			return fmt.Errorf("invalid exclude filter: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// MatchesSubscription checks if a subscription passes the include and exclude filters of the collector
+func (c *CollectorConfig) MatchesSubscription(subscriptionID, name string, tags map[string]*string) bool {
+	if c.Include != nil && !c.Include.Matches(subscriptionID, name, tags) {
+		return false
+	}
+
+	if c.Exclude != nil && c.Exclude.Matches(subscriptionID, name, tags) {
+		return false
+	}
+
+	return true
+}
+
+// GetLocations returns the locations of the collector or the default locations if none are set
+func (c CollectorConfig) GetLocations(defaultLocations []string) []string {
+	if len(c.Locations) > 0 {
+		return c.Locations
+	}
+
+	return defaultLocations
+}
+
+func (f *SubscriptionFilter) Validate() error {
+	if len(f.IDs) == 0 && len(f.Names) == 0 && len(f.Tags) == 0 {
+		return fmt.Errorf("filter needs at least one of ids, names or tags")
+	}
+
+	return f.compile()
+}
+
+// compile compiles the regexps of names and tags once on first use (Validate or Matches)
+func (f *SubscriptionFilter) compile() error {
+	f.compileOnce.Do(func() {
+		if f.compileError = f.compileRegexps(); f.compileError != nil {
+			f.nameRegexps = nil
+			f.tagRegexps = nil
+		}
+	})
+
+	return f.compileError
+}
+
+func (f *SubscriptionFilter) compileRegexps() error {
+	f.nameRegexps = []*regexp.Regexp{}
+	for _, name := range f.Names {
+		nameRegexp, err := compileFilterRegexp(name)
+		if err != nil {
+			return fmt.Errorf(`invalid name regexp "%s": %w`, name, err)
+		}
+		f.nameRegexps = append(f.nameRegexps, nameRegexp)
+	}
+
+	f.tagRegexps = map[string]*regexp.Regexp{}
+	for tagName, tagValue := range f.Tags {
+		tagRegexp, err := compileFilterRegexp(tagValue)
+		if err != nil {
+			return fmt.Errorf(`invalid regexp "%s" for tag "%s": %w`, tagValue, tagName, err)
+		}
+		f.tagRegexps[tagName] = tagRegexp
+	}
+
+	return nil
+}
+
+// Matches checks if the subscription matches any of the ids, names or tags
+// (invalid regexps are rejected by Validate, a filter with invalid regexps only matches by ids)
+func (f *SubscriptionFilter) Matches(subscriptionID, name string, tags map[string]*string) bool {
+	f.compile() // nolint:errcheck
+
+	for _, id := range f.IDs {
+		if strings.EqualFold(id, subscriptionID) {
+			return true
+		}
+	}
+
+	for _, nameRegexp := range f.nameRegexps {
+		if nameRegexp.MatchString(name) {
+			return true
+		}
+	}
+
+	for tagName, tagRegexp := range f.tagRegexps {
+		for subscriptionTagName, subscriptionTagValue := range tags {
+			if !strings.EqualFold(tagName, subscriptionTagName) || subscriptionTagValue == nil {
+				continue
+			}
+
+			if tagRegexp.MatchString(*subscriptionTagValue) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compileFilterRegexp compiles a case-insensitive regexp which has to match the whole value
+func compileFilterRegexp(value string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)^(?:" + value + ")$")
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCollectorConfigMatchesSubscription(t *testing.T) {
+	prod := "prod"
+	dev := "dev"
+
+	tests := []struct {
+		name           string
+		config         CollectorConfig
+		subscriptionID string
+		displayName    string
+		tags           map[string]*string
+		expected       bool
+	}{
+		{
+			name:           "no filters",
+			config:         CollectorConfig{},
+			subscriptionID: "00000000-0000-0000-0000-000000000001",
+			expected:       true,
+		},
+		{
+			name:           "include id",
+			config:         CollectorConfig{Include: &SubscriptionFilter{IDs: []string{"00000000-0000-0000-0000-00000000000A"}}},
+			subscriptionID: "00000000-0000-0000-0000-00000000000a",
+			expected:       true,
+		},
+		{
+			name:        "include name",
+			config:      CollectorConfig{Include: &SubscriptionFilter{Names: []string{"prod-.*"}}},
+			displayName: "PROD-Platform",
+			expected:    true,
+		},
+		{
+			name:        "include name matches whole name",
+			config:      CollectorConfig{Include: &SubscriptionFilter{Names: []string{"prod"}}},
+			displayName: "prod-platform",
+			expected:    false,
+		},
+		{
+			name:     "include tag",
+			config:   CollectorConfig{Include: &SubscriptionFilter{Tags: map[string]string{"Env": "prod|staging"}}},
+			tags:     map[string]*string{"env": &prod},
+			expected: true,
+		},
+		{
+			name:     "include tag without match",
+			config:   CollectorConfig{Include: &SubscriptionFilter{Tags: map[string]string{"env": "prod"}}},
+			tags:     map[string]*string{"env": &dev, "owner": nil},
+			expected: false,
+		},
+		{
+			name: "exclude wins",
+			config: CollectorConfig{
+				Include: &SubscriptionFilter{Names: []string{".*"}},
+				Exclude: &SubscriptionFilter{Tags: map[string]string{"env": "dev"}},
+			},
+			displayName: "dev-platform",
+			tags:        map[string]*string{"env": &dev},
+			expected:    false,
+		},
+		{
+			name:        "exclude without match",
+			config:      CollectorConfig{Exclude: &SubscriptionFilter{Names: []string{"dev-.*"}}},
+			displayName: "prod-platform",
+			expected:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result := test.config.MatchesSubscription(test.subscriptionID, test.displayName, test.tags); result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestSubscriptionFilterValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *SubscriptionFilter
+	}{
+		{name: "empty filter", filter: &SubscriptionFilter{}},
+		{name: "invalid name regexp", filter: &SubscriptionFilter{Names: []string{"prod-("}}},
+		{name: "invalid tag regexp", filter: &SubscriptionFilter{Tags: map[string]string{"env": "[prod"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.filter.Validate(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestSubscriptionFilterMatchesWithoutValidate(t *testing.T) {
+	prod := "prod"
+	filter := &SubscriptionFilter{Names: []string{"prod-.*"}, Tags: map[string]string{"env": "prod"}}
+
+	if !filter.Matches("", "prod-platform", nil) {
+		t.Error("expected name to match")
+	}
+
+	if !filter.Matches("", "other", map[string]*string{"Env": &prod}) {
+		t.Error("expected tag to match")
+	}
+
+	if filter.Matches("", "dev-platform", nil) {
+		t.Error("expected no match")
+	}
+}
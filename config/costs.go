@@ -0,0 +1,459 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CostTimePeriodDateFormat = "2006-01-02"
+)
+
+var (
+	costScopeResourceGroupRegexp = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/?$`)
+)
+
+var (
+	// CostExportTypes are the supported export types of cost queries
+	CostExportTypes = []string{"ActualCost", "AmortizedCost", "Usage"}
+)
+
+type (
+	CostQueryConfig struct {
+		Name        string           `yaml:"name"        json:"name"`
+		Dimensions  []string         `yaml:"dimensions"  json:"dimensions"`
+		Filter      *CostQueryFilter `yaml:"filter"      json:"filter,omitempty"`
+		ExportTypes []string         `yaml:"exportTypes" json:"exportTypes,omitempty"`
+		DailyDays   *int             `yaml:"dailyDays"   json:"dailyDays,omitempty"`
+		Timeframes  []string         `yaml:"timeframes"  json:"timeframes,omitempty"`
+		TimePeriods []CostTimePeriod `yaml:"timePeriods" json:"timePeriods,omitempty"`
+		Scopes      []CostQueryScope `yaml:"scopes"      json:"scopes,omitempty"`
+	}
+
+	// CostQueryScope is the scope of a cost query (management group, billing account, billing profile, department
+	// or resource group), queries without scopes are run for each subscription
+	CostQueryScope struct {
+		// Tenant used for authentication (default: first tenant)
+		Tenant string `yaml:"tenant" json:"tenant,omitempty"`
+
+		ManagementGroup string `yaml:"managementGroup" json:"managementGroup,omitempty"`
+		BillingAccount  string `yaml:"billingAccount"  json:"billingAccount,omitempty"`
+		BillingProfile  string `yaml:"billingProfile"  json:"billingProfile,omitempty"`
+		Department      string `yaml:"department"      json:"department,omitempty"`
+		ResourceGroup   string `yaml:"resourceGroup"   json:"resourceGroup,omitempty"`
+	}
+
+	// CostTimePeriod is a custom time period of a cost query, the name is used as timeframe label
+	// either lastDays (including today), previousMonth (previous calendar month) or from and to
+	// (YYYY-MM-DD, including both days) have to be set
+	CostTimePeriod struct {
+		Name          string `yaml:"name"          json:"name"`
+		LastDays      int    `yaml:"lastDays"      json:"lastDays,omitempty"`
+		PreviousMonth bool   `yaml:"previousMonth" json:"previousMonth,omitempty"`
+		From          string `yaml:"from"          json:"from,omitempty"`
+		To            string `yaml:"to"            json:"to,omitempty"`
+	}
+
+	// CostQueryFilter is a (nested) filter expression for cost queries
+	// only one of the fields should be set, use and/or/not for combining expressions
+	// (the Cost Management API doesn't support not, these expressions are applied to the result rows
+	// and can only use dimensions of the query)
+	CostQueryFilter struct {
+		And       []*CostQueryFilter         `yaml:"and"       json:"and,omitempty"`
+		Or        []*CostQueryFilter         `yaml:"or"        json:"or,omitempty"`
+		Not       *CostQueryFilter           `yaml:"not"       json:"not,omitempty"`
+		Dimension *CostQueryFilterComparison `yaml:"dimension" json:"dimension,omitempty"`
+		Tag       *CostQueryFilterComparison `yaml:"tag"       json:"tag,omitempty"`
+	}
+
+	// CostQueryFilterComparison matches a dimension or tag against a value (equals) or a list of values (in)
+	CostQueryFilterComparison struct {
+		Name   string   `yaml:"name"   json:"name"`
+		Equals string   `yaml:"equals" json:"equals,omitempty"`
+		In     []string `yaml:"in"     json:"in,omitempty"`
+	}
+)
+
+func (q *CostQueryConfig) Validate() error {
+	if strings.TrimSpace(q.Name) == "" {
+		return errors.New("cost query needs a name")
+	}
+
+	if len(q.Dimensions) == 0 {
+		return fmt.Errorf(`cost query "%s" needs at least one dimension`, q.Name)
+	}
+
+	if q.DailyDays != nil && *q.DailyDays < 0 {
+		return fmt.Errorf(`cost query "%s": dailyDays cannot be negative`, q.Name)
+	}
+
+	for _, exportType := range q.ExportTypes {
+		if _, err := NormalizeCostExportType(exportType); err != nil {
+			return fmt.Errorf(`cost query "%s": %w`, q.Name, err)
+		}
+	}
+
+	for _, timeframe := range q.Timeframes {
+		if _, err := NormalizeCostTimeframe(timeframe); err != nil {
+			return fmt.Errorf(`cost query "%s": %w`, q.Name, err)
+		}
+	}
+
+	if q.Filter != nil {
+		if err := q.Filter.Validate(); err != nil {
+			return fmt.Errorf(`cost query "%s" has invalid filter: %w`, q.Name, err)
+		}
+	}
+
+	for _, scope := range q.Scopes {
+		if err := scope.Validate(); err != nil {
+			return fmt.Errorf(`cost query "%s" has invalid scope: %w`, q.Name, err)
+		}
+	}
+
+	timePeriodNames := []string{}
+	for _, timePeriod := range q.TimePeriods {
+		if err := timePeriod.Validate(); err != nil {
+			return fmt.Errorf(`cost query "%s" has invalid time period: %w`, q.Name, err)
+		}
+
+		for _, name := range timePeriodNames {
+			if strings.EqualFold(name, timePeriod.Name) {
+				return fmt.Errorf(`cost query "%s" has duplicate time period "%s"`, q.Name, timePeriod.Name)
+			}
+		}
+		timePeriodNames = append(timePeriodNames, timePeriod.Name)
+	}
+
+	return nil
+}
+
+func (s *CostQueryScope) Validate() error {
+	scopeCount := 0
+	for _, val := range []string{s.ManagementGroup, s.BillingAccount, s.ResourceGroup} {
+		if val != "" {
+			scopeCount++
+		}
+	}
+
+	if scopeCount != 1 {
+		return errors.New("scope needs exactly one of managementGroup, billingAccount or resourceGroup")
+	}
+
+	if (s.BillingProfile != "" || s.Department != "") && s.BillingAccount == "" {
+		return errors.New("billingProfile and department need a billingAccount")
+	}
+
+	if s.BillingProfile != "" && s.Department != "" {
+		return errors.New("scope cannot have billingProfile and department")
+	}
+
+	if s.ResourceGroup != "" && !costScopeResourceGroupRegexp.MatchString(s.ResourceGroup) {
+		return fmt.Errorf(`resourceGroup "%s" needs to be a resource id (/subscriptions/<id>/resourceGroups/<name>)`, s.ResourceGroup)
+	}
+
+	return nil
+}
+
+// ResourceID returns the resource id of the scope as used by the Cost Management API
+func (s *CostQueryScope) ResourceID() string {
+	switch {
+	case s.ManagementGroup != "":
+		return "/providers/Microsoft.Management/managementGroups/" + s.ManagementGroup
+	case s.BillingProfile != "":
+		return "/providers/Microsoft.Billing/billingAccounts/" + s.BillingAccount + "/billingProfiles/" + s.BillingProfile
+	case s.Department != "":
+		return "/providers/Microsoft.Billing/billingAccounts/" + s.BillingAccount + "/departments/" + s.Department
+	case s.BillingAccount != "":
+		return "/providers/Microsoft.Billing/billingAccounts/" + s.BillingAccount
+	default:
+		return strings.TrimSuffix(s.ResourceGroup, "/")
+	}
+}
+
+func (p *CostTimePeriod) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("time period needs a name")
+	}
+
+	if p.LastDays < 0 {
+		return fmt.Errorf(`time period "%s": lastDays cannot be negative`, p.Name)
+	}
+
+	periodCount := 0
+	if p.LastDays > 0 {
+		periodCount++
+	}
+	if p.PreviousMonth {
+		periodCount++
+	}
+	if p.From != "" || p.To != "" {
+		periodCount++
+	}
+
+	if periodCount != 1 || (p.From == "") != (p.To == "") {
+		return fmt.Errorf(`time period "%s" needs either lastDays, previousMonth or from and to`, p.Name)
+	}
+
+	if p.From != "" {
+		from, err := time.Parse(CostTimePeriodDateFormat, p.From)
+		if err != nil {
+			return fmt.Errorf(`time period "%s": invalid from date "%s" (format YYYY-MM-DD)`, p.Name, p.From)
+		}
+
+		to, err := time.Parse(CostTimePeriodDateFormat, p.To)
+		if err != nil {
+			return fmt.Errorf(`time period "%s": invalid to date "%s" (format YYYY-MM-DD)`, p.Name, p.To)
+		}
+
+		if to.Before(from) {
+			return fmt.Errorf(`time period "%s": to date is before from date`, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// Period returns the start and end of the time period (UTC, end is the last second of the last day)
+func (p *CostTimePeriod) Period(now time.Time) (from, to time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch {
+	case p.LastDays > 0:
+		from = today.AddDate(0, 0, -(p.LastDays - 1))
+		to = today
+	case p.PreviousMonth:
+		to = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+		from = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		// validated before
+		from, _ = time.Parse(CostTimePeriodDateFormat, p.From)
+		to, _ = time.Parse(CostTimePeriodDateFormat, p.To)
+	}
+
+	return from, to.AddDate(0, 0, 1).Add(-1 * time.Second)
+}
+
+func (f *CostQueryFilter) Validate() error {
+	expressionCount := 0
+	if len(f.And) > 0 {
+		expressionCount++
+	}
+	if len(f.Or) > 0 {
+		expressionCount++
+	}
+	if f.Not != nil {
+		expressionCount++
+	}
+	if f.Dimension != nil {
+		expressionCount++
+	}
+	if f.Tag != nil {
+		expressionCount++
+	}
+
+	if expressionCount != 1 {
+		return errors.New("filter needs exactly one of and, or, not, dimension or tag")
+	}
+
+	expressions := append([]*CostQueryFilter{}, f.And...)
+	expressions = append(expressions, f.Or...)
+	if f.Not != nil {
+		expressions = append(expressions, f.Not)
+	}
+
+	for _, expr := range expressions {
+		if err := expr.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(f.And) == 1 || len(f.Or) == 1 {
+		return errors.New("and/or filter needs at least two expressions")
+	}
+
+	for _, comparison := range []*CostQueryFilterComparison{f.Dimension, f.Tag} {
+		if comparison == nil {
+			continue
+		}
+
+		if comparison.Name == "" {
+			return errors.New("filter comparison needs a name")
+		}
+
+		if len(comparison.Values()) == 0 {
+			return fmt.Errorf(`filter comparison for "%s" needs equals or in`, comparison.Name)
+		}
+	}
+
+	return nil
+}
+
+// HasNot returns true if the filter contains a not expression
+func (f *CostQueryFilter) HasNot() bool {
+	if f.Not != nil {
+		return true
+	}
+
+	for _, expr := range append(append([]*CostQueryFilter{}, f.And...), f.Or...) {
+		if expr.HasNot() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Names returns the dimension names used in the filter (tags are prefixed with "tag:")
+func (f *CostQueryFilter) Names() []string {
+	names := []string{}
+
+	if f.Dimension != nil {
+		names = append(names, f.Dimension.Name)
+	}
+
+	if f.Tag != nil {
+		names = append(names, "tag:"+f.Tag.Name)
+	}
+
+	expressions := append(append([]*CostQueryFilter{}, f.And...), f.Or...)
+	if f.Not != nil {
+		expressions = append(expressions, f.Not)
+	}
+
+	for _, expr := range expressions {
+		names = append(names, expr.Names()...)
+	}
+
+	return names
+}
+
+// Matches evaluates the filter against the dimension values of a result row (keys are the lowercase dimension names)
+func (f *CostQueryFilter) Matches(values map[string]string) bool {
+	switch {
+	case len(f.And) > 0:
+		for _, expr := range f.And {
+			if !expr.Matches(values) {
+				return false
+			}
+		}
+		return true
+	case len(f.Or) > 0:
+		for _, expr := range f.Or {
+			if expr.Matches(values) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !f.Not.Matches(values)
+	case f.Dimension != nil:
+		return f.Dimension.Matches(values[strings.ToLower(f.Dimension.Name)])
+	case f.Tag != nil:
+		return f.Tag.Matches(values["tag:"+strings.ToLower(f.Tag.Name)])
+	}
+
+	return true
+}
+
+// Matches checks if the value is one of the values of the comparison (case-insensitive)
+func (c *CostQueryFilterComparison) Matches(value string) bool {
+	for _, val := range c.Values() {
+		if strings.EqualFold(val, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Values returns the list of values to compare with (equals is handled as "in" with one value)
+func (c *CostQueryFilterComparison) Values() []string {
+	values := c.In
+	if c.Equals != "" {
+		values = append([]string{c.Equals}, values...)
+	}
+	return values
+}
+
+// NormalizeCostExportType returns the export type as used by the Cost Management API (case-insensitive)
+func NormalizeCostExportType(exportType string) (string, error) {
+	for _, val := range CostExportTypes {
+		if strings.EqualFold(val, strings.TrimSpace(exportType)) {
+			return val, nil
+		}
+	}
+
+	return "", fmt.Errorf(`unknown export type "%s" (valid export types: %s)`, exportType, strings.Join(CostExportTypes, ", "))
+}
+
+// NormalizeCostTimeframe returns the timeframe as used by the Cost Management API (case-insensitive),
+// custom timeframes are configured with time periods
+func NormalizeCostTimeframe(timeframe string) (string, error) {
+	timeframes := []string{}
+	for _, val := range armcostmanagement.PossibleTimeframeTypeValues() {
+		if val == armcostmanagement.TimeframeTypeCustom {
+			continue
+		}
+
+		if strings.EqualFold(string(val), strings.TrimSpace(timeframe)) {
+			return string(val), nil
+		}
+		timeframes = append(timeframes, string(val))
+	}
+
+	return "", fmt.Errorf(`unknown timeframe "%s" (valid timeframes: %s)`, timeframe, strings.Join(timeframes, ", "))
+}
+
+// ParseCurrencyRates parses exchange rates in format CURRENCY=rate (eg. USD=0.92), currencies are uppercase
+func ParseCurrencyRates(rates []string) (map[string]float64, error) {
+	ret := map[string]float64{}
+
+	for _, rate := range rates {
+		parts := strings.SplitN(rate, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf(`invalid currency rate "%s", expected format CURRENCY=rate`, rate)
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf(`invalid currency rate "%s", rate needs to be a number greater than zero`, rate)
+		}
+
+		ret[strings.ToUpper(strings.TrimSpace(parts[0]))] = value
+	}
+
+	return ret, nil
+}
+
+// ReadCurrencyRatesFile reads exchange rates from a yaml or json file (map of currency and rate, eg. "USD: 0.92")
+func ReadCurrencyRatesFile(path string) (map[string]float64, error) {
+	content, err := os.ReadFile(path) // #nosec G304 path is set by the user
+	if err != nil {
+		return nil, fmt.Errorf(`unable to read currency rates file "%s": %w`, path, err)
+	}
+
+	rates := map[string]float64{}
+	if err := yaml.Unmarshal(content, &rates); err != nil {
+		return nil, fmt.Errorf(`unable to parse currency rates file "%s": %w`, path, err)
+	}
+
+	ret := map[string]float64{}
+	for currency, value := range rates {
+		if value <= 0 {
+			return nil, fmt.Errorf(`currency rates file "%s": rate of "%s" needs to be greater than zero`, path, currency)
+		}
+		ret[strings.ToUpper(strings.TrimSpace(currency))] = value
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCostQueryFilterMatches(t *testing.T) {
+	location := func(values ...string) *CostQueryFilter {
+		return &CostQueryFilter{Dimension: &CostQueryFilterComparison{Name: "ResourceLocation", In: values}}
+	}
+	env := func(value string) *CostQueryFilter {
+		return &CostQueryFilter{Tag: &CostQueryFilterComparison{Name: "env", Equals: value}}
+	}
+
+	tests := []struct {
+		name     string
+		filter   *CostQueryFilter
+		values   map[string]string
+		expected bool
+	}{
+		{
+			name:     "dimension",
+			filter:   location("westeurope", "northeurope"),
+			values:   map[string]string{"resourcelocation": "NorthEurope"},
+			expected: true,
+		},
+		{
+			name:     "dimension without match",
+			filter:   location("westeurope"),
+			values:   map[string]string{"resourcelocation": "global"},
+			expected: false,
+		},
+		{
+			name:     "tag",
+			filter:   env("prod"),
+			values:   map[string]string{"tag:env": "prod"},
+			expected: true,
+		},
+		{
+			name:     "not",
+			filter:   &CostQueryFilter{Not: location("global")},
+			values:   map[string]string{"resourcelocation": "westeurope"},
+			expected: true,
+		},
+		{
+			name:     "not without match",
+			filter:   &CostQueryFilter{Not: location("global")},
+			values:   map[string]string{"resourcelocation": "global"},
+			expected: false,
+		},
+		{
+			name:     "not with missing value",
+			filter:   &CostQueryFilter{Not: env("prod")},
+			values:   map[string]string{},
+			expected: true,
+		},
+		{
+			name:     "double not",
+			filter:   &CostQueryFilter{Not: &CostQueryFilter{Not: env("prod")}},
+			values:   map[string]string{"tag:env": "prod"},
+			expected: true,
+		},
+		{
+			name:     "and with not",
+			filter:   &CostQueryFilter{And: []*CostQueryFilter{env("prod"), {Not: location("global")}}},
+			values:   map[string]string{"tag:env": "prod", "resourcelocation": "global"},
+			expected: false,
+		},
+		{
+			name:     "or with not",
+			filter:   &CostQueryFilter{Or: []*CostQueryFilter{env("prod"), {Not: location("global")}}},
+			values:   map[string]string{"tag:env": "dev", "resourcelocation": "westeurope"},
+			expected: true,
+		},
+		{
+			name:     "or without match",
+			filter:   &CostQueryFilter{Or: []*CostQueryFilter{env("prod"), {Not: location("global")}}},
+			values:   map[string]string{"tag:env": "dev", "resourcelocation": "global"},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.filter.Matches(test.values); result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestCostTimePeriodPeriod(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 13, 37, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		timePeriod CostTimePeriod
+		now        time.Time
+		from       string
+		to         string
+	}{
+		{
+			name:       "last day",
+			timePeriod: CostTimePeriod{Name: "Today", LastDays: 1},
+			now:        now,
+			from:       "2023-03-15T00:00:00Z",
+			to:         "2023-03-15T23:59:59Z",
+		},
+		{
+			name:       "last days",
+			timePeriod: CostTimePeriod{Name: "Last7Days", LastDays: 7},
+			now:        now,
+			from:       "2023-03-09T00:00:00Z",
+			to:         "2023-03-15T23:59:59Z",
+		},
+		{
+			name:       "last days across months",
+			timePeriod: CostTimePeriod{Name: "Last30Days", LastDays: 30},
+			now:        now,
+			from:       "2023-02-14T00:00:00Z",
+			to:         "2023-03-15T23:59:59Z",
+		},
+		{
+			name:       "last days in other time zone",
+			timePeriod: CostTimePeriod{Name: "Today", LastDays: 1},
+			now:        time.Date(2023, time.March, 16, 0, 30, 0, 0, time.FixedZone("CET", 3600)),
+			from:       "2023-03-15T00:00:00Z",
+			to:         "2023-03-15T23:59:59Z",
+		},
+		{
+			name:       "previous month",
+			timePeriod: CostTimePeriod{Name: "PreviousMonth", PreviousMonth: true},
+			now:        now,
+			from:       "2023-02-01T00:00:00Z",
+			to:         "2023-02-28T23:59:59Z",
+		},
+		{
+			name:       "previous month across years",
+			timePeriod: CostTimePeriod{Name: "PreviousMonth", PreviousMonth: true},
+			now:        time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC),
+			from:       "2023-12-01T00:00:00Z",
+			to:         "2023-12-31T23:59:59Z",
+		},
+		{
+			name:       "previous month in leap year",
+			timePeriod: CostTimePeriod{Name: "PreviousMonth", PreviousMonth: true},
+			now:        time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC),
+			from:       "2024-02-01T00:00:00Z",
+			to:         "2024-02-29T23:59:59Z",
+		},
+		{
+			name:       "from and to",
+			timePeriod: CostTimePeriod{Name: "FY2023Q1", From: "2023-01-01", To: "2023-03-31"},
+			now:        now,
+			from:       "2023-01-01T00:00:00Z",
+			to:         "2023-03-31T23:59:59Z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.timePeriod.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			from, to := test.timePeriod.Period(test.now)
+			if from.Format(time.RFC3339) != test.from || to.Format(time.RFC3339) != test.to {
+				t.Errorf("expected %s - %s, got %s - %s", test.from, test.to, from.Format(time.RFC3339), to.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestCostTimePeriodValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		timePeriod CostTimePeriod
+	}{
+		{name: "no name", timePeriod: CostTimePeriod{LastDays: 7}},
+		{name: "no period", timePeriod: CostTimePeriod{Name: "empty"}},
+		{name: "negative last days", timePeriod: CostTimePeriod{Name: "negative", LastDays: -1}},
+		{name: "last days and previous month", timePeriod: CostTimePeriod{Name: "both", LastDays: 7, PreviousMonth: true}},
+		{name: "previous month and from", timePeriod: CostTimePeriod{Name: "both", PreviousMonth: true, From: "2023-01-01", To: "2023-01-31"}},
+		{name: "from without to", timePeriod: CostTimePeriod{Name: "from", From: "2023-01-01"}},
+		{name: "to without from", timePeriod: CostTimePeriod{Name: "to", To: "2023-01-31"}},
+		{name: "invalid date", timePeriod: CostTimePeriod{Name: "invalid", From: "01.01.2023", To: "2023-01-31"}},
+		{name: "to before from", timePeriod: CostTimePeriod{Name: "reversed", From: "2023-01-31", To: "2023-01-01"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.timePeriod.Validate(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestNormalizeCostTimeframe(t *testing.T) {
+	tests := []struct {
+		timeframe string
+		expected  string
+		wantErr   bool
+	}{
+		{timeframe: "MonthToDate", expected: "MonthToDate"},
+		{timeframe: " thelastmonth ", expected: "TheLastMonth"},
+		{timeframe: "Custom", wantErr: true},
+		{timeframe: "LastDecade", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.timeframe, func(t *testing.T) {
+			timeframe, err := NormalizeCostTimeframe(test.timeframe)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", timeframe)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if timeframe != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, timeframe)
+			}
+		})
+	}
+}
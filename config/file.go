@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FileKeyCostsQueries = "costs.queries"
+	FileKeyCollectors   = "collectors"
+	FileKeyTenants      = "tenants"
+)
+
+type (
+	// File is a parsed configuration file (yaml or json)
+	File struct {
+		Path string
+
+		// Options contains all plain options (named like the long flag name, eg. "azure.tenant")
+		Options []FileOption
+
+		// structured sections (not available as flags)
+		Costs struct {
+			Queries []CostQueryConfig
+		}
+		Collectors map[string]CollectorConfig
+		Tenants    []TenantConfig
+	}
+
+	// FileOption is a single option (eg. "scrape.time") set inside a configuration file
+	FileOption struct {
+		Name   string
+		Values []string
+		IsList bool
+		Line   int
+	}
+
+	// FileError points to the offending key inside a configuration file
+	FileError struct {
+		Path    string
+		Line    int
+		Key     string
+		Message string
+	}
+)
+
+func (e *FileError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf(`%s:%d: key "%s": %s`, e.Path, e.Line, e.Key, e.Message)
+	}
+	return fmt.Sprintf(`%s:%d: %s`, e.Path, e.Line, e.Message)
+}
+
+// ReadFile reads and parses a configuration file, yaml and json files are supported
+func ReadFile(path string) (*File, error) {
+	content, err := os.ReadFile(path) // #nosec inside container
+	if err != nil {
+		return nil, fmt.Errorf(`unable to read config file "%s": %w`, path, err)
+	}
+
+	file := &File{
+		Path:       path,
+		Collectors: map[string]CollectorConfig{},
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf(`unable to parse config file "%s": %w`, path, err)
+	}
+
+	// empty file
+	if len(root.Content) == 0 {
+		return file, nil
+	}
+
+	if err := file.parseNode(root.Content[0], ""); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (f *File) newError(node *yaml.Node, key, message string, args ...interface{}) error {
+	return &FileError{
+		Path:    f.Path,
+		Line:    node.Line,
+		Key:     key,
+		Message: fmt.Sprintf(message, args...),
+	}
+}
+
+func (f *File) parseNode(node *yaml.Node, path string) error {
+	if node.Kind != yaml.MappingNode {
+		return f.newError(node, path, "expected a map")
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		if keyNode.Kind != yaml.ScalarNode {
+			return f.newError(keyNode, path, "expected a string as key")
+		}
+
+		key := strings.ToLower(keyNode.Value)
+		if path != "" {
+			key = path + "." + key
+		}
+
+		switch key {
+		case FileKeyCostsQueries:
+			if err := f.decodeNode(valueNode, key, &f.Costs.Queries); err != nil {
+				return err
+			}
+
+			for num, query := range f.Costs.Queries {
+				if err := query.Validate(); err != nil {
+					return f.newError(valueNode.Content[num], key, err.Error())
+				}
+			}
+			continue
+		case FileKeyTenants:
+			if err := f.decodeNode(valueNode, key, &f.Tenants); err != nil {
+				return err
+			}
+
+			for num, tenant := range f.Tenants {
+				if err := tenant.Validate(); err != nil {
+					return f.newError(valueNode.Content[num], key, err.Error())
+				}
+			}
+			continue
+		case FileKeyCollectors:
+			collectors := map[string]CollectorConfig{}
+			if err := f.decodeNode(valueNode, key, &collectors); err != nil {
+				return err
+			}
+
+			for name, collector := range collectors {
+				if err := collector.Validate(); err != nil {
+					return f.newError(valueNode, key, `collector "%s": %v`, name, err)
+				}
+				f.Collectors[strings.ToLower(name)] = collector
+			}
+			continue
+		}
+
+		switch valueNode.Kind {
+		case yaml.MappingNode:
+			if err := f.parseNode(valueNode, key); err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			option := FileOption{Name: key, Line: keyNode.Line, IsList: true}
+			for _, itemNode := range valueNode.Content {
+				if itemNode.Kind != yaml.ScalarNode {
+					return f.newError(itemNode, key, "expected a list of values")
+				}
+				option.Values = append(option.Values, itemNode.Value)
+			}
+			f.Options = append(f.Options, option)
+		case yaml.ScalarNode:
+			// null values are ignored (eg. empty keys)
+			if valueNode.Tag == "!!null" {
+				continue
+			}
+
+			f.Options = append(f.Options, FileOption{Name: key, Line: keyNode.Line, Values: []string{valueNode.Value}})
+		default:
+			return f.newError(valueNode, key, "unsupported value")
+		}
+	}
+
+	return nil
+}
+
+// decodeNode decodes the structured node into target and fails on unknown keys
+func (f *File) decodeNode(node *yaml.Node, key string, target interface{}) error {
+	if err := f.checkKnownFields(node, key, reflect.TypeOf(target)); err != nil {
+		return err
+	}
+
+	if err := node.Decode(target); err != nil {
+		return f.newError(node, key, err.Error())
+	}
+
+	return nil
+}
+
+// checkKnownFields walks the yaml node and checks if all map keys exist as yaml fields of the target type
+func (f *File) checkKnownFields(node *yaml.Node, key string, targetType reflect.Type) error {
+	for targetType.Kind() == reflect.Ptr {
+		targetType = targetType.Elem()
+	}
+
+	switch targetType.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fieldName := node.Content[i].Value
+			field, exists := yamlStructField(targetType, fieldName)
+			if !exists {
+				return f.newError(node.Content[i], key+"."+fieldName, "unknown key")
+			}
+
+			if err := f.checkKnownFields(node.Content[i+1], key+"."+fieldName, field.Type); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := f.checkKnownFields(node.Content[i+1], key+"."+node.Content[i].Value, targetType.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+
+		for num, itemNode := range node.Content {
+			if err := f.checkKnownFields(itemNode, fmt.Sprintf("%s[%d]", key, num), targetType.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func yamlStructField(structType reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tagName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tagName == "" {
+			tagName = strings.ToLower(field.Name)
+		}
+
+		if tagName == name {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
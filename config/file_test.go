@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		options []FileOption
+	}{
+		{
+			name:    "empty file",
+			content: "",
+			options: nil,
+		},
+		{
+			name: "nested keys",
+			content: `
+log:
+  debug: true
+scrape:
+  time: 10m
+`,
+			options: []FileOption{
+				{Name: "log.debug", Values: []string{"true"}, Line: 3},
+				{Name: "scrape.time", Values: []string{"10m"}, Line: 5},
+			},
+		},
+		{
+			name: "dotted keys and lists",
+			content: `
+azure.location: [westeurope, northeurope]
+Azure:
+  Subscription:
+    - 00000000-0000-0000-0000-000000000000
+`,
+			options: []FileOption{
+				{Name: "azure.location", Values: []string{"westeurope", "northeurope"}, IsList: true, Line: 2},
+				{Name: "azure.subscription", Values: []string{"00000000-0000-0000-0000-000000000000"}, IsList: true, Line: 4},
+			},
+		},
+		{
+			name: "null values are ignored",
+			content: `
+azure:
+  tenant:
+`,
+			options: nil,
+		},
+		{
+			name:    "json",
+			content: `{"scrape": {"time": "1m"}}`,
+			options: []FileOption{
+				{Name: "scrape.time", Values: []string{"1m"}, Line: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ReadFile(writeTestFile(t, test.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(file.Options, test.options) {
+				t.Errorf("expected options %+v, got %+v", test.options, file.Options)
+			}
+		})
+	}
+}
+
+func TestReadFileSections(t *testing.T) {
+	file, err := ReadFile(writeTestFile(t, `
+costs:
+  queries:
+    - name: by_location
+      dimensions: [ResourceLocation]
+tenants:
+  - id: tenant-a
+collectors:
+  Quota:
+    locations: [westeurope]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(file.Options) != 0 {
+		t.Errorf("expected no options, got %+v", file.Options)
+	}
+
+	if len(file.Costs.Queries) != 1 || file.Costs.Queries[0].Name != "by_location" {
+		t.Errorf("expected query by_location, got %+v", file.Costs.Queries)
+	}
+
+	if len(file.Tenants) != 1 || file.Tenants[0].ID != "tenant-a" {
+		t.Errorf("expected tenant tenant-a, got %+v", file.Tenants)
+	}
+
+	if _, exists := file.Collectors["quota"]; !exists {
+		t.Errorf("expected collector quota, got %+v", file.Collectors)
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		key     string
+	}{
+		{
+			name:    "no map",
+			content: "- foo",
+			line:    1,
+			key:     "",
+		},
+		{
+			name: "list of maps",
+			content: `
+azure:
+  location:
+    - name: westeurope
+`,
+			line: 4,
+			key:  "azure.location",
+		},
+		{
+			name: "unknown query field",
+			content: `
+costs:
+  queries:
+    - name: by_location
+      dimension: [ResourceLocation]
+`,
+			line: 5,
+			key:  "costs.queries[0].dimension",
+		},
+		{
+			name: "invalid query",
+			content: `
+costs:
+  queries:
+    - name: by_location
+      dimensions: [ResourceLocation]
+    - name: by_resourcegroup
+`,
+			line: 6,
+			key:  "costs.queries",
+		},
+		{
+			name: "invalid tenant",
+			content: `
+tenants:
+  - id: tenant-a
+    credentials:
+      type: clientsecret
+`,
+			line: 3,
+			key:  "tenants",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeTestFile(t, test.content)
+
+			_, err := ReadFile(path)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("expected FileError, got %T: %v", err, err)
+			}
+
+			if fileErr.Path != path || fileErr.Line != test.line || fileErr.Key != test.key {
+				t.Errorf("expected %s:%d (key %q), got %s:%d (key %q)", path, test.line, test.key, fileErr.Path, fileErr.Line, fileErr.Key)
+			}
+		})
+	}
+}
+
+func TestFileError(t *testing.T) {
+	tests := []struct {
+		err      FileError
+		expected string
+	}{
+		{
+			err:      FileError{Path: "config.yaml", Line: 3, Key: "scrape.time", Message: "invalid duration"},
+			expected: `config.yaml:3: key "scrape.time": invalid duration`,
+		},
+		{
+			err:      FileError{Path: "config.yaml", Line: 1, Message: "expected a map"},
+			expected: `config.yaml:1: expected a map`,
+		},
+	}
+
+	for _, test := range tests {
+		if message := test.err.Error(); message != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, message)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -9,6 +10,12 @@ import (
 
 type (
 	Opts struct {
+		// config file
+		Config struct {
+			Path          string        `long:"config"                env:"CONFIG"                 description:"Path to config file (yaml or json), flags and env vars override values from the config file"`
+			WatchInterval time.Duration `long:"config.watch.interval" env:"CONFIG_WATCH_INTERVAL"  description:"Check config file for changes and reload it (0 = disabled, reload is also possible via SIGHUP)" default:"0"`
+		}
+
 		// logger
 		Logger struct {
 			Debug bool `long:"log.debug"    env:"LOG_DEBUG"  description:"debug mode"`
@@ -18,14 +25,18 @@ type (
 
 		// azure
 		Azure struct {
-			Tenant            *string  `long:"azure.tenant"                   env:"AZURE_TENANT_ID"           description:"Azure tenant id" required:"true"`
+			Tenant            *string  `long:"azure.tenant"                   env:"AZURE_TENANT_ID"           description:"Azure tenant id (required if no tenants are set in the config file)"`
 			Environment       *string  `long:"azure.environment"              env:"AZURE_ENVIRONMENT"         description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
 			Subscription      []string `long:"azure.subscription"             env:"AZURE_SUBSCRIPTION_ID"     env-delim:" "  description:"Azure subscription ID (space delimiter)"`
+			ManagementGroup   []string `long:"azure.managementgroup"          env:"AZURE_MANAGEMENTGROUP"     env-delim:" "  description:"Azure management group ID, limits subscriptions to the management groups (recursive; space delimiter)"`
 			Location          []string `long:"azure.location"                 env:"AZURE_LOCATION"            env-delim:" "  description:"Azure locations (space delimiter)"                                  default:"westeurope" default:"northeurope"` //nolint:staticcheck
 			ResourceGroupTags []string `long:"azure.resourcegroup.tag"        env:"AZURE_RESOURCEGROUP_TAG"   env-delim:" "  description:"Azure ResourceGroup tags (space delimiter)"                         default:"owner"`
 			ResourceTags      []string `long:"azure.resource.tag"             env:"AZURE_RESOURCE_TAG"        env-delim:" "  description:"Azure Resource tags (space delimiter)"                              default:"owner"`
 		}
 
+		// additional tenants (config file only)
+		Tenants []TenantConfig `no-flag:"true" json:"tenants,omitempty"`
+
 		// scrape times
 		Scrape struct {
 			Time               time.Duration  `long:"scrape.time"                    env:"SCRAPE_TIME"                    description:"Default scrape time (time.duration)"                      default:"5m"`
@@ -38,6 +49,8 @@ type (
 			TimeIam            *time.Duration `long:"scrape.time.iam"                env:"SCRAPE_TIME_IAM"                description:"Scrape time for IAM metrics (time.duration)"`
 			TimeGraph          *time.Duration `long:"scrape.time.graph"              env:"SCRAPE_TIME_GRAPH"              description:"Scrape time for Graph metrics (time.duration)"`
 			TimeCosts          *time.Duration `long:"scrape.time.costs"              env:"SCRAPE_TIME_COSTS"              description:"Scrape time for costs/consumtion metrics (time.duration; BETA)" default:"0"`
+			TimeCostsForecast  *time.Duration `long:"scrape.time.costs.forecast"     env:"SCRAPE_TIME_COSTS_FORECAST"     description:"Scrape time for costs forecast metrics (time.duration; BETA)" default:"0"`
+			TimeReservation    *time.Duration `long:"scrape.time.reservation"        env:"SCRAPE_TIME_RESERVATION"        description:"Scrape time for reservation and savings plan metrics (time.duration)" default:"0"`
 			TimePortscan       *time.Duration `long:"scrape.time.portscan"           env:"SCRAPE_TIME_PORTSCAN"           description:"Scrape time for public ips for portscan (time.duration)"`
 		}
 
@@ -50,11 +63,53 @@ type (
 			ApplicationFilter string `long:"graph.application.filter"    env:"GRAPH_APPLICATION_FILTER"               description:"MS Graph application $filter query eg: startswith(displayName,'A')"`
 		}
 
+		// quota settings
+		Quota struct {
+			Providers []string `long:"quota.provider"  env:"QUOTA_PROVIDER"  env-delim:" "  description:"Resource providers collected with the generic Microsoft.Quota API instead of the provider usage APIs (eg. Microsoft.Compute; space delimiter)"`
+
+			// exhaustion forecast
+			Forecast bool `long:"quota.forecast" env:"QUOTA_FORECAST" description:"Export 7/30 day growth rates and the estimated exhaustion of quotas (daily history of current values is persisted in --cache.path)"`
+
+			// location discovery
+			LocationDiscovery string   `long:"quota.location.discovery"  env:"QUOTA_LOCATION_DISCOVERY"                description:"Discover the quota locations of each subscription instead of using --azure.location (resources: locations containing resources, all: all physical locations of the subscription)"  choice:"disabled" choice:"resources" choice:"all" default:"disabled"` //nolint:staticcheck
+			LocationInclude   []string `long:"quota.location.include"    env:"QUOTA_LOCATION_INCLUDE"    env-delim:" "  description:"Only collect quotas of these locations (space delimiter)"`
+			LocationExclude   []string `long:"quota.location.exclude"    env:"QUOTA_LOCATION_EXCLUDE"    env-delim:" "  description:"Skip quotas of these locations (space delimiter)"`
+
+			// quota requests
+			RequestDays int `long:"quota.request.days" env:"QUOTA_REQUEST_DAYS" description:"Export quota requests submitted within the last days (all collected providers, needs the Microsoft.Quota provider; 0 = disabled)" default:"30"`
+		}
+
 		// costs
 		Costs struct {
 			Timeframe    []string      `long:"costs.timeframe"     env:"COSTS_TIMEFRAME"  env-delim:" " description:"Timeframe for cost reportings  (space delimiter)" default:"MonthToDate" default:"YearToDate"` //nolint:staticcheck
 			Queries      []string      `long:"costs.query"                                              description:"Cost query in format: 'queryname=dimension' or 'queryname=dimension1,dimension2,dimension3'. Dimensions can be: 'ResourceGroupName','ResourceLocation','ConsumedService','ResourceType','ResourceId','MeterId','BillingMonth','MeterCategory','MeterSubcategory','Meter','AccountName','DepartmentName','SubscriptionId','SubscriptionName','ServiceName','ServiceTier','EnrollmentAccountName','BillingAccountId','ResourceGuid','BillingPeriod','InvoiceNumber','ChargeType','PublisherType','ReservationId','ReservationName','Frequency','PartNumber','CostAllocationRuleName','MarkupRuleName','PricingModel'. Can be specified in env vars as COSTS_QUERY_queryname=Dimensions"`
 			RequestDelay time.Duration `long:"costs.request.delay" env:"COSTS_REQUEST_DELAY" description:"Delay API requests by this time to avoid ratelimits" default:"10s"`
+			DailyDays    int           `long:"costs.daily.days"    env:"COSTS_DAILY_DAYS"    description:"Export daily costs of the last days as azurerm_costs_<query>_daily with date label (0 = disabled, can be set per query with dailyDays)" default:"0"`
+			ExportTypes  []string      `long:"costs.exporttype"    env:"COSTS_EXPORTTYPE"    env-delim:" " description:"Export types of cost queries without exportTypes setting (ActualCost, AmortizedCost, Usage; space delimiter)" default:"ActualCost"`
+
+			// throttling
+			RequestDelayMax time.Duration `long:"costs.request.delay.max" env:"COSTS_REQUEST_DELAY_MAX" description:"Max delay of API requests after throttled requests (adaptive backoff, 0 = unlimited)" default:"5m"`
+			RequestRetries  int           `long:"costs.request.retries"   env:"COSTS_REQUEST_RETRIES"   description:"Retries of throttled (429) API requests (honoring the retry-after headers)" default:"3"`
+
+			// forecast
+			ForecastLimit int `long:"costs.forecast.limit" env:"COSTS_FORECAST_LIMIT" description:"Max number of dimension value combinations per query for forecasts (one API request per combination, highest costs first; 0 = unlimited)" default:"20"`
+
+			// budgets
+			BudgetResourceGroups   bool `long:"costs.budget.resourcegroups"   env:"COSTS_BUDGET_RESOURCEGROUPS"   description:"Collect budgets of resource groups (one API request per resource group)"`
+			BudgetManagementGroups bool `long:"costs.budget.managementgroups" env:"COSTS_BUDGET_MANAGEMENTGROUPS" description:"Collect budgets of the configured management groups (--azure.managementgroup or managementGroups of tenants)"`
+
+			// anomaly detection
+			AnomalyDays   int     `long:"costs.anomaly.days"   env:"COSTS_ANOMALY_DAYS"   description:"Baseline days (average daily costs) for anomaly detection of queries with daily costs (0 = disabled, history is persisted in --cache.path)" default:"0"`
+			AnomalyFactor float64 `long:"costs.anomaly.factor" env:"COSTS_ANOMALY_FACTOR" description:"Costs of the last complete day are an anomaly if they are higher than the baseline multiplied or lower than the baseline divided by this factor (> 1)" default:"2"`
+
+			// currency normalization
+			CurrencyTarget       string        `long:"costs.currency.target"    env:"COSTS_CURRENCY_TARGET"                 description:"Convert cost and budget values to this currency and export them as additional *_normalized metrics (empty = disabled)"`
+			CurrencyRates        []string      `long:"costs.currency.rate"      env:"COSTS_CURRENCY_RATE"    env-delim:" "  description:"Static exchange rates in format CURRENCY=rate, the rate is the value of 1 CURRENCY in the target currency (eg. USD=0.92; space delimiter)"`
+			CurrencyRatesFile    string        `long:"costs.currency.ratesfile" env:"COSTS_CURRENCY_RATESFILE"              description:"File with exchange rates (yaml/json map of currency and rate, overrides static rates), reloaded when changed"`
+			CurrencyRatesRefresh time.Duration `long:"costs.currency.refresh"   env:"COSTS_CURRENCY_REFRESH"                description:"Interval for checking the exchange rates file for changes" default:"1h"`
+
+			// config file only (costs.queries)
+			QueryConfigs []CostQueryConfig `no-flag:"true" json:"queryConfigs,omitempty"`
 		}
 
 		// portscan settings
@@ -67,16 +122,42 @@ type (
 			PortRange []string      `long:"portscan.range"                env:"PORTSCAN_RANGE"            env-delim:" "  description:"Portscan port range (first-last)  (space delimiter)"                 default:"1-65535"`
 		}
 
+		// oneshot mode
+		Oneshot struct {
+			Enabled    bool     `long:"oneshot"            env:"ONESHOT"                           description:"Run collectors once, write the metrics and exit (no http server)"`
+			Collectors []string `long:"oneshot.collector"  env:"ONESHOT_COLLECTOR"  env-delim:" "  description:"Collectors to run in oneshot mode (space delimiter, default: all enabled collectors)"`
+			Format     string   `long:"oneshot.format"     env:"ONESHOT_FORMAT"                    description:"Output format for oneshot mode"  choice:"prometheus" choice:"openmetrics" choice:"json" default:"prometheus"` //nolint:staticcheck
+			Output     string   `long:"oneshot.output"     env:"ONESHOT_OUTPUT"                    description:"Output file for oneshot mode (- = stdout)"  default:"-"`
+		}
+
+		// collector run endpoint
+		CollectorRun struct {
+			Token string `long:"collector.run.token"  env:"COLLECTOR_RUN_TOKEN"  description:"Bearer token for triggering collector runs via POST /collectors/{name}/run (endpoint is disabled without token)" json:"-"`
+		}
+
+		// readiness
+		Readiness struct {
+			Collectors []string `long:"readiness.collector"  env:"READINESS_COLLECTOR"  env-delim:" "  description:"Collectors which need a successful run before /readyz reports ready (space delimiter, default: all enabled collectors)"`
+		}
+
 		// caching
 		Cache struct {
 			Path string `long:"cache.path" env:"CACHE_PATH" description:"Cache path (to folder, file://path... or azblob://storageaccount.blob.core.windows.net/containername)"`
 		}
 
+		// per collector settings (config file only)
+		Collectors map[string]CollectorConfig `no-flag:"true" json:"collectors,omitempty"`
+
 		Server struct {
 			// general options
 			Bind         string        `long:"server.bind"              env:"SERVER_BIND"           description:"Server address"        default:":8080"`
 			ReadTimeout  time.Duration `long:"server.timeout.read"      env:"SERVER_TIMEOUT_READ"   description:"Server read timeout"   default:"5s"`
 			WriteTimeout time.Duration `long:"server.timeout.write"     env:"SERVER_TIMEOUT_WRITE"  description:"Server write timeout"  default:"10s"`
+
+			// tls and authentication
+			WebConfig       string `long:"server.web-config"          env:"SERVER_WEB_CONFIG"          description:"Path to web config file for TLS, mTLS, basic auth and http headers (Prometheus exporter-toolkit format, read on each request and TLS handshake)"`
+			BearerToken     string `long:"server.auth.bearer-token"   env:"SERVER_AUTH_BEARER_TOKEN"   description:"Bearer token for accessing the http server (alternative to basic auth)" json:"-"`
+			BearerTokenFile string `long:"server.auth.bearer-token-file"  env:"SERVER_AUTH_BEARER_TOKEN_FILE"  description:"File with bearer token for accessing the http server (read on each request)"`
 		}
 	}
 )
@@ -90,6 +171,15 @@ func (o *Opts) GetCachePath(path string) (ret *string) {
 	return
 }
 
+// GetCollectorConfig returns the config file settings for a collector (name is case-insensitive)
+func (o *Opts) GetCollectorConfig(name string) CollectorConfig {
+	if collectorConfig, exists := o.Collectors[strings.ToLower(name)]; exists {
+		return collectorConfig
+	}
+
+	return CollectorConfig{}
+}
+
 func (o *Opts) GetJson() []byte {
 	jsonBytes, err := json.Marshal(o)
 	if err != nil {
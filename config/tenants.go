@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	TenantCredentialsDefault           = "default"
+	TenantCredentialsAzCli             = "azcli"
+	TenantCredentialsWorkloadIdentity  = "workloadidentity"
+	TenantCredentialsManagedIdentity   = "managedidentity"
+	TenantCredentialsClientSecret      = "clientsecret"
+	TenantCredentialsClientCertificate = "clientcertificate"
+)
+
+type (
+	// TenantConfig is an additional Azure tenant with its own credentials and subscription filter
+	TenantConfig struct {
+		ID               string            `yaml:"id"               json:"id"`
+		Subscriptions    []string          `yaml:"subscriptions"    json:"subscriptions,omitempty"`
+		ManagementGroups []string          `yaml:"managementGroups" json:"managementGroups,omitempty"`
+		Credentials      TenantCredentials `yaml:"credentials"      json:"credentials"`
+	}
+
+	// TenantCredentials defines the credential source of a tenant (default: credentials from env vars, MSI, ...)
+	TenantCredentials struct {
+		Type                      string `yaml:"type"                      json:"type,omitempty"`
+		ClientID                  string `yaml:"clientID"                  json:"clientID,omitempty"`
+		ClientSecret              string `yaml:"clientSecret"              json:"-"`
+		ClientSecretFile          string `yaml:"clientSecretFile"          json:"clientSecretFile,omitempty"`
+		ClientCertificatePath     string `yaml:"clientCertificatePath"     json:"clientCertificatePath,omitempty"`
+		ClientCertificatePassword string `yaml:"clientCertificatePassword" json:"-"`
+		FederatedTokenFile        string `yaml:"federatedTokenFile"        json:"federatedTokenFile,omitempty"`
+	}
+)
+
+func (t *TenantConfig) Validate() error {
+	if strings.TrimSpace(t.ID) == "" {
+		return errors.New("tenant needs an id")
+	}
+
+	if err := t.Credentials.Validate(); err != nil {
+		return fmt.Errorf(`tenant "%s" has invalid credentials: %w`, t.ID, err)
+	}
+
+	return nil
+}
+
+func (c *TenantCredentials) Validate() error {
+	switch strings.ToLower(c.Type) {
+	case "", TenantCredentialsDefault, TenantCredentialsAzCli, TenantCredentialsManagedIdentity:
+	case TenantCredentialsWorkloadIdentity:
+		if c.ClientID == "" || c.FederatedTokenFile == "" {
+			return errors.New("workloadidentity needs clientID and federatedTokenFile")
+		}
+	case TenantCredentialsClientSecret:
+		if c.ClientID == "" || (c.ClientSecret == "" && c.ClientSecretFile == "") {
+			return errors.New("clientsecret needs clientID and clientSecret or clientSecretFile")
+		}
+	case TenantCredentialsClientCertificate:
+		if c.ClientID == "" || c.ClientCertificatePath == "" {
+			return errors.New("clientcertificate needs clientID and clientCertificatePath")
+		}
+	default:
+		return fmt.Errorf(`unknown type "%s" (valid types: %s, %s, %s, %s, %s, %s)`, c.Type,
+			TenantCredentialsDefault, TenantCredentialsAzCli, TenantCredentialsWorkloadIdentity,
+			TenantCredentialsManagedIdentity, TenantCredentialsClientSecret, TenantCredentialsClientCertificate,
+		)
+	}
+
+	return nil
+}
+
+// Secret returns the client secret (read from clientSecretFile if set)
+func (c *TenantCredentials) Secret() (string, error) {
+	if c.ClientSecretFile == "" {
+		return c.ClientSecret, nil
+	}
+
+	content, err := os.ReadFile(c.ClientSecretFile) // #nosec G304 path is set by the user
+	if err != nil {
+		return "", fmt.Errorf("unable to read client secret file: %w", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// CostsAnomalyHistory is the rolling history of daily costs of the cost queries,
+	// used to detect costs which deviate from the trailing baseline (persisted in --cache.path)
+	CostsAnomalyHistory struct {
+		lock sync.Mutex
+
+		Scopes map[string]*CostsAnomalyScope `json:"scopes"`
+	}
+
+	// CostsAnomalyScope is the history of a query, scope (subscription, management group, ...) and export type
+	CostsAnomalyScope struct {
+		Query string `json:"query"`
+
+		// Dates contains the successfully collected days, missing costs of series on these days are zero
+		Dates  []string                       `json:"dates"`
+		Series map[string]*CostsAnomalySeries `json:"series"`
+	}
+
+	// CostsAnomalySeries are the daily costs of a currency and combination of dimension values
+	CostsAnomalySeries struct {
+		Labels map[string]string  `json:"labels"`
+		Costs  map[string]float64 `json:"costs"`
+	}
+
+	// CostsAnomalyResult is the result of the anomaly detection of the last complete day of a series
+	CostsAnomalyResult struct {
+		Query  string
+		Labels prometheus.Labels
+		Score  float64
+
+		// Anomaly is 1 if the costs are higher than the baseline multiplied by the factor,
+		// -1 if they are lower than the baseline divided by the factor and 0 otherwise
+		Anomaly int
+	}
+)
+
+func newCostsAnomalyHistory() *CostsAnomalyHistory {
+	return &CostsAnomalyHistory{
+		Scopes: map[string]*CostsAnomalyScope{},
+	}
+}
+
+func (h *CostsAnomalyHistory) CacheLoad(path string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err := cacheRestoreFromPath(path, h); err != nil {
+		return err
+	}
+
+	if h.Scopes == nil {
+		h.Scopes = map[string]*CostsAnomalyScope{}
+	}
+
+	return nil
+}
+
+func (h *CostsAnomalyHistory) CacheSave(path string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return cacheSaveToPath(path, h)
+}
+
+// Add sets the costs of the day (date label) of the series, costs of a day are updated on each run
+func (h *CostsAnomalyHistory) Add(query MetricsCollectorAzureRmCostsQuery, labels prometheus.Labels, value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	seriesLabels := map[string]string{}
+	for name, labelValue := range labels {
+		if name != "date" {
+			seriesLabels[name] = labelValue
+		}
+	}
+
+	historyScope := h.scope(query, labels)
+
+	seriesKey := labelsKey(seriesLabels, nil)
+	series, exists := historyScope.Series[seriesKey]
+	if !exists {
+		series = &CostsAnomalySeries{
+			Labels: seriesLabels,
+			Costs:  map[string]float64{},
+		}
+		historyScope.Series[seriesKey] = series
+	}
+
+	series.Costs[labels["date"]] = value
+}
+
+// Collected marks the days of the time period as collected (days without costs of a series are zero)
+func (h *CostsAnomalyHistory) Collected(query MetricsCollectorAzureRmCostsQuery, scopeLabels prometheus.Labels, exportType string, from, to time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	labels := prometheus.Labels{"exportType": exportType}
+	for name, value := range scopeLabels {
+		labels[name] = value
+	}
+
+	historyScope := h.scope(query, labels)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dayFormat)
+		if !stringInSliceCI(date, historyScope.Dates) {
+			historyScope.Dates = append(historyScope.Dates, date)
+		}
+	}
+	sort.Strings(historyScope.Dates)
+}
+
+// Evaluate compares the costs of the last complete day (yesterday, costs of today are incomplete) with the average
+// daily costs of the days before (baseline), series without baseline costs are ignored.
+// Days older than the baseline are removed from the history.
+func (h *CostsAnomalyHistory) Evaluate(now time.Time, days int, factor float64) (results []CostsAnomalyResult) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	evaluationDate := now.AddDate(0, 0, -1).Format(dayFormat)
+
+	// days from the history which are still needed (baseline + evaluated day + one day for the timezone of the api)
+	cutoffDate := now.AddDate(0, 0, -(days + 2)).Format(dayFormat)
+
+	for scopeKey, historyScope := range h.Scopes {
+		dates := []string{}
+		for _, date := range historyScope.Dates {
+			if date >= cutoffDate {
+				dates = append(dates, date)
+			}
+		}
+		historyScope.Dates = dates
+
+		for seriesKey, series := range historyScope.Series {
+			for date := range series.Costs {
+				if date < cutoffDate {
+					delete(series.Costs, date)
+				}
+			}
+
+			if len(series.Costs) == 0 {
+				delete(historyScope.Series, seriesKey)
+			}
+		}
+
+		if len(historyScope.Dates) == 0 {
+			delete(h.Scopes, scopeKey)
+			continue
+		}
+
+		// last complete day wasn't collected (eg. removed subscription or failed runs)
+		evaluationIndex := sort.SearchStrings(dates, evaluationDate)
+		if evaluationIndex >= len(dates) || dates[evaluationIndex] != evaluationDate {
+			continue
+		}
+
+		// baseline: up to days before the evaluated day, at least half of the days needed
+		baselineDates := dates[:evaluationIndex]
+		if len(baselineDates) > days {
+			baselineDates = baselineDates[len(baselineDates)-days:]
+		}
+		if len(baselineDates) == 0 || len(baselineDates) < days/2 {
+			continue
+		}
+
+		for _, series := range historyScope.Series {
+			baseline := float64(0)
+			for _, date := range baselineDates {
+				baseline += series.Costs[date]
+			}
+			baseline /= float64(len(baselineDates))
+
+			if baseline <= 0 {
+				continue
+			}
+
+			labels := prometheus.Labels{}
+			for name, value := range series.Labels {
+				labels[name] = value
+			}
+			labels["date"] = evaluationDate
+
+			result := CostsAnomalyResult{
+				Query:  historyScope.Query,
+				Labels: labels,
+				Score:  series.Costs[evaluationDate] / baseline,
+			}
+			switch {
+			case result.Score >= factor:
+				result.Anomaly = 1
+			case result.Score <= 1/factor:
+				result.Anomaly = -1
+			}
+			results = append(results, result)
+		}
+	}
+
+	return
+}
+
+// scope returns the history of the query, scope labels and export type (has to be called with lock)
+func (h *CostsAnomalyHistory) scope(query MetricsCollectorAzureRmCostsQuery, labels prometheus.Labels) *CostsAnomalyScope {
+	scopeLabelNames := append(query.ScopeLabelNames(), "exportType")
+	scopeKey := query.Name + "\x00" + labelsKey(labels, scopeLabelNames)
+
+	historyScope, exists := h.Scopes[scopeKey]
+	if !exists {
+		historyScope = &CostsAnomalyScope{
+			Query:  query.Name,
+			Series: map[string]*CostsAnomalySeries{},
+		}
+		h.Scopes[scopeKey] = historyScope
+	}
+
+	if historyScope.Series == nil {
+		historyScope.Series = map[string]*CostsAnomalySeries{}
+	}
+
+	return historyScope
+}
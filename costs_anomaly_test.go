@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCostsAnomalyHistoryEvaluate(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+
+		// collected are the collected days (days ago), costs are the costs of the series by days ago
+		collected []int
+		costs     map[int]float64
+
+		results int
+		date    string
+		score   float64
+		anomaly int
+	}{
+		{
+			name:      "steady costs",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 1: 10, 0: 10},
+			results:   1,
+			date:      "2023-03-14",
+			score:     1,
+			anomaly:   0,
+		},
+		{
+			name:      "higher costs",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 1: 30, 0: 10},
+			results:   1,
+			date:      "2023-03-14",
+			score:     3,
+			anomaly:   1,
+		},
+		{
+			name:      "score equals factor",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 1: 20, 0: 10},
+			results:   1,
+			date:      "2023-03-14",
+			score:     2,
+			anomaly:   1,
+		},
+		{
+			name:      "lower costs",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 1: 4, 0: 10},
+			results:   1,
+			date:      "2023-03-14",
+			score:     0.4,
+			anomaly:   -1,
+		},
+		{
+			name:      "no costs of the day",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10},
+			results:   1,
+			date:      "2023-03-14",
+			score:     0,
+			anomaly:   -1,
+		},
+		{
+			name:      "incomplete costs of today are ignored",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1, 0},
+			costs:     map[int]float64{8: 10, 7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 1: 10, 0: 1},
+			results:   1,
+			date:      "2023-03-14",
+			score:     1,
+			anomaly:   0,
+		},
+		{
+			name:      "missing costs of collected days are zero",
+			collected: []int{8, 7, 6, 5, 4, 3, 2, 1},
+			costs:     map[int]float64{8: 14, 6: 14, 4: 14, 2: 14, 1: 16},
+			results:   1,
+			date:      "2023-03-14",
+			score:     2,
+			anomaly:   1,
+		},
+		{
+			name:      "baseline is limited to the days",
+			collected: []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+			costs:     map[int]float64{10: 1000, 9: 1000, 8: 5, 7: 5, 6: 5, 5: 5, 4: 5, 3: 5, 2: 5, 1: 5},
+			results:   1,
+			date:      "2023-03-14",
+			score:     1,
+			anomaly:   0,
+		},
+		{
+			name:      "not enough baseline days",
+			collected: []int{3, 2, 1},
+			costs:     map[int]float64{3: 10, 2: 10, 1: 100},
+			results:   0,
+		},
+		{
+			name:      "no baseline costs",
+			collected: []int{5, 4, 3, 2, 1},
+			costs:     map[int]float64{1: 100},
+			results:   0,
+		},
+		{
+			name:      "last complete day not collected",
+			collected: []int{7, 6, 5, 4, 3, 2, 0},
+			costs:     map[int]float64{7: 10, 6: 10, 5: 10, 4: 10, 3: 10, 2: 10, 0: 50},
+			results:   0,
+		},
+	}
+
+	query := MetricsCollectorAzureRmCostsQuery{Name: "by_location", Dimensions: []string{"ResourceLocation"}}
+	scopeLabels := prometheus.Labels{"tenantID": "tenant", "subscriptionID": "subscription"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := newCostsAnomalyHistory()
+
+			for daysAgo, value := range test.costs {
+				labels := prometheus.Labels{
+					"tenantID":         "tenant",
+					"subscriptionID":   "subscription",
+					"exportType":       "ActualCost",
+					"ResourceLocation": "westeurope",
+					"date":             now.AddDate(0, 0, -daysAgo).Format(dayFormat),
+				}
+				history.Add(query, labels, value)
+			}
+
+			for _, daysAgo := range test.collected {
+				day := now.AddDate(0, 0, -daysAgo)
+				history.Collected(query, scopeLabels, "ActualCost", day, day)
+			}
+
+			results := history.Evaluate(now, 7, 2)
+			if len(results) != test.results {
+				t.Fatalf("expected %d results, got %+v", test.results, results)
+			}
+
+			if test.results == 0 {
+				return
+			}
+
+			result := results[0]
+			if result.Query != query.Name {
+				t.Errorf("expected query %v, got %v", query.Name, result.Query)
+			}
+
+			if result.Labels["date"] != test.date || result.Labels["ResourceLocation"] != "westeurope" {
+				t.Errorf("expected date %v and location label, got %v", test.date, result.Labels)
+			}
+
+			if math.Abs(result.Score-test.score) > 0.0001 {
+				t.Errorf("expected score %v, got %v", test.score, result.Score)
+			}
+
+			if result.Anomaly != test.anomaly {
+				t.Errorf("expected anomaly %v, got %v", test.anomaly, result.Anomaly)
+			}
+		})
+	}
+}
+
+func TestCostsAnomalyHistoryEvaluateCleanup(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+	query := MetricsCollectorAzureRmCostsQuery{Name: "by_location", Dimensions: []string{"ResourceLocation"}}
+	scopeLabels := prometheus.Labels{"tenantID": "tenant", "subscriptionID": "subscription"}
+
+	history := newCostsAnomalyHistory()
+	for _, daysAgo := range []int{20, 10, 9, 8, 1, 0} {
+		day := now.AddDate(0, 0, -daysAgo)
+		history.Add(query, prometheus.Labels{
+			"tenantID":       "tenant",
+			"subscriptionID": "subscription",
+			"exportType":     "ActualCost",
+			"date":           day.Format(dayFormat),
+		}, 10)
+		history.Collected(query, scopeLabels, "ActualCost", day, day)
+	}
+
+	// removed subscription, only old days are left
+	history.Collected(query, prometheus.Labels{"tenantID": "tenant", "subscriptionID": "removed"}, "ActualCost", now.AddDate(0, 0, -30), now.AddDate(0, 0, -20))
+
+	history.Evaluate(now, 7, 2)
+
+	if len(history.Scopes) != 1 {
+		t.Fatalf("expected 1 scope, got %d", len(history.Scopes))
+	}
+
+	for _, historyScope := range history.Scopes {
+		expectedDates := []string{"2023-03-06", "2023-03-07", "2023-03-14", "2023-03-15"}
+		if len(historyScope.Dates) != len(expectedDates) {
+			t.Fatalf("expected dates %v, got %v", expectedDates, historyScope.Dates)
+		}
+		for num, date := range expectedDates {
+			if historyScope.Dates[num] != date {
+				t.Errorf("expected dates %v, got %v", expectedDates, historyScope.Dates)
+			}
+		}
+
+		for _, series := range historyScope.Series {
+			if len(series.Costs) != len(expectedDates) {
+				t.Errorf("expected costs of %v, got %v", expectedDates, series.Costs)
+			}
+		}
+	}
+}
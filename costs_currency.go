@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+type (
+	// CostsCurrencyRates converts cost values to --costs.currency.target using the static rates
+	// and the rates file (reloaded if changed, previous rates are kept on errors)
+	CostsCurrencyRates struct {
+		lock sync.Mutex
+
+		fileRates     map[string]float64
+		fileModTime   time.Time
+		fileLastCheck time.Time
+
+		// currencies without rate (only logged once)
+		missing map[string]bool
+	}
+)
+
+var (
+	costsCurrencyRates = &CostsCurrencyRates{}
+)
+
+// Convert converts the value from the currency to the target currency, returns false if no rate is available
+func (r *CostsCurrencyRates) Convert(opts *config.Opts, currency string, value float64) (float64, bool) {
+	target := strings.ToUpper(opts.Costs.CurrencyTarget)
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+
+	if currency == target {
+		return value, true
+	}
+
+	rates := r.rates(opts)
+	if rate, exists := rates[currency]; exists {
+		return value * rate, true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.missing == nil {
+		r.missing = map[string]bool{}
+	}
+	if !r.missing[currency] {
+		log.Warnf(`no currency rate for "%v" to "%v" found, values are not normalized`, currency, target)
+		r.missing[currency] = true
+	}
+
+	return 0, false
+}
+
+// rates returns the static rates merged with the rates of the rates file
+func (r *CostsCurrencyRates) rates(opts *config.Opts) map[string]float64 {
+	// validated by argparser
+	rates, _ := config.ParseCurrencyRates(opts.Costs.CurrencyRates)
+
+	if opts.Costs.CurrencyRatesFile == "" {
+		return rates
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.fileLastCheck.IsZero() || time.Since(r.fileLastCheck) >= opts.Costs.CurrencyRatesRefresh {
+		r.fileLastCheck = time.Now()
+
+		stat, err := os.Stat(opts.Costs.CurrencyRatesFile)
+		switch {
+		case err != nil:
+			log.Errorf("currency rates file check failed, keeping previous rates: %v", err)
+		case r.fileRates == nil || !stat.ModTime().Equal(r.fileModTime):
+			if fileRates, err := config.ReadCurrencyRatesFile(opts.Costs.CurrencyRatesFile); err == nil {
+				log.Infof(`loaded %v currency rates from "%v"`, len(fileRates), opts.Costs.CurrencyRatesFile)
+				r.fileRates = fileRates
+				r.fileModTime = stat.ModTime()
+				r.missing = nil
+			} else {
+				log.Errorf("currency rates file reload failed, keeping previous rates: %v", err)
+			}
+		}
+	}
+
+	for currency, rate := range r.fileRates {
+		rates[currency] = rate
+	}
+
+	return rates
+}
+
+// registerCostsNormalizedMetricList registers the <name>_normalized metric of a cost metric (if --costs.currency.target is set),
+// currencyLabel is the label containing the currency (added if the metric doesn't have it)
+func registerCostsNormalizedMetricList(c *Collector, opts *config.Opts, listName, name, help string, labels []string, currencyLabel string) {
+	if opts.Costs.CurrencyTarget == "" {
+		return
+	}
+
+	normalizedLabels := append([]string{}, labels...)
+	if !stringInSliceCI(currencyLabel, normalizedLabels) {
+		normalizedLabels = append(normalizedLabels, currencyLabel)
+	}
+	normalizedLabels = append(normalizedLabels, "originalCurrency")
+
+	gaugeVec := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name + "_normalized",
+			Help: help + " (converted to --costs.currency.target)",
+		},
+		normalizedLabels,
+	)
+	c.RegisterMetricList(listName+":normalized", gaugeVec, true)
+}
+
+// addCostsNormalizedMetric adds the value converted to the target currency to the <name>_normalized metric
+func addCostsNormalizedMetric(c *Collector, opts *config.Opts, listName string, labels prometheus.Labels, currencyLabel, currency string, value float64) {
+	if opts.Costs.CurrencyTarget == "" || currency == "" {
+		return
+	}
+
+	normalizedValue, ok := costsCurrencyRates.Convert(opts, currency, value)
+	if !ok {
+		return
+	}
+
+	normalizedLabels := copyPrometheusLabels(labels)
+	normalizedLabels[currencyLabel] = strings.ToLower(opts.Costs.CurrencyTarget)
+	normalizedLabels["originalCurrency"] = strings.ToLower(currency)
+
+	c.GetMetricList(listName+":normalized").Add(normalizedLabels, normalizedValue)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// httpCollectorRunHandler handles POST /collectors/{name}/run and triggers an immediate run of a collector
+// the response is sent after the run is finished, within the write timeout of the server (or immediately with ?wait=false)
+func httpCollectorRunHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/collectors/"), "/"), "/")
+	if len(pathParts) != 2 || pathParts[1] != "run" {
+		http.NotFound(w, r)
+		return
+	}
+	collectorName := pathParts[0]
+
+	runToken := currentOpts().CollectorRun.Token
+	if runToken == "" {
+		http.Error(w, "collector run endpoint is disabled (no token configured)", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(runToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !collectorNameIsValid(collectorName) {
+		http.Error(w, fmt.Sprintf(`collector "%v" not found (valid collectors: %v)`, collectorName, strings.Join(collectorNames(), ", ")), http.StatusNotFound)
+		return
+	}
+
+	managedCollector := collectorManager.Get(collectorName)
+	if managedCollector == nil {
+		http.Error(w, fmt.Sprintf(`collector "%v" is disabled`, collectorName), http.StatusConflict)
+		return
+	}
+
+	contextLogger := log.WithFields(log.Fields{
+		"collector":  managedCollector.Definition.Name,
+		"remoteAddr": r.RemoteAddr,
+	})
+
+	alreadyRunningMessage := fmt.Sprintf(`collector "%v" is already running`, managedCollector.Definition.Name)
+
+	result := managedCollector.TryRunAsync()
+	if result == nil {
+		http.Error(w, alreadyRunningMessage, http.StatusConflict)
+		return
+	}
+	contextLogger.Info("collector run triggered")
+
+	if r.URL.Query().Get("wait") == "false" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// wait for the run only within the write timeout of the server,
+	// longer runs are continued in background and answered with 202
+	var timeout <-chan time.Time
+	if server, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && server.WriteTimeout > 0 {
+		timer := time.NewTimer(server.WriteTimeout - server.WriteTimeout/10)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case err = <-result:
+	case <-timeout:
+		contextLogger.Info("collector run exceeds the write timeout, continuing in background")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(managedCollector.Status()); err != nil {
+			log.Error(err)
+		}
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if err := json.NewEncoder(w).Encode(managedCollector.Status()); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// toolkitLogger passes the log messages of the exporter-toolkit (go-kit logger) to logrus
+	toolkitLogger struct{}
+)
+
+// Log logs the go-kit key/value pairs as logrus fields ("msg" is the message, "level" the log level)
+func (l toolkitLogger) Log(keyvals ...interface{}) error {
+	fields := log.Fields{}
+	message := ""
+	logLevel := log.InfoLevel
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprintf("%v", keyvals[i])
+		switch key {
+		case "msg":
+			message = fmt.Sprintf("%v", keyvals[i+1])
+		case "level":
+			if value, ok := keyvals[i+1].(level.Value); ok {
+				if parsedLevel, err := log.ParseLevel(value.String()); err == nil {
+					logLevel = parsedLevel
+				}
+			}
+		default:
+			fields[key] = keyvals[i+1]
+		}
+	}
+
+	log.WithFields(fields).Log(logLevel, message)
+	return nil
+}
+
+// httpBearerTokenHandler requires the bearer token for all requests except /healthz, /readyz and /collectors/
+// (uses its own token), the token file is read on each request so changed tokens are used without restart
+func httpBearerTokenHandler(next http.Handler, bearerToken, bearerTokenFile string) http.Handler {
+	if bearerToken == "" && bearerTokenFile == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz", r.URL.Path == "/readyz", strings.HasPrefix(r.URL.Path, "/collectors/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expectedToken := bearerToken
+		if bearerTokenFile != "" {
+			content, err := os.ReadFile(bearerTokenFile) // #nosec G304 path is set by the user
+			if err != nil {
+				log.Errorf(`unable to read bearer token file "%s": %v`, bearerTokenFile, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			expectedToken = strings.TrimSpace(string(content))
+		}
+
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if expectedToken == "" || token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(expectedToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
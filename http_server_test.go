@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHttpBearerTokenHandler(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := httpBearerTokenHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "", tokenFile)
+
+	request := func(path, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		path, authorization string
+		expected            int
+	}{
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "Bearer wrong", http.StatusUnauthorized},
+		{"/metrics", "Bearer first", http.StatusOK},
+		{"/healthz", "", http.StatusOK},
+		{"/readyz", "", http.StatusOK},
+	} {
+		if code := request(tc.path, tc.authorization); code != tc.expected {
+			t.Errorf("%v with %q: expected %v, got %v", tc.path, tc.authorization, tc.expected, code)
+		}
+	}
+
+	// changed token file is used without restart
+	if err := os.WriteFile(tokenFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code := request("/metrics", "Bearer first"); code != http.StatusUnauthorized {
+		t.Errorf("expected old token to be rejected, got %v", code)
+	}
+	if code := request("/metrics", "Bearer second"); code != http.StatusOK {
+		t.Errorf("expected new token to be accepted, got %v", code)
+	}
+}
@@ -1,21 +1,21 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync/atomic"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	log "github.com/sirupsen/logrus"
-	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/azuresdk/prometheus/tracing"
-	"github.com/webdevops/go-common/msgraphsdk/msgraphclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 
 	"github.com/webdevops/azure-resourcemanager-exporter/config"
 )
@@ -27,14 +27,9 @@ const (
 
 var (
 	argparser *flags.Parser
-	opts      config.Opts
 
-	AzureClient                *armclient.ArmClient
-	AzureSubscriptionsIterator *armclient.SubscriptionsIterator
-
-	MsGraphClient *msgraphclient.MsGraphClient
-
-	portscanPortRange []Portrange
+	// liveConfig is the current config, replaced as a whole on config reloads
+	liveConfig atomic.Pointer[RuntimeConfig]
 
 	portrangeRegexp = regexp.MustCompile("^(?P<first>[0-9]+)(-(?P<last>[0-9]+))?$")
 
@@ -48,115 +43,77 @@ type Portrange struct {
 	LastPort  int
 }
 
+// RuntimeConfig is the parsed config and the objects created from it, it's never modified after creation
+type RuntimeConfig struct {
+	Opts *config.Opts
+
+	// tenant from --azure.tenant and tenants from the config file
+	AzureTenants []*AzureTenant
+
+	PortscanPortRange []Portrange
+}
+
+// currentConfig returns the live config (collectors use the config snapshot of their run instead)
+func currentConfig() *RuntimeConfig {
+	return liveConfig.Load()
+}
+
+// currentOpts returns the options of the live config
+func currentOpts() *config.Opts {
+	return currentConfig().Opts
+}
+
 func main() {
 	initArgparser()
 	initLogger()
 
+	opts := currentOpts()
 	log.Infof("starting azure-resourcemanager-exporter v%s (%s; %s; by %v)", gitTag, gitCommit, runtime.Version(), Author)
 	log.Info(string(opts.GetJson()))
 
 	log.Infof("init Azure connection")
 	initAzureConnection()
 
+	initCollectorStatusMetrics()
+	initCostsRateLimitMetrics()
+
+	if opts.Oneshot.Enabled {
+		log.Infof("running collectors once (oneshot mode)")
+		os.Exit(runOneshot())
+	}
+
 	log.Infof("starting metrics collection")
 	initMetricCollector()
 
+	initConfigReload()
+
 	log.Infof("starting http server on %s", opts.Server.Bind)
 	startHttpServer()
 }
 
 func initArgparser() {
-	argparser = flags.NewParser(&opts, flags.Default)
-	_, err := argparser.Parse()
-
-	// check if there is an parse error
+	var err error
+	opts := &config.Opts{}
+	argparser, err = argparserParse(opts)
 	if err != nil {
-		var flagsErr *flags.Error
-		if ok := errors.As(err, &flagsErr); ok && flagsErr.Type == flags.ErrHelp {
-			os.Exit(0)
-		} else {
-			fmt.Println()
-			argparser.WriteHelp(os.Stdout)
-			os.Exit(1)
-		}
+		argparserExit(err)
 	}
 
+	runtimeConfig := &RuntimeConfig{Opts: opts}
 	if opts.Portscan.Enabled {
 		// parse --portscan-range
-		err := argparserParsePortrange()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v%v\n", "[ERROR] ", err.Error())
-			fmt.Println()
-			argparser.WriteHelp(os.Stdout)
-			os.Exit(1)
+		if runtimeConfig.PortscanPortRange, err = argparserParsePortrange(opts); err != nil {
+			argparserExit(err)
 		}
 	}
 
-	// deprecated option
-	if len(opts.Azure.ResourceGroupTags) > 0 {
-		opts.Azure.ResourceTags = opts.Azure.ResourceGroupTags
-	}
-
-	// scrape time
-	if opts.Scrape.TimeGeneral == nil {
-		opts.Scrape.TimeGeneral = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeResource == nil {
-		opts.Scrape.TimeResource = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeQuota == nil {
-		opts.Scrape.TimeQuota = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeCosts == nil {
-		opts.Scrape.TimeCosts = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeIam == nil {
-		opts.Scrape.TimeIam = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeSecurity == nil {
-		opts.Scrape.TimeSecurity = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeResourceHealth == nil {
-		opts.Scrape.TimeResourceHealth = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimeGraph == nil {
-		opts.Scrape.TimeGraph = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimePortscan == nil {
-		opts.Scrape.TimePortscan = &opts.Scrape.Time
-	}
-
-	if opts.Scrape.TimePortscan == nil || opts.Scrape.TimePortscan.Seconds() == 0 && opts.Portscan.Enabled {
-		log.Fatalf(`portscan is enabled but has invalid scape time (zero)`)
-	}
-
-	// check deprecated env vars
-	deprecatedEnvVars := map[string]string{
-		"SCRAPE_TIME_CONTAINERREGISTRY": "not supported anymore",
-		"SCRAPE_TIME_CONTAINERINSTANCE": "not supported anymore",
-		"SCRAPE_TIME_EVENTHUB":          "not supported anymore",
-		"SCRAPE_TIME_STORAGE":           "not supported anymore",
-		"SCRAPE_TIME_COMPUTE":           "not supported anymore",
-		"SCRAPE_TIME_NETWORK":           "not supported anymore",
-		"SCRAPE_TIME_DATABASE":          "not supported anymore",
-		"SCRAPE_TIME_COMPUTING":         "deprecated, please use SCRAPE_TIME_COMPUTE",
-	}
-	for envVar, reason := range deprecatedEnvVars {
-		if os.Getenv(envVar) != "" {
-			log.Fatalf("env var %v is %v", envVar, reason)
-		}
-	}
+	liveConfig.Store(runtimeConfig)
 }
 
 func initLogger() {
+	opts := currentOpts()
+	log.SetLevel(log.InfoLevel)
+
 	// verbose level
 	if opts.Logger.Debug {
 		log.SetLevel(log.DebugLevel)
@@ -190,140 +147,34 @@ func initLogger() {
 }
 
 func initAzureConnection() {
-	var err error
-	AzureClient, err = armclient.NewArmClientWithCloudName(*opts.Azure.Environment, log.StandardLogger())
+	runtimeConfig := *currentConfig()
+
+	tenants, err := newAzureTenants(runtimeConfig.Opts)
 	if err != nil {
 		log.Panic(err.Error())
 	}
 
-	AzureClient.SetUserAgent(UserAgent + gitTag)
-
-	// limit subscriptions (if filter is set)
-	if len(opts.Azure.Subscription) >= 1 {
-		AzureClient.SetSubscriptionFilter(opts.Azure.Subscription...)
-	}
-
-	AzureSubscriptionsIterator = armclient.NewSubscriptionIterator(AzureClient)
+	runtimeConfig.AzureTenants = tenants
+	liveConfig.Store(&runtimeConfig)
 }
 
-func initMsGraphConnection() {
-	var err error
-	if MsGraphClient == nil {
-		MsGraphClient, err = msgraphclient.NewMsGraphClientWithCloudName(*opts.Azure.Environment, *opts.Azure.Tenant, log.StandardLogger())
-		if err != nil {
+func initMsGraphConnection(runtimeConfig *RuntimeConfig) {
+	for _, tenant := range runtimeConfig.AzureTenants {
+		if _, err := tenant.MsGraphClient(); err != nil {
 			log.Panic(err.Error())
 		}
-
-		MsGraphClient.SetUserAgent(UserAgent + gitTag)
 	}
 }
 
 func initMetricCollector() {
-	var collectorName string
-
-	collectorName = "General"
-	if opts.Scrape.TimeGeneral.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmGeneral{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeGeneral)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "Resource"
-	if opts.Scrape.TimeResource.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmResources{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeResource)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "Quota"
-	if opts.Scrape.TimeQuota.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmQuota{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeQuota)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "Costs"
-	if opts.Scrape.TimeCosts.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmCosts{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeCosts)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "Security"
-	if opts.Scrape.TimeSecurity.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmSecurity{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeSecurity)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "ResourceHealth"
-	if opts.Scrape.TimeResourceHealth.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorAzureRmHealth{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeResourceHealth)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "IAM"
-	if opts.Scrape.TimeIam.Seconds() > 0 {
-		initMsGraphConnection()
-		c := collector.New(collectorName, &MetricsCollectorAzureRmIam{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeIam)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "GraphApps"
-	if opts.Scrape.TimeGraph.Seconds() > 0 {
-		initMsGraphConnection()
-		c := collector.New(collectorName, &MetricsCollectorGraphApps{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimeGraph)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
-	}
-
-	collectorName = "Portscan"
-	if opts.Portscan.Enabled && opts.Scrape.TimePortscan.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorPortscanner{}, log.StandardLogger())
-		c.SetScapeTime(*opts.Scrape.TimePortscan)
-		if err := c.Start(); err != nil {
-			log.Panic(err.Error())
-		}
-	} else {
-		log.WithField("collector", collectorName).Infof("collector disabled")
+	if err := collectorManager.Apply(currentConfig()); err != nil {
+		log.Panic(err.Error())
 	}
 }
 
 // start and handle prometheus handler
+// TLS, mTLS, basic auth and http headers are handled by the exporter-toolkit which reads the web config
+// on each request and TLS handshake (server settings and whether TLS is enabled need a restart)
 func startHttpServer() {
 	mux := http.NewServeMux()
 
@@ -334,22 +185,61 @@ func startHttpServer() {
 		}
 	})
 
-	// readyz
+	// readyz (ready after required collectors finished their first successful run)
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if ready, notReadyCollectors := collectorManager.Ready(currentOpts().Readiness.Collectors); !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if _, err := fmt.Fprintf(w, "Not ready, waiting for collectors: %v", strings.Join(notReadyCollectors, ", ")); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
 			log.Error(err)
 		}
 	})
 
-	mux.Handle("/metrics", collector.HttpWaitForRlock(
-		tracing.RegisterAzureMetricAutoClean(promhttp.Handler())),
+	// status
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		ready, _ := collectorManager.Ready(currentOpts().Readiness.Collectors)
+		status := struct {
+			Ready      bool              `json:"ready"`
+			Collectors []CollectorStatus `json:"collectors"`
+		}{
+			Ready:      ready,
+			Collectors: collectorManager.Status(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Error(err)
+		}
+	})
+
+	// trigger collector runs
+	mux.HandleFunc("/collectors/", httpCollectorRunHandler)
+
+	// metrics of all running collectors (each collector uses its own registry)
+	metricsHandler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(collectorManager, promhttp.HandlerOpts{}),
 	)
+	mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(metricsHandler))
 
+	opts := currentOpts()
 	srv := &http.Server{
-		Addr:         opts.Server.Bind,
-		Handler:      mux,
+		Handler:      httpBearerTokenHandler(mux, opts.Server.BearerToken, opts.Server.BearerTokenFile),
 		ReadTimeout:  opts.Server.ReadTimeout,
 		WriteTimeout: opts.Server.WriteTimeout,
 	}
-	log.Fatal(srv.ListenAndServe())
+
+	systemdSocket := false
+	webFlags := &web.FlagConfig{
+		WebListenAddresses: &[]string{opts.Server.Bind},
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &opts.Server.WebConfig,
+	}
+
+	log.Fatal(web.ListenAndServe(srv, webFlags, toolkitLogger{}))
 }
@@ -1,20 +1,22 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/consumption/armconsumption"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
 )
 
 const (
@@ -23,51 +25,143 @@ const (
 
 type (
 	MetricsCollectorAzureRmCosts struct {
-		collector.Processor
+		CollectorProcessor
 
 		queries map[string]MetricsCollectorAzureRmCostsQuery
 
+		// anomalyHistory is the history of daily costs for anomaly detection (nil if disabled)
+		anomalyHistory *CostsAnomalyHistory
+
+		// anomalyLabelNames are the labels of the anomaly metrics of the queries (labels of the daily costs of the query)
+		anomalyLabelNames map[string][]string
+
 		prometheus struct {
 			consumptionBudgetInfo    *prometheus.GaugeVec
 			consumptionBudgetLimit   *prometheus.GaugeVec
 			consumptionBudgetCurrent *prometheus.GaugeVec
 			consumptionBudgetUsage   *prometheus.GaugeVec
 
+			consumptionBudgetForecast     *prometheus.GaugeVec
+			consumptionBudgetTimePeriod   *prometheus.GaugeVec
+			consumptionBudgetNotification *prometheus.GaugeVec
+			consumptionBudgetFilter       *prometheus.GaugeVec
+
 			costmanagementOverallUsage      *prometheus.GaugeVec
 			costmanagementOverallActualCost *prometheus.GaugeVec
 		}
 	}
 
 	MetricsCollectorAzureRmCostsQuery struct {
-		Name       string
-		Dimensions []string
+		Name        string
+		Dimensions  []string
+		Filter      *config.CostQueryFilter
+		ExportTypes []string
+		DailyDays   int
+		Timeframes  []string
+		TimePeriods []config.CostTimePeriod
+		Scopes      []config.CostQueryScope
+
+		// LocalFilters are applied to the result rows (expressions not supported by the Cost Management API)
+		LocalFilters []*config.CostQueryFilter
 	}
 )
 
-func (m *MetricsCollectorAzureRmCosts) collectQueries() {
-	m.queries = map[string]MetricsCollectorAzureRmCostsQuery{}
+// buildCostQueries returns the cost queries from flags, config file and env vars
+func buildCostQueries(opts *config.Opts) (map[string]MetricsCollectorAzureRmCostsQuery, error) {
+	queries := map[string]MetricsCollectorAzureRmCostsQuery{}
 
-	addQuery := func(query MetricsCollectorAzureRmCostsQuery) {
+	addQuery := func(query MetricsCollectorAzureRmCostsQuery) error {
 		query.Name = strings.ToLower(strings.TrimSpace(query.Name))
 
-		if _, exists := m.queries[query.Name]; exists {
-			m.Logger().Fatalf(`found duplicate query config name "%v"`, query.Name)
+		if _, exists := queries[query.Name]; exists {
+			return fmt.Errorf(`found duplicate query config name "%v"`, query.Name)
+		}
+
+		if query.DailyDays < 0 {
+			return fmt.Errorf(`query "%v": dailyDays cannot be negative`, query.Name)
+		}
+
+		query.Filter, query.LocalFilters = splitCostQueryFilter(query.Filter)
+		for _, localFilter := range query.LocalFilters {
+			for _, name := range localFilter.Names() {
+				if !stringInSliceCI(name, query.Dimensions) {
+					return fmt.Errorf(`query "%v": filter with not can only use dimensions of the query, "%v" is not a dimension of the query`, query.Name, name)
+				}
+			}
+		}
+
+		exportTypes := query.ExportTypes
+		if len(exportTypes) == 0 {
+			exportTypes = opts.Costs.ExportTypes
 		}
-		m.queries[query.Name] = query
+
+		query.ExportTypes = []string{}
+		for _, val := range exportTypes {
+			exportType, err := config.NormalizeCostExportType(val)
+			if err != nil {
+				return fmt.Errorf(`query "%v": %w`, query.Name, err)
+			}
+
+			if !stringInSliceCI(exportType, query.ExportTypes) {
+				query.ExportTypes = append(query.ExportTypes, exportType)
+			}
+		}
+
+		timeframes := query.Timeframes
+		query.Timeframes = []string{}
+		for _, val := range timeframes {
+			timeframe, err := config.NormalizeCostTimeframe(val)
+			if err != nil {
+				return fmt.Errorf(`query "%v": %w`, query.Name, err)
+			}
+
+			if !stringInSliceCI(timeframe, query.Timeframes) {
+				query.Timeframes = append(query.Timeframes, timeframe)
+			}
+		}
+
+		queries[query.Name] = query
+		return nil
 	}
 
 	for _, queryConfig := range opts.Costs.Queries {
 		if !strings.Contains(queryConfig, "=") {
-			m.Logger().Fatalf(`query config "%v" is not valid`, queryConfig)
+			return nil, fmt.Errorf(`query config "%v" is not valid`, queryConfig)
 		}
 
-		query := MetricsCollectorAzureRmCostsQuery{}
+		query := MetricsCollectorAzureRmCostsQuery{
+			DailyDays: opts.Costs.DailyDays,
+		}
 
 		queryConfigParts := strings.SplitN(queryConfig, "=", 2)
 		query.Name = queryConfigParts[0]
 		query.Dimensions = strings.Split(queryConfigParts[1], ",")
 
-		addQuery(query)
+		if err := addQuery(query); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, queryConfig := range opts.Costs.QueryConfigs {
+		query := MetricsCollectorAzureRmCostsQuery{
+			Name:        queryConfig.Name,
+			Dimensions:  queryConfig.Dimensions,
+			Filter:      queryConfig.Filter,
+			ExportTypes: queryConfig.ExportTypes,
+			DailyDays:   opts.Costs.DailyDays,
+			Timeframes:  queryConfig.Timeframes,
+			TimePeriods: queryConfig.TimePeriods,
+			Scopes:      queryConfig.Scopes,
+		}
+
+		if queryConfig.DailyDays != nil {
+			query.DailyDays = *queryConfig.DailyDays
+		}
+
+		err := addQuery(query)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	for _, val := range os.Environ() {
@@ -76,20 +170,135 @@ func (m *MetricsCollectorAzureRmCosts) collectQueries() {
 		envVal := envParts[1]
 
 		if strings.HasPrefix(envName, CostsQueryEnvVarPrefix) {
-			query := MetricsCollectorAzureRmCostsQuery{}
+			query := MetricsCollectorAzureRmCostsQuery{
+				DailyDays: opts.Costs.DailyDays,
+			}
 			query.Name = strings.TrimPrefix(envName, CostsQueryEnvVarPrefix)
 			query.Dimensions = strings.Split(envVal, ",")
 
-			addQuery(query)
+			if err := addQuery(query); err != nil {
+				return nil, err
+			}
 		}
 	}
+
+	return queries, nil
+}
+
+// ScopeLabelNames returns the labels identifying the scope of the query results
+// (subscription or scope for queries with scopes)
+func (q *MetricsCollectorAzureRmCostsQuery) ScopeLabelNames() []string {
+	if len(q.Scopes) > 0 {
+		return []string{"tenantID", "scope"}
+	}
+
+	return []string{"tenantID", "subscriptionID"}
 }
 
-func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
-	m.Collector.SetCache(opts.GetCachePath("costs.json"))
+// MatchesLocalFilters checks if the dimension values of a result row match the local filters of the query
+func (q *MetricsCollectorAzureRmCostsQuery) MatchesLocalFilters(dimensionValues map[string]string) bool {
+	for _, filter := range q.LocalFilters {
+		if !filter.Matches(dimensionValues) {
+			return false
+		}
+	}
 
-	m.collectQueries()
+	return true
+}
+
+// splitCostQueryFilter splits the filter into the part supported by the Cost Management API and
+// the expressions with not which have to be applied to the result rows (combined with and)
+func splitCostQueryFilter(filter *config.CostQueryFilter) (*config.CostQueryFilter, []*config.CostQueryFilter) {
+	if filter == nil || !filter.HasNot() {
+		return filter, nil
+	}
+
+	if len(filter.And) == 0 {
+		// not or an or containing not cannot be sent to the api
+		return nil, []*config.CostQueryFilter{filter}
+	}
+
+	apiFilters := []*config.CostQueryFilter{}
+	localFilters := []*config.CostQueryFilter{}
+	for _, expr := range filter.And {
+		apiFilter, exprLocalFilters := splitCostQueryFilter(expr)
+		if apiFilter != nil {
+			apiFilters = append(apiFilters, apiFilter)
+		}
+		localFilters = append(localFilters, exprLocalFilters...)
+	}
+
+	switch len(apiFilters) {
+	case 0:
+		return nil, localFilters
+	case 1:
+		return apiFilters[0], localFilters
+	default:
+		return &config.CostQueryFilter{And: apiFilters}, localFilters
+	}
+}
+
+// costDimensionLabelName returns the prometheus label name for a cost dimension
+func costDimensionLabelName(dimension string) string {
+	switch {
+	case strings.EqualFold(dimension, "ResourceGroupName"):
+		return "resourceGroup"
+	default:
+		return prometheusLabelReplacerRegExp.ReplaceAllString(dimension, "_")
+	}
+}
+
+// costQueryGrouping converts the dimensions to costmanagement query groupings ("tag:name" for tags)
+func costQueryGrouping(dimensions []string) ([]*armcostmanagement.QueryGrouping, error) {
+	queryGrouping := []*armcostmanagement.QueryGrouping{}
+
+	for _, row := range dimensions {
+		dimension := row
+
+		dimensionType := armcostmanagement.QueryColumnTypeDimension
+
+		if strings.Contains(dimension, ":") {
+			dimensionParts := strings.SplitN(dimension, ":", 2)
+			switch strings.ToLower(dimensionParts[0]) {
+			case "tag":
+				dimensionType = armcostmanagement.QueryColumnTypeTag
+				dimension = dimensionParts[1]
+			default:
+				return nil, fmt.Errorf(`cost dimension %v is not supported`, dimension)
+			}
+		}
+
+		queryGrouping = append(
+			queryGrouping,
+			&armcostmanagement.QueryGrouping{
+				Name: &dimension,
+				Type: &dimensionType,
+			},
+		)
+	}
+
+	return queryGrouping, nil
+}
+
+func (m *MetricsCollectorAzureRmCosts) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
+	m.Collector.SetCache(m.Opts().GetCachePath("costs.json"))
+
+	queries, err := buildCostQueries(m.Opts())
+	if err != nil {
+		m.Logger().Panic(err)
+	}
+	m.queries = queries
+
+	if m.Opts().Costs.AnomalyDays > 0 {
+		m.anomalyHistory = newCostsAnomalyHistory()
+		m.anomalyLabelNames = map[string][]string{}
+		if cachePath := m.Opts().GetCachePath("costs-anomaly.json"); cachePath != nil {
+			if err := m.anomalyHistory.CacheLoad(*cachePath); err != nil {
+				m.Logger().Errorf("failed to load costs anomaly history: %v", err)
+			}
+		}
+	}
 
 	// ----------------------------------------------------
 	// Budget
@@ -100,9 +309,11 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 		},
 		[]string{
 			"resourceID",
+			"tenantID",
 			"subscriptionID",
 			"budgetName",
 			"resourceGroup",
+			"scope",
 			"category",
 			"timeGrain",
 		},
@@ -116,12 +327,28 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 		},
 		[]string{
 			"resourceID",
+			"tenantID",
 			"subscriptionID",
 			"resourceGroup",
 			"budgetName",
 		},
 	)
 	m.Collector.RegisterMetricList("consumptionBudgetLimit", m.prometheus.consumptionBudgetLimit, true)
+	registerCostsNormalizedMetricList(
+		m.Collector,
+		m.Opts(),
+		"consumptionBudgetLimit",
+		"azurerm_costs_bugdet_limit",
+		"Azure ResourceManager consumption budget limit",
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+		},
+		"currency",
+	)
 
 	m.prometheus.consumptionBudgetUsage = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -130,6 +357,7 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 		},
 		[]string{
 			"resourceID",
+			"tenantID",
 			"subscriptionID",
 			"resourceGroup",
 			"budgetName",
@@ -144,6 +372,7 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 		},
 		[]string{
 			"resourceID",
+			"tenantID",
 			"subscriptionID",
 			"resourceGroup",
 			"budgetName",
@@ -151,27 +380,122 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 		},
 	)
 	m.Collector.RegisterMetricList("consumptionBudgetCurrent", m.prometheus.consumptionBudgetCurrent, true)
+	registerCostsNormalizedMetricList(
+		m.Collector,
+		m.Opts(),
+		"consumptionBudgetCurrent",
+		"azurerm_costs_bugdet_current",
+		"Azure ResourceManager consumption budget current",
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"unit",
+		},
+		"unit",
+	)
+
+	m.prometheus.consumptionBudgetForecast = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_bugdet_forecast",
+			Help: "Azure ResourceManager consumption budget forecasted spend (only for budgets with forecasted notifications)",
+		},
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"unit",
+		},
+	)
+	m.Collector.RegisterMetricList("consumptionBudgetForecast", m.prometheus.consumptionBudgetForecast, true)
+	registerCostsNormalizedMetricList(
+		m.Collector,
+		m.Opts(),
+		"consumptionBudgetForecast",
+		"azurerm_costs_bugdet_forecast",
+		"Azure ResourceManager consumption budget forecasted spend",
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"unit",
+		},
+		"unit",
+	)
+
+	m.prometheus.consumptionBudgetTimePeriod = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_bugdet_timeperiod",
+			Help: "Azure ResourceManager consumption budget time period (start and end timestamp)",
+		},
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"type",
+		},
+	)
+	m.Collector.RegisterMetricList("consumptionBudgetTimePeriod", m.prometheus.consumptionBudgetTimePeriod, true)
+
+	m.prometheus.consumptionBudgetNotification = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_bugdet_notification",
+			Help: "Azure ResourceManager consumption budget notification threshold percentage",
+		},
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"notificationName",
+			"operator",
+			"thresholdType",
+			"enabled",
+		},
+	)
+	m.Collector.RegisterMetricList("consumptionBudgetNotification", m.prometheus.consumptionBudgetNotification, true)
+
+	m.prometheus.consumptionBudgetFilter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_bugdet_filter",
+			Help: "Azure ResourceManager consumption budget filter expressions (dimensions and tags)",
+		},
+		[]string{
+			"resourceID",
+			"tenantID",
+			"subscriptionID",
+			"resourceGroup",
+			"budgetName",
+			"filterType",
+			"name",
+			"operator",
+			"values",
+		},
+	)
+	m.Collector.RegisterMetricList("consumptionBudgetFilter", m.prometheus.consumptionBudgetFilter, true)
 
 	// ----------------------------------------------------
 	// Costs (by Query)
 
 	for _, query := range m.queries {
-		costLabels := []string{
-			"subscriptionID",
+		costLabels := append(
+			query.ScopeLabelNames(),
 			"currency",
 			"timeframe",
-		}
+			"exportType",
+		)
 
 		for _, dimension := range query.Dimensions {
-			var labelName string
-			switch {
-			case strings.EqualFold(dimension, "ResourceGroupName"):
-				labelName = "resourceGroup"
-			default:
-				labelName = prometheusLabelReplacerRegExp.ReplaceAllString(dimension, "_")
-			}
-
-			costLabels = append(costLabels, labelName)
+			costLabels = append(costLabels, costDimensionLabelName(dimension))
 		}
 
 		queryGaugeVec := prometheus.NewGaugeVec(
@@ -186,62 +510,292 @@ func (m *MetricsCollectorAzureRmCosts) Setup(collector *collector.Collector) {
 			queryGaugeVec,
 			true,
 		)
+		registerCostsNormalizedMetricList(
+			m.Collector,
+			m.Opts(),
+			fmt.Sprintf(`query:%v`, query.Name),
+			fmt.Sprintf(`azurerm_costs_%v`, query.Name),
+			fmt.Sprintf(`Azure ResourceManager costmanagement query with dimensions %v`, strings.Join(query.Dimensions, ",")),
+			costLabels,
+			"currency",
+		)
+
+		if query.DailyDays > 0 {
+			dailyLabels := append(
+				query.ScopeLabelNames(),
+				"currency",
+				"date",
+				"exportType",
+			)
+
+			for _, dimension := range query.Dimensions {
+				dailyLabels = append(dailyLabels, costDimensionLabelName(dimension))
+			}
+
+			dailyGaugeVec := prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: fmt.Sprintf(`azurerm_costs_%v_daily`, query.Name),
+					Help: fmt.Sprintf(`Azure ResourceManager costmanagement query with dimensions %v per day (last %v days)`, strings.Join(query.Dimensions, ","), query.DailyDays),
+				},
+				dailyLabels,
+			)
+			m.Collector.RegisterMetricList(
+				fmt.Sprintf(`query:%v:daily`, query.Name),
+				dailyGaugeVec,
+				true,
+			)
+			registerCostsNormalizedMetricList(
+				m.Collector,
+				m.Opts(),
+				fmt.Sprintf(`query:%v:daily`, query.Name),
+				fmt.Sprintf(`azurerm_costs_%v_daily`, query.Name),
+				fmt.Sprintf(`Azure ResourceManager costmanagement query with dimensions %v per day`, strings.Join(query.Dimensions, ",")),
+				dailyLabels,
+				"currency",
+			)
+
+			// Costs anomalies of the daily costs (last complete day)
+			if m.anomalyHistory != nil {
+				m.anomalyLabelNames[query.Name] = dailyLabels
+
+				anomalyGaugeVec := prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: fmt.Sprintf(`azurerm_costs_%v_anomaly`, query.Name),
+						Help: fmt.Sprintf(`Azure ResourceManager costmanagement query with dimensions %v cost anomaly of the last complete day (1 = higher, -1 = lower than the baseline by factor %v)`, strings.Join(query.Dimensions, ","), m.Opts().Costs.AnomalyFactor),
+					},
+					dailyLabels,
+				)
+				m.Collector.RegisterMetricList(
+					fmt.Sprintf(`query:%v:anomaly`, query.Name),
+					anomalyGaugeVec,
+					true,
+				)
+
+				anomalyScoreGaugeVec := prometheus.NewGaugeVec(
+					prometheus.GaugeOpts{
+						Name: fmt.Sprintf(`azurerm_costs_%v_anomaly_score`, query.Name),
+						Help: fmt.Sprintf(`Azure ResourceManager costmanagement query with dimensions %v cost anomaly score (costs of the last complete day divided by the average daily costs of the %v days before)`, strings.Join(query.Dimensions, ","), m.Opts().Costs.AnomalyDays),
+					},
+					dailyLabels,
+				)
+				m.Collector.RegisterMetricList(
+					fmt.Sprintf(`query:%v:anomalyScore`, query.Name),
+					anomalyScoreGaugeVec,
+					true,
+				)
+			}
+		}
 	}
 }
 
 func (m *MetricsCollectorAzureRmCosts) Reset() {}
 
 func (m *MetricsCollectorAzureRmCosts) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEach(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectSubscription(subscription, logger)
+	err := m.SubscriptionsIterator().ForEach(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		m.collectSubscription(status, tenant, subscription, logger)
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
-}
 
-func (m *MetricsCollectorAzureRmCosts) collectSubscription(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-	for _, timeframe := range opts.Costs.Timeframe {
-		for _, query := range m.queries {
-			logger.Infof(`fetching cost report for query %v`, query.Name)
-			m.collectCostManagementMetrics(
-				logger.WithField("costreport", "ActualCost"),
-				m.Collector.GetMetricList(fmt.Sprintf(`query:%v`, query.Name)),
-				subscription,
-				armcostmanagement.ExportTypeActualCost,
-				query.Dimensions,
-				timeframe,
+	if m.Opts().Costs.BudgetManagementGroups {
+		for _, tenant := range m.AzureTenants() {
+			for _, managementGroup := range tenant.ManagementGroups() {
+				scope := fmt.Sprintf("/providers/Microsoft.Management/managementGroups/%v", managementGroup)
+				if err := m.collectBudgetScope(tenant, tenant.TenantID, scope); err != nil {
+					collectorErrorHandle(m.Logger().WithField("scope", scope), m.Collector, "", "consumption.budgets", err)
+				}
+			}
+		}
+	}
+
+	// queries with scopes (management groups, billing accounts, ...) are only run once per scope
+	for _, query := range m.queries {
+		for _, scope := range query.Scopes {
+			scopeID := scope.ResourceID()
+			logger := m.Logger().WithField("scope", scopeID)
+
+			tenant := getAzureTenant(m.AzureTenants(), scope.Tenant)
+			if tenant == nil {
+				logger.Errorf(`tenant "%v" not found`, scope.Tenant)
+				continue
+			}
+
+			m.collectQuery(
+				logger,
+				tenant,
+				scopeID,
+				prometheus.Labels{
+					"tenantID": tenant.TenantID,
+					"scope":    stringToStringLower(scopeID),
+				},
+				query,
+				func(err error) {
+					if err != nil {
+						collectorErrorHandle(logger, m.Collector, "", "costmanagement.query", err)
+					}
+				},
 			)
 		}
+	}
 
-		// avoid rate limit
-		time.Sleep(opts.Costs.RequestDelay)
+	if m.anomalyHistory != nil {
+		m.collectAnomalies()
+	}
+}
+
+// collectAnomalies detects cost anomalies of the daily costs and saves the history to the cache
+func (m *MetricsCollectorAzureRmCosts) collectAnomalies() {
+	for _, result := range m.anomalyHistory.Evaluate(time.Now(), m.Opts().Costs.AnomalyDays, m.Opts().Costs.AnomalyFactor) {
+		// queries without daily costs (anymore)
+		labelNames, exists := m.anomalyLabelNames[result.Query]
+		if !exists {
+			continue
+		}
+
+		// labels of the current query config (history may contain series of changed dimensions)
+		labels := prometheus.Labels{}
+		for _, labelName := range labelNames {
+			labels[labelName] = result.Labels[labelName]
+		}
+
+		m.Collector.GetMetricList(fmt.Sprintf(`query:%v:anomalyScore`, result.Query)).Add(labels, result.Score)
+		m.Collector.GetMetricList(fmt.Sprintf(`query:%v:anomaly`, result.Query)).Add(labels, float64(result.Anomaly))
+	}
+
+	if cachePath := m.Opts().GetCachePath("costs-anomaly.json"); cachePath != nil {
+		if err := m.anomalyHistory.CacheSave(*cachePath); err != nil {
+			m.Logger().Errorf("failed to save costs anomaly history: %v", err)
+		}
+	}
+}
+
+func (m *MetricsCollectorAzureRmCosts) collectSubscription(status *CollectorSubscriptionStatus, tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+	for _, query := range m.queries {
+		if len(query.Scopes) > 0 {
+			continue
+		}
+
+		m.collectQuery(
+			logger,
+			tenant,
+			*subscription.ID,
+			prometheus.Labels{
+				"tenantID":       tenant.SubscriptionTenantID(subscription),
+				"subscriptionID": to.StringLower(subscription.SubscriptionID),
+			},
+			query,
+			func(err error) {
+				status.Check("costmanagement.query", err)
+			},
+		)
 	}
 
 	logger.Info(`fetching cost budget report`)
-	m.collectBugdetMetrics(
-		logger.WithField("consumption", "Budgets"),
-		subscription,
-	)
+	err := m.collectBudgetScope(tenant, tenant.SubscriptionTenantID(subscription), *subscription.ID)
+	status.Check("consumption.budgets", err)
+
+	if m.Opts().Costs.BudgetResourceGroups {
+		err := m.collectBudgetResourceGroups(tenant, subscription)
+		status.Check("consumption.budgets.resourcegroups", err)
+	}
 }
 
-func (m *MetricsCollectorAzureRmCosts) collectBugdetMetrics(logger *log.Entry, subscription *armsubscriptions.Subscription) {
-	client, err := armconsumption.NewBudgetsClient(AzureClient.GetCred(), AzureClient.NewArmClientOptions())
-	if err != nil {
-		logger.Panic(err)
+// collectQuery runs the query with all export types, timeframes, time periods and daily costs for the scope
+func (m *MetricsCollectorAzureRmCosts) collectQuery(logger *log.Entry, tenant *AzureTenant, scope string, scopeLabels prometheus.Labels, query MetricsCollectorAzureRmCostsQuery, errorHandler func(err error)) {
+	now := time.Now()
+
+	timeframes := m.Opts().Costs.Timeframe
+	if len(query.Timeframes) > 0 {
+		timeframes = query.Timeframes
 	}
 
-	infoMetric := m.Collector.GetMetricList("consumptionBudgetInfo")
-	usageMetric := m.Collector.GetMetricList("consumptionBudgetUsage")
-	limitMetric := m.Collector.GetMetricList("consumptionBudgetLimit")
-	currentMetric := m.Collector.GetMetricList("consumptionBudgetCurrent")
+	for _, exportType := range query.ExportTypes {
+		for _, timeframe := range timeframes {
+			logger.Infof(`fetching %v cost report for query %v (%v)`, exportType, query.Name, timeframe)
+			err := m.collectCostManagementMetrics(
+				logger.WithField("costreport", exportType),
+				fmt.Sprintf(`query:%v`, query.Name),
+				tenant,
+				scope,
+				scopeLabels,
+				query,
+				armcostmanagement.ExportType(exportType),
+				timeframe,
+				armcostmanagement.GranularityType("none"),
+				nil,
+			)
+			errorHandler(err)
+		}
+
+		for _, timePeriod := range query.TimePeriods {
+			periodFrom, periodTo := timePeriod.Period(now)
+
+			logger.Infof(`fetching %v cost report for query %v (%v)`, exportType, query.Name, timePeriod.Name)
+			err := m.collectCostManagementMetrics(
+				logger.WithField("costreport", exportType),
+				fmt.Sprintf(`query:%v`, query.Name),
+				tenant,
+				scope,
+				scopeLabels,
+				query,
+				armcostmanagement.ExportType(exportType),
+				timePeriod.Name,
+				armcostmanagement.GranularityType("none"),
+				&armcostmanagement.QueryTimePeriod{
+					From: &periodFrom,
+					To:   &periodTo,
+				},
+			)
+			errorHandler(err)
+		}
+
+		// daily costs of the last days (including today), older days are refreshed on every run
+		// as costs are often reported with a delay
+		if query.DailyDays > 0 {
+			dailyPeriod := config.CostTimePeriod{LastDays: query.DailyDays}
+			periodFrom, periodTo := dailyPeriod.Period(now)
+
+			logger.Infof(`fetching %v daily cost report for query %v`, exportType, query.Name)
+			err := m.collectCostManagementMetrics(
+				logger.WithField("costreport", exportType),
+				fmt.Sprintf(`query:%v:daily`, query.Name),
+				tenant,
+				scope,
+				scopeLabels,
+				query,
+				armcostmanagement.ExportType(exportType),
+				"",
+				armcostmanagement.GranularityTypeDaily,
+				&armcostmanagement.QueryTimePeriod{
+					From: &periodFrom,
+					To:   &periodTo,
+				},
+			)
+			errorHandler(err)
+
+			if err == nil && m.anomalyHistory != nil {
+				m.anomalyHistory.Collected(query, scopeLabels, exportType, periodFrom, periodTo)
+			}
+		}
+	}
+}
+
+// collectBudgetScope collects the budgets of the scope (subscription, resource group or management group)
+func (m *MetricsCollectorAzureRmCosts) collectBudgetScope(tenant *AzureTenant, tenantID, scope string) error {
+	client, err := armconsumption.NewBudgetsClient(tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+	if err != nil {
+		return err
+	}
 
-	pager := client.NewListPager(*subscription.ID, nil)
+	pager := client.NewListPager(scope, nil)
 
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -249,87 +803,191 @@ func (m *MetricsCollectorAzureRmCosts) collectBugdetMetrics(logger *log.Entry, s
 		}
 
 		for _, budget := range result.Value {
-			resourceId := to.String(budget.ID)
-			azureResource, _ := armclient.ParseResourceId(resourceId)
-
-			infoMetric.AddInfo(prometheus.Labels{
-				"resourceID":     stringToStringLower(resourceId),
-				"subscriptionID": azureResource.Subscription,
-				"resourceGroup":  azureResource.ResourceGroup,
-				"budgetName":     to.String(budget.Name),
-				"category":       stringToStringLower(string(*budget.Properties.Category)),
-				"timeGrain":      string(*budget.Properties.TimeGrain),
-			})
-
-			if budget.Properties.Amount != nil {
-				limitMetric.Add(prometheus.Labels{
-					"resourceID":     stringToStringLower(resourceId),
-					"subscriptionID": azureResource.Subscription,
-					"resourceGroup":  azureResource.ResourceGroup,
-					"budgetName":     to.String(budget.Name),
-				}, *budget.Properties.Amount)
+			if budget.Properties == nil {
+				continue
 			}
 
-			if budget.Properties.CurrentSpend != nil {
-				currentMetric.Add(prometheus.Labels{
-					"resourceID":     stringToStringLower(resourceId),
-					"subscriptionID": azureResource.Subscription,
-					"resourceGroup":  azureResource.ResourceGroup,
-					"budgetName":     to.String(budget.Name),
-					"unit":           to.StringLower(budget.Properties.CurrentSpend.Unit),
-				}, *budget.Properties.CurrentSpend.Amount)
-			}
+			m.addBudgetMetrics(tenantID, scope, budget)
+		}
+	}
 
-			if budget.Properties.Amount != nil && budget.Properties.CurrentSpend != nil {
-				usageMetric.Add(prometheus.Labels{
-					"resourceID":     stringToStringLower(resourceId),
-					"subscriptionID": azureResource.Subscription,
-					"resourceGroup":  azureResource.ResourceGroup,
-					"budgetName":     to.String(budget.Name),
-				}, *budget.Properties.CurrentSpend.Amount / *budget.Properties.Amount)
+	return nil
+}
+
+func (m *MetricsCollectorAzureRmCosts) collectBudgetResourceGroups(tenant *AzureTenant, subscription *armsubscriptions.Subscription) error {
+	client, err := armresources.NewResourceGroupsClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+	if err != nil {
+		return err
+	}
+
+	pager := client.NewListPager(nil)
+
+	for pager.More() {
+		result, err := pager.NextPage(m.Context())
+		if err != nil {
+			return err
+		}
+
+		for _, resourceGroup := range result.Value {
+			if err := m.collectBudgetScope(tenant, tenant.SubscriptionTenantID(subscription), to.String(resourceGroup.ID)); err != nil {
+				return err
 			}
 		}
 	}
+
+	return nil
 }
 
-func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.Entry, metricList *collector.MetricList, subscription *armsubscriptions.Subscription, exportType armcostmanagement.ExportType, dimensions []string, timeframe string) {
-	client, err := armcostmanagement.NewQueryClient(AzureClient.GetCred(), AzureClient.NewArmClientOptions())
-	if err != nil {
-		logger.Panic(err)
+func (m *MetricsCollectorAzureRmCosts) addBudgetMetrics(tenantID, scope string, budget *armconsumption.Budget) {
+	infoMetric := m.Collector.GetMetricList("consumptionBudgetInfo")
+	usageMetric := m.Collector.GetMetricList("consumptionBudgetUsage")
+	limitMetric := m.Collector.GetMetricList("consumptionBudgetLimit")
+	currentMetric := m.Collector.GetMetricList("consumptionBudgetCurrent")
+	forecastMetric := m.Collector.GetMetricList("consumptionBudgetForecast")
+	timePeriodMetric := m.Collector.GetMetricList("consumptionBudgetTimePeriod")
+	notificationMetric := m.Collector.GetMetricList("consumptionBudgetNotification")
+	filterMetric := m.Collector.GetMetricList("consumptionBudgetFilter")
+
+	resourceId := to.String(budget.ID)
+	azureResource, _ := armclient.ParseResourceId(resourceId)
+
+	budgetLabels := func() prometheus.Labels {
+		return prometheus.Labels{
+			"resourceID":     stringToStringLower(resourceId),
+			"tenantID":       tenantID,
+			"subscriptionID": azureResource.Subscription,
+			"resourceGroup":  azureResource.ResourceGroup,
+			"budgetName":     to.String(budget.Name),
+		}
 	}
 
-	queryGrouping := []*armcostmanagement.QueryGrouping{}
+	infoLabels := budgetLabels()
+	infoLabels["scope"] = stringToStringLower(scope)
+	infoLabels["category"] = ""
+	if budget.Properties.Category != nil {
+		infoLabels["category"] = stringToStringLower(string(*budget.Properties.Category))
+	}
+	infoLabels["timeGrain"] = ""
+	if budget.Properties.TimeGrain != nil {
+		infoLabels["timeGrain"] = string(*budget.Properties.TimeGrain)
+	}
+	infoMetric.AddInfo(infoLabels)
 
-	for _, row := range dimensions {
-		dimension := row
+	// the limit has no currency, it's the currency of the current spend
+	budgetCurrency := ""
+	if budget.Properties.CurrentSpend != nil {
+		budgetCurrency = to.String(budget.Properties.CurrentSpend.Unit)
+	}
 
-		dimensionType := armcostmanagement.QueryColumnTypeDimension
+	if budget.Properties.Amount != nil {
+		limitMetric.Add(budgetLabels(), *budget.Properties.Amount)
+		addCostsNormalizedMetric(m.Collector, m.Opts(), "consumptionBudgetLimit", budgetLabels(), "currency", budgetCurrency, *budget.Properties.Amount)
+	}
 
-		if strings.Contains(dimension, ":") {
-			dimensionParts := strings.SplitN(dimension, ":", 2)
-			switch strings.ToLower(dimensionParts[0]) {
-			case "tag":
-				dimensionType = armcostmanagement.QueryColumnTypeTag
-				dimension = dimensionParts[1]
-			default:
-				logger.Fatalf(`cost dimension %v is not supported`, dimension)
-			}
+	if budget.Properties.CurrentSpend != nil && budget.Properties.CurrentSpend.Amount != nil {
+		currentLabels := budgetLabels()
+		currentLabels["unit"] = to.StringLower(budget.Properties.CurrentSpend.Unit)
+		currentMetric.Add(currentLabels, *budget.Properties.CurrentSpend.Amount)
+		addCostsNormalizedMetric(m.Collector, m.Opts(), "consumptionBudgetCurrent", currentLabels, "unit", budgetCurrency, *budget.Properties.CurrentSpend.Amount)
+
+		if budget.Properties.Amount != nil && *budget.Properties.Amount != 0 {
+			usageMetric.Add(budgetLabels(), *budget.Properties.CurrentSpend.Amount / *budget.Properties.Amount)
 		}
+	}
 
-		queryGrouping = append(
-			queryGrouping,
-			&armcostmanagement.QueryGrouping{
-				Name: &dimension,
-				Type: &dimensionType,
+	// forecast is only provided if the budget has a notification with forecasted threshold
+	if budget.Properties.ForecastSpend != nil && budget.Properties.ForecastSpend.Amount != nil {
+		forecastLabels := budgetLabels()
+		forecastLabels["unit"] = to.StringLower(budget.Properties.ForecastSpend.Unit)
+		forecastMetric.Add(forecastLabels, *budget.Properties.ForecastSpend.Amount)
+		addCostsNormalizedMetric(m.Collector, m.Opts(), "consumptionBudgetForecast", forecastLabels, "unit", to.String(budget.Properties.ForecastSpend.Unit), *budget.Properties.ForecastSpend.Amount)
+	}
+
+	if timePeriod := budget.Properties.TimePeriod; timePeriod != nil {
+		if timePeriod.StartDate != nil {
+			timePeriodLabels := budgetLabels()
+			timePeriodLabels["type"] = "startDate"
+			timePeriodMetric.AddTime(timePeriodLabels, timePeriod.StartDate.UTC())
+		}
+
+		if timePeriod.EndDate != nil {
+			timePeriodLabels := budgetLabels()
+			timePeriodLabels["type"] = "endDate"
+			timePeriodMetric.AddTime(timePeriodLabels, timePeriod.EndDate.UTC())
+		}
+	}
+
+	for notificationName, notification := range budget.Properties.Notifications {
+		if notification == nil || notification.Threshold == nil {
+			continue
+		}
+
+		notificationLabels := budgetLabels()
+		notificationLabels["notificationName"] = notificationName
+		notificationLabels["operator"] = ""
+		if notification.Operator != nil {
+			notificationLabels["operator"] = string(*notification.Operator)
+		}
+		// default threshold type of the api is Actual
+		notificationLabels["thresholdType"] = string(armconsumption.ThresholdTypeActual)
+		if notification.ThresholdType != nil {
+			notificationLabels["thresholdType"] = string(*notification.ThresholdType)
+		}
+		notificationLabels["enabled"] = to.BoolString(notification.Enabled != nil && *notification.Enabled)
+		notificationMetric.Add(notificationLabels, *notification.Threshold)
+	}
+
+	if filter := budget.Properties.Filter; filter != nil {
+		filterExpressions := []*armconsumption.BudgetFilterProperties{
+			{
+				Dimensions: filter.Dimensions,
+				Tags:       filter.Tags,
 			},
-		)
+		}
+		filterExpressions = append(filterExpressions, filter.And...)
+
+		for _, expression := range filterExpressions {
+			if expression == nil {
+				continue
+			}
+
+			for filterType, comparison := range map[string]*armconsumption.BudgetComparisonExpression{
+				"dimension": expression.Dimensions,
+				"tag":       expression.Tags,
+			} {
+				if comparison == nil {
+					continue
+				}
+
+				values := []string{}
+				for _, value := range comparison.Values {
+					values = append(values, to.String(value))
+				}
+
+				filterLabels := budgetLabels()
+				filterLabels["filterType"] = filterType
+				filterLabels["name"] = to.String(comparison.Name)
+				filterLabels["operator"] = ""
+				if comparison.Operator != nil {
+					filterLabels["operator"] = string(*comparison.Operator)
+				}
+				filterLabels["values"] = strings.Join(values, ",")
+				filterMetric.AddInfo(filterLabels)
+			}
+		}
 	}
+}
 
-	foo, _ := json.Marshal(queryGrouping)
-	fmt.Println(string(foo))
+func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.Entry, metricListName string, tenant *AzureTenant, scope string, scopeLabels prometheus.Labels, query MetricsCollectorAzureRmCostsQuery, exportType armcostmanagement.ExportType, timeframe string, granularity armcostmanagement.GranularityType, timePeriod *armcostmanagement.QueryTimePeriod) error {
+	queryGrouping, err := costQueryGrouping(query.Dimensions)
+	if err != nil {
+		return err
+	}
 
-	granularity := armcostmanagement.GranularityType("none")
 	timeframeType := armcostmanagement.TimeframeType(timeframe)
+	if timePeriod != nil {
+		timeframeType = armcostmanagement.TimeframeTypeCustom
+	}
 
 	aggregationFunction := armcostmanagement.FunctionTypeSum
 	params := armcostmanagement.QueryDefinition{
@@ -341,31 +999,33 @@ func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.
 				},
 			},
 			Configuration: nil,
-			Filter:        nil,
+			Filter:        buildCostQueryFilter(query.Filter),
 			Granularity:   &granularity,
 			Grouping:      queryGrouping,
 		},
 		Timeframe:  &timeframeType,
 		Type:       &exportType,
-		TimePeriod: nil,
+		TimePeriod: timePeriod,
 	}
 
-	result, err := client.Usage(m.Context(), *subscription.ID, params, nil)
+	// avoid rate limit (also after failed queries)
+	costsRateLimiter.Wait(m.Context(), m.Opts())
+
+	list, err := costsQueryUsage(m.Context(), tenant, m.Opts(), scope, params)
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
-	if result.Properties == nil || result.Properties.Columns == nil || result.Properties.Rows == nil {
+	if list == nil || list.Columns == nil || list.Rows == nil {
 		// no result
 		logger.Warnln("got invalid response (no columns or rows)")
-		return
+		return nil
 	}
 
-	list := result.Properties
-
 	// detect column numbers
 	columnNumberCost := -1
 	columnNumberCurrency := -1
+	columnNumberDate := -1
 	columnDimensions := map[string]int{}
 	for num, col := range list.Columns {
 		if col.Name == nil {
@@ -377,9 +1037,11 @@ func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.
 			columnNumberCost = num
 		case "currency":
 			columnNumberCurrency = num
+		case "usagedate":
+			columnNumberDate = num
 		}
 
-		for _, dimension := range dimensions {
+		for _, dimension := range query.Dimensions {
 			if strings.EqualFold(dimension, *col.Name) {
 				columnDimensions[dimension] = num
 			}
@@ -387,9 +1049,9 @@ func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.
 	}
 
 	// check if we detected all columns
-	if columnNumberCost == -1 || columnNumberCurrency == -1 || len(columnDimensions) != len(dimensions) {
+	if columnNumberCost == -1 || columnNumberCurrency == -1 || len(columnDimensions) != len(query.Dimensions) || (granularity == armcostmanagement.GranularityTypeDaily && columnNumberDate == -1) {
 		logger.Warnln("unable to detect columns")
-		return
+		return nil
 	}
 
 	// process metrics
@@ -400,25 +1062,88 @@ func (m *MetricsCollectorAzureRmCosts) collectCostManagementMetrics(logger *log.
 		}
 
 		labels := prometheus.Labels{
-			"subscriptionID": to.StringLower(subscription.SubscriptionID),
-			"currency":       stringToStringLower(row[columnNumberCurrency].(string)),
-			"timeframe":      timeframe,
+			"currency":   stringToStringLower(row[columnNumberCurrency].(string)),
+			"exportType": string(exportType),
+		}
+		for labelName, labelValue := range scopeLabels {
+			labels[labelName] = labelValue
+		}
+
+		if granularity == armcostmanagement.GranularityTypeDaily {
+			labels["date"] = costUsageDate(row[columnNumberDate])
+		} else {
+			labels["timeframe"] = timeframe
 		}
 
+		dimensionValues := map[string]string{}
 		for dimension, colNumber := range columnDimensions {
-			labelName := prometheusLabelReplacerRegExp.ReplaceAllString(dimension, "_")
+			dimensionValues[strings.ToLower(dimension)] = row[colNumber].(string)
+			labels[costDimensionLabelName(dimension)] = row[colNumber].(string)
+		}
 
-			switch {
-			case strings.EqualFold(dimension, "ResourceGroupName"):
-				labelName = "resourceGroup"
-			}
+		if !query.MatchesLocalFilters(dimensionValues) {
+			continue
+		}
 
-			labels[labelName] = row[colNumber].(string)
+		m.Collector.GetMetricList(metricListName).Add(labels, usage)
+		addCostsNormalizedMetric(m.Collector, m.Opts(), metricListName, labels, "currency", labels["currency"], usage)
+
+		if granularity == armcostmanagement.GranularityTypeDaily && m.anomalyHistory != nil {
+			m.anomalyHistory.Add(query, labels, usage)
 		}
+	}
+
+	return nil
+}
+
+// costUsageDate converts the UsageDate column (eg. 20230115) to a date (eg. 2023-01-15)
+func costUsageDate(value interface{}) string {
+	var date string
+	switch v := value.(type) {
+	case float64:
+		date = strconv.FormatInt(int64(v), 10)
+	case string:
+		date = v
+	}
 
-		metricList.Add(labels, usage)
+	if parsedDate, err := time.Parse("20060102", date); err == nil {
+		return parsedDate.Format("2006-01-02")
 	}
 
-	// avoid rate limit
-	time.Sleep(opts.Costs.RequestDelay)
+	return date
+}
+
+// buildCostQueryFilter converts the configured filter to a costmanagement query filter
+func buildCostQueryFilter(filter *config.CostQueryFilter) *armcostmanagement.QueryFilter {
+	if filter == nil {
+		return nil
+	}
+
+	queryFilter := &armcostmanagement.QueryFilter{}
+
+	for _, expr := range filter.And {
+		queryFilter.And = append(queryFilter.And, buildCostQueryFilter(expr))
+	}
+
+	for _, expr := range filter.Or {
+		queryFilter.Or = append(queryFilter.Or, buildCostQueryFilter(expr))
+	}
+
+	buildComparison := func(comparison *config.CostQueryFilterComparison) *armcostmanagement.QueryComparisonExpression {
+		if comparison == nil {
+			return nil
+		}
+
+		operator := armcostmanagement.QueryOperatorTypeIn
+		return &armcostmanagement.QueryComparisonExpression{
+			Name:     to.StringPtr(comparison.Name),
+			Operator: &operator,
+			Values:   to.SlicePtr(comparison.Values()),
+		}
+	}
+
+	queryFilter.Dimensions = buildComparison(filter.Dimension)
+	queryFilter.Tags = buildComparison(filter.Tag)
+
+	return queryFilter
 }
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+type (
+	MetricsCollectorAzureRmCostsForecast struct {
+		CollectorProcessor
+
+		queries map[string]MetricsCollectorAzureRmCostsQuery
+
+		prometheus struct {
+			costsForecast *prometheus.GaugeVec
+		}
+	}
+
+	// costsForecastDimensionValues is one combination of dimension values of a query
+	costsForecastDimensionValues struct {
+		values []string
+		cost   float64
+	}
+)
+
+func (m *MetricsCollectorAzureRmCostsForecast) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
+	m.Collector.SetCache(m.Opts().GetCachePath("costs-forecast.json"))
+
+	queries, err := buildCostQueries(m.Opts())
+	if err != nil {
+		m.Logger().Panic(err)
+	}
+	m.queries = queries
+
+	// ----------------------------------------------------
+	// Forecast (subscription)
+	m.prometheus.costsForecast = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_costs_forecast",
+			Help: "Azure ResourceManager costmanagement forecast for the rest of the current month",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"currency",
+			"exportType",
+		},
+	)
+	m.Collector.RegisterMetricList("costsForecast", m.prometheus.costsForecast, true)
+	registerCostsNormalizedMetricList(
+		m.Collector,
+		m.Opts(),
+		"costsForecast",
+		"azurerm_costs_forecast",
+		"Azure ResourceManager costmanagement forecast for the rest of the current month",
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"currency",
+			"exportType",
+		},
+		"currency",
+	)
+
+	// ----------------------------------------------------
+	// Forecast (by Query)
+	for _, query := range m.queries {
+		forecastLabels := append(
+			query.ScopeLabelNames(),
+			"currency",
+			"exportType",
+		)
+
+		for _, dimension := range query.Dimensions {
+			forecastLabels = append(forecastLabels, costDimensionLabelName(dimension))
+		}
+
+		queryGaugeVec := prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf(`azurerm_costs_forecast_%v`, query.Name),
+				Help: fmt.Sprintf(`Azure ResourceManager costmanagement forecast for the rest of the current month with dimensions %v`, strings.Join(query.Dimensions, ",")),
+			},
+			forecastLabels,
+		)
+		m.Collector.RegisterMetricList(
+			fmt.Sprintf(`query:%v`, query.Name),
+			queryGaugeVec,
+			true,
+		)
+		registerCostsNormalizedMetricList(
+			m.Collector,
+			m.Opts(),
+			fmt.Sprintf(`query:%v`, query.Name),
+			fmt.Sprintf(`azurerm_costs_forecast_%v`, query.Name),
+			fmt.Sprintf(`Azure ResourceManager costmanagement forecast for the rest of the current month with dimensions %v`, strings.Join(query.Dimensions, ",")),
+			forecastLabels,
+			"currency",
+		)
+	}
+}
+
+func (m *MetricsCollectorAzureRmCostsForecast) Reset() {}
+
+func (m *MetricsCollectorAzureRmCostsForecast) Collect(callback chan<- func()) {
+	err := m.SubscriptionsIterator().ForEach(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		m.collectSubscription(status, tenant, subscription, logger)
+		status.Finish()
+	})
+	if err != nil {
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
+	}
+
+	// queries with scopes (management groups, billing accounts, ...) are only run once per scope
+	for _, query := range m.queries {
+		for _, scope := range query.Scopes {
+			scopeID := scope.ResourceID()
+			logger := m.Logger().WithField("scope", scopeID)
+
+			tenant := getAzureTenant(m.AzureTenants(), scope.Tenant)
+			if tenant == nil {
+				logger.Errorf(`tenant "%v" not found`, scope.Tenant)
+				continue
+			}
+
+			client, err := armcostmanagement.NewForecastClient(tenant.Client.GetCred(), newCostsClientOptions(tenant, m.Opts()))
+			if err != nil {
+				collectorErrorHandle(logger, m.Collector, "", "costmanagement.forecast", err)
+				continue
+			}
+
+			scopeLabels := prometheus.Labels{
+				"tenantID": tenant.TenantID,
+				"scope":    stringToStringLower(scopeID),
+			}
+
+			for _, exportType := range query.ExportTypes {
+				// avoid rate limit
+				costsRateLimiter.Wait(m.Context(), m.Opts())
+
+				logger.Infof(`fetching %v cost forecast for query %v`, exportType, query.Name)
+				err := m.collectQueryForecast(
+					logger.WithField("costquery", query.Name),
+					fmt.Sprintf(`query:%v`, query.Name),
+					client,
+					tenant,
+					scopeID,
+					query,
+					exportType,
+					scopeLabels,
+				)
+				if err != nil {
+					collectorErrorHandle(logger, m.Collector, "", "costmanagement.forecast", err)
+				}
+			}
+		}
+	}
+}
+
+// collectSubscription fetches the forecasts of the subscription, each request is delayed by the costs rate limiter:
+// one forecast per export type and for each query and export type one month to date query (dimension values)
+// plus one forecast per dimension value combination (max --costs.forecast.limit)
+func (m *MetricsCollectorAzureRmCostsForecast) collectSubscription(status *CollectorSubscriptionStatus, tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+	client, err := armcostmanagement.NewForecastClient(tenant.Client.GetCred(), newCostsClientOptions(tenant, m.Opts()))
+	if !status.Check("costmanagement.forecast", err) {
+		return
+	}
+
+	baseLabels := prometheus.Labels{
+		"tenantID":       tenant.SubscriptionTenantID(subscription),
+		"subscriptionID": to.StringLower(subscription.SubscriptionID),
+	}
+
+	for _, val := range m.Opts().Costs.ExportTypes {
+		exportType, _ := config.NormalizeCostExportType(val)
+
+		// avoid rate limit
+		costsRateLimiter.Wait(m.Context(), m.Opts())
+
+		logger.Infof(`fetching %v cost forecast`, exportType)
+		forecast, err := m.fetchForecast(client, *subscription.ID, exportType, nil)
+		if status.Check("costmanagement.forecast", err) {
+			metricList := m.Collector.GetMetricList("costsForecast")
+			for currency, cost := range forecast {
+				labels := prometheus.Labels{
+					"tenantID":       baseLabels["tenantID"],
+					"subscriptionID": baseLabels["subscriptionID"],
+					"currency":       currency,
+					"exportType":     exportType,
+				}
+				metricList.Add(labels, cost)
+				addCostsNormalizedMetric(m.Collector, m.Opts(), "costsForecast", labels, "currency", currency, cost)
+			}
+		}
+	}
+
+	for _, query := range m.queries {
+		if len(query.Scopes) > 0 {
+			continue
+		}
+
+		for _, exportType := range query.ExportTypes {
+			// avoid rate limit
+			costsRateLimiter.Wait(m.Context(), m.Opts())
+
+			logger.Infof(`fetching %v cost forecast for query %v`, exportType, query.Name)
+			err := m.collectQueryForecast(
+				logger.WithField("costquery", query.Name),
+				fmt.Sprintf(`query:%v`, query.Name),
+				client,
+				tenant,
+				*subscription.ID,
+				query,
+				exportType,
+				baseLabels,
+			)
+			status.Check("costmanagement.forecast", err)
+		}
+	}
+}
+
+// collectQueryForecast fetches the forecast for each combination of dimension values of the query,
+// the forecast api doesn't support grouping so the combinations are taken from the month to date costs
+func (m *MetricsCollectorAzureRmCostsForecast) collectQueryForecast(logger *log.Entry, metricListName string, client *armcostmanagement.ForecastClient, tenant *AzureTenant, scope string, query MetricsCollectorAzureRmCostsQuery, exportType string, scopeLabels prometheus.Labels) error {
+	dimensionValues, err := m.fetchDimensionValues(logger, tenant, scope, query, exportType)
+	if err != nil {
+		return err
+	}
+
+	if m.Opts().Costs.ForecastLimit > 0 && len(dimensionValues) > m.Opts().Costs.ForecastLimit {
+		logger.Warnf(`query has %v dimension value combinations, only forecasting the %v with the highest costs`, len(dimensionValues), m.Opts().Costs.ForecastLimit)
+		dimensionValues = dimensionValues[:m.Opts().Costs.ForecastLimit]
+	}
+
+	for _, row := range dimensionValues {
+		// avoid rate limit
+		costsRateLimiter.Wait(m.Context(), m.Opts())
+
+		forecast, err := m.fetchForecast(client, scope, exportType, costsForecastQueryFilter(query, row.values))
+		if err != nil {
+			return err
+		}
+
+		for currency, cost := range forecast {
+			labels := prometheus.Labels{
+				"currency":   currency,
+				"exportType": exportType,
+			}
+			for labelName, labelValue := range scopeLabels {
+				labels[labelName] = labelValue
+			}
+			for num, dimension := range query.Dimensions {
+				labels[costDimensionLabelName(dimension)] = row.values[num]
+			}
+			m.Collector.GetMetricList(metricListName).Add(labels, cost)
+			addCostsNormalizedMetric(m.Collector, m.Opts(), metricListName, labels, "currency", currency, cost)
+		}
+	}
+
+	return nil
+}
+
+// fetchDimensionValues returns the dimension value combinations of the month to date costs (highest costs first)
+func (m *MetricsCollectorAzureRmCostsForecast) fetchDimensionValues(logger *log.Entry, tenant *AzureTenant, scope string, query MetricsCollectorAzureRmCostsQuery, exportType string) ([]costsForecastDimensionValues, error) {
+	queryGrouping, err := costQueryGrouping(query.Dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	granularity := armcostmanagement.GranularityType("none")
+	timeframeType := armcostmanagement.TimeframeTypeMonthToDate
+	queryExportType := armcostmanagement.ExportType(exportType)
+	aggregationFunction := armcostmanagement.FunctionTypeSum
+	params := armcostmanagement.QueryDefinition{
+		Dataset: &armcostmanagement.QueryDataset{
+			Aggregation: map[string]*armcostmanagement.QueryAggregation{
+				"PreTaxCost": {
+					Name:     to.StringPtr("PreTaxCost"),
+					Function: &aggregationFunction,
+				},
+			},
+			Filter:      buildCostQueryFilter(query.Filter),
+			Granularity: &granularity,
+			Grouping:    queryGrouping,
+		},
+		Timeframe: &timeframeType,
+		Type:      &queryExportType,
+	}
+
+	list, err := costsQueryUsage(m.Context(), tenant, m.Opts(), scope, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if list == nil || list.Columns == nil || list.Rows == nil {
+		// no result
+		logger.Warnln("got invalid response (no columns or rows)")
+		return nil, nil
+	}
+
+	// detect column numbers
+	columnNumberCost := -1
+	columnDimensions := make([]int, len(queryGrouping))
+	for num := range columnDimensions {
+		columnDimensions[num] = -1
+	}
+	for num, col := range list.Columns {
+		if col.Name == nil {
+			continue
+		}
+
+		if strings.EqualFold(*col.Name, "PreTaxCost") {
+			columnNumberCost = num
+		}
+
+		for dimensionNum, grouping := range queryGrouping {
+			if strings.EqualFold(*grouping.Name, *col.Name) {
+				columnDimensions[dimensionNum] = num
+			}
+		}
+	}
+
+	// check if we detected all columns
+	if columnNumberCost == -1 {
+		logger.Warnln("unable to detect columns")
+		return nil, nil
+	}
+	for _, colNumber := range columnDimensions {
+		if colNumber == -1 {
+			logger.Warnln("unable to detect columns")
+			return nil, nil
+		}
+	}
+
+	ret := []costsForecastDimensionValues{}
+	for _, row := range list.Rows {
+		dimensionValues := costsForecastDimensionValues{}
+		if v, ok := row[columnNumberCost].(float64); ok {
+			dimensionValues.cost = v
+		}
+
+		filterValues := map[string]string{}
+		for num, colNumber := range columnDimensions {
+			value, _ := row[colNumber].(string)
+			dimensionValues.values = append(dimensionValues.values, value)
+			filterValues[strings.ToLower(query.Dimensions[num])] = value
+		}
+
+		if !query.MatchesLocalFilters(filterValues) {
+			continue
+		}
+
+		ret = append(ret, dimensionValues)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].cost > ret[j].cost
+	})
+
+	return ret, nil
+}
+
+// fetchForecast returns the forecasted costs (per currency) from today until the end of the current month
+func (m *MetricsCollectorAzureRmCostsForecast) fetchForecast(client *armcostmanagement.ForecastClient, scope, exportType string, filter *armcostmanagement.QueryFilter) (map[string]float64, error) {
+	now := time.Now().UTC()
+	periodFrom := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	periodTo := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Add(-1 * time.Second)
+
+	granularity := armcostmanagement.GranularityTypeDaily
+	timeframeType := armcostmanagement.ForecastTimeframeTypeCustom
+	forecastType := armcostmanagement.ForecastType(exportType)
+	aggregationFunction := armcostmanagement.FunctionTypeSum
+	params := armcostmanagement.ForecastDefinition{
+		Dataset: &armcostmanagement.ForecastDataset{
+			Aggregation: map[string]*armcostmanagement.QueryAggregation{
+				"PreTaxCost": {
+					Name:     to.StringPtr("PreTaxCost"),
+					Function: &aggregationFunction,
+				},
+			},
+			Filter:      filter,
+			Granularity: &granularity,
+		},
+		Timeframe:               &timeframeType,
+		Type:                    &forecastType,
+		IncludeActualCost:       to.BoolPtr(false),
+		IncludeFreshPartialCost: to.BoolPtr(false),
+		TimePeriod: &armcostmanagement.QueryTimePeriod{
+			From: &periodFrom,
+			To:   &periodTo,
+		},
+	}
+
+	result, err := client.Usage(m.Context(), scope, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]float64{}
+	if result.Properties == nil || result.Properties.Columns == nil || result.Properties.Rows == nil {
+		// no forecast available (eg. new subscription without costs)
+		return ret, nil
+	}
+
+	list := result.Properties
+
+	// detect column numbers
+	columnNumberCost := -1
+	columnNumberCurrency := -1
+	columnNumberStatus := -1
+	for num, col := range list.Columns {
+		if col.Name == nil {
+			continue
+		}
+
+		switch stringToStringLower(*col.Name) {
+		case "pretaxcost", "cost":
+			columnNumberCost = num
+		case "currency":
+			columnNumberCurrency = num
+		case "coststatus":
+			columnNumberStatus = num
+		}
+	}
+
+	// check if we detected all columns
+	if columnNumberCost == -1 || columnNumberCurrency == -1 {
+		return nil, fmt.Errorf("unable to detect columns of forecast")
+	}
+
+	for _, row := range list.Rows {
+		// only use forecasted values, actual costs are available via azurerm_costs_<query>
+		if columnNumberStatus != -1 {
+			if status, ok := row[columnNumberStatus].(string); ok && !strings.EqualFold(status, "Forecast") {
+				continue
+			}
+		}
+
+		cost := float64(0)
+		if v, ok := row[columnNumberCost].(float64); ok {
+			cost = v
+		}
+
+		currency, _ := row[columnNumberCurrency].(string)
+		ret[stringToStringLower(currency)] += cost
+	}
+
+	return ret, nil
+}
+
+// costsForecastQueryFilter combines the filter of the query with the dimension values
+func costsForecastQueryFilter(query MetricsCollectorAzureRmCostsQuery, values []string) *armcostmanagement.QueryFilter {
+	filters := []*armcostmanagement.QueryFilter{}
+
+	if query.Filter != nil {
+		filters = append(filters, buildCostQueryFilter(query.Filter))
+	}
+
+	for num, dimension := range query.Dimensions {
+		comparison := &config.CostQueryFilterComparison{
+			Name: dimension,
+			In:   []string{values[num]},
+		}
+
+		if strings.HasPrefix(strings.ToLower(dimension), "tag:") {
+			comparison.Name = dimension[len("tag:"):]
+			filters = append(filters, buildCostQueryFilter(&config.CostQueryFilter{Tag: comparison}))
+		} else {
+			filters = append(filters, buildCostQueryFilter(&config.CostQueryFilter{Dimension: comparison}))
+		}
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	default:
+		return &armcostmanagement.QueryFilter{And: filters}
+	}
+}
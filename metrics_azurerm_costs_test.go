@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+func TestSplitCostQueryFilter(t *testing.T) {
+	location := &config.CostQueryFilter{Dimension: &config.CostQueryFilterComparison{Name: "ResourceLocation", Equals: "westeurope"}}
+	env := &config.CostQueryFilter{Tag: &config.CostQueryFilterComparison{Name: "env", Equals: "prod"}}
+	notGlobal := &config.CostQueryFilter{Not: &config.CostQueryFilter{Dimension: &config.CostQueryFilterComparison{Name: "ResourceLocation", Equals: "global"}}}
+	orWithNot := &config.CostQueryFilter{Or: []*config.CostQueryFilter{location, notGlobal}}
+
+	tests := []struct {
+		name         string
+		filter       *config.CostQueryFilter
+		apiFilter    *config.CostQueryFilter
+		localFilters []*config.CostQueryFilter
+	}{
+		{
+			name:         "no filter",
+			filter:       nil,
+			apiFilter:    nil,
+			localFilters: nil,
+		},
+		{
+			name:         "without not",
+			filter:       &config.CostQueryFilter{And: []*config.CostQueryFilter{location, env}},
+			apiFilter:    &config.CostQueryFilter{And: []*config.CostQueryFilter{location, env}},
+			localFilters: nil,
+		},
+		{
+			name:         "not",
+			filter:       notGlobal,
+			apiFilter:    nil,
+			localFilters: []*config.CostQueryFilter{notGlobal},
+		},
+		{
+			name:         "or with not",
+			filter:       orWithNot,
+			apiFilter:    nil,
+			localFilters: []*config.CostQueryFilter{orWithNot},
+		},
+		{
+			name:         "and with one api expression",
+			filter:       &config.CostQueryFilter{And: []*config.CostQueryFilter{location, notGlobal}},
+			apiFilter:    location,
+			localFilters: []*config.CostQueryFilter{notGlobal},
+		},
+		{
+			name:         "and with multiple api expressions",
+			filter:       &config.CostQueryFilter{And: []*config.CostQueryFilter{location, notGlobal, env}},
+			apiFilter:    &config.CostQueryFilter{And: []*config.CostQueryFilter{location, env}},
+			localFilters: []*config.CostQueryFilter{notGlobal},
+		},
+		{
+			name:         "and with only local expressions",
+			filter:       &config.CostQueryFilter{And: []*config.CostQueryFilter{notGlobal, orWithNot}},
+			apiFilter:    nil,
+			localFilters: []*config.CostQueryFilter{notGlobal, orWithNot},
+		},
+		{
+			name: "nested and",
+			filter: &config.CostQueryFilter{And: []*config.CostQueryFilter{
+				env,
+				{And: []*config.CostQueryFilter{location, notGlobal}},
+			}},
+			apiFilter:    &config.CostQueryFilter{And: []*config.CostQueryFilter{env, location}},
+			localFilters: []*config.CostQueryFilter{notGlobal},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiFilter, localFilters := splitCostQueryFilter(test.filter)
+
+			if !reflect.DeepEqual(apiFilter, test.apiFilter) {
+				t.Errorf("expected api filter %+v, got %+v", test.apiFilter, apiFilter)
+			}
+
+			if !reflect.DeepEqual(localFilters, test.localFilters) {
+				t.Errorf("expected local filters %+v, got %+v", test.localFilters, localFilters)
+			}
+		})
+	}
+}
+
+func TestMatchesLocalFilters(t *testing.T) {
+	query := MetricsCollectorAzureRmCostsQuery{
+		LocalFilters: []*config.CostQueryFilter{
+			{Not: &config.CostQueryFilter{Dimension: &config.CostQueryFilterComparison{Name: "ResourceLocation", Equals: "global"}}},
+			{Not: &config.CostQueryFilter{Tag: &config.CostQueryFilterComparison{Name: "env", In: []string{"dev", "test"}}}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		values   map[string]string
+		expected bool
+	}{
+		{
+			name:     "all filters match",
+			values:   map[string]string{"resourcelocation": "westeurope", "tag:env": "prod"},
+			expected: true,
+		},
+		{
+			name:     "first filter fails",
+			values:   map[string]string{"resourcelocation": "Global", "tag:env": "prod"},
+			expected: false,
+		},
+		{
+			name:     "second filter fails",
+			values:   map[string]string{"resourcelocation": "westeurope", "tag:env": "test"},
+			expected: false,
+		},
+		{
+			name:     "missing values",
+			values:   map[string]string{},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := query.MatchesLocalFilters(test.values); result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
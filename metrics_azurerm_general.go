@@ -4,12 +4,11 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorAzureRmGeneral struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		subscription  *prometheus.GaugeVec
@@ -17,8 +16,8 @@ type MetricsCollectorAzureRmGeneral struct {
 	}
 }
 
-func (m *MetricsCollectorAzureRmGeneral) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmGeneral) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.subscription = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -27,8 +26,10 @@ func (m *MetricsCollectorAzureRmGeneral) Setup(collector *collector.Collector) {
 		},
 		[]string{
 			"resourceID",
+			"tenantID",
 			"subscriptionID",
 			"subscriptionName",
+			"managementGroup",
 			"spendingLimit",
 			"quotaID",
 			"locationPlacementID",
@@ -41,16 +42,18 @@ func (m *MetricsCollectorAzureRmGeneral) Setup(collector *collector.Collector) {
 func (m *MetricsCollectorAzureRmGeneral) Reset() {}
 
 func (m *MetricsCollectorAzureRmGeneral) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectSubscription(subscription, logger, callback)
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		m.collectSubscription(tenant, subscription, logger, callback)
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
 }
 
 // Collect Azure Subscription metrics
-func (m *MetricsCollectorAzureRmGeneral) collectSubscription(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
+func (m *MetricsCollectorAzureRmGeneral) collectSubscription(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
 	subscriptionMetric := m.Collector.GetMetricList("subscription")
 
 	spendingLimit := ""
@@ -60,8 +63,10 @@ func (m *MetricsCollectorAzureRmGeneral) collectSubscription(subscription *armsu
 
 	subscriptionMetric.AddInfo(prometheus.Labels{
 		"resourceID":          to.StringLower(subscription.ID),
+		"tenantID":            tenant.SubscriptionTenantID(subscription),
 		"subscriptionID":      to.StringLower(subscription.SubscriptionID),
 		"subscriptionName":    to.String(subscription.DisplayName),
+		"managementGroup":     tenant.ManagementGroup(to.String(subscription.SubscriptionID)),
 		"spendingLimit":       spendingLimit,
 		"quotaID":             to.StringLower(subscription.SubscriptionPolicies.QuotaID),
 		"locationPlacementID": to.StringLower(subscription.SubscriptionPolicies.LocationPlacementID),
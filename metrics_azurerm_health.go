@@ -9,12 +9,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorAzureRmHealth struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		resourceHealth                         *prometheus.GaugeVec
@@ -23,8 +22,8 @@ type MetricsCollectorAzureRmHealth struct {
 	}
 }
 
-func (m *MetricsCollectorAzureRmHealth) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmHealth) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.resourceHealth = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -32,6 +31,7 @@ func (m *MetricsCollectorAzureRmHealth) Setup(collector *collector.Collector) {
 			Help: "Azure Resource health status information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"resourceID",
 			"resourceGroup",
@@ -51,6 +51,7 @@ func (m *MetricsCollectorAzureRmHealth) Setup(collector *collector.Collector) {
 			Help: "Azure Resource health status information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"resourceID",
 			"resourceGroup",
@@ -64,6 +65,7 @@ func (m *MetricsCollectorAzureRmHealth) Setup(collector *collector.Collector) {
 			Help: "Azure Resource health status information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"resourceID",
 			"resourceGroup",
@@ -75,18 +77,20 @@ func (m *MetricsCollectorAzureRmHealth) Setup(collector *collector.Collector) {
 func (m *MetricsCollectorAzureRmHealth) Reset() {}
 
 func (m *MetricsCollectorAzureRmHealth) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectSubscription(subscription, logger, callback)
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		status.Check("resourcehealth.availabilitystatuses", m.collectSubscription(tenant, subscription, logger, callback))
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
 }
 
-func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armresourcehealth.NewAvailabilityStatusesClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmHealth) collectSubscription(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
+	client, err := armresourcehealth.NewAvailabilityStatusesClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	resourceHealthMetric := m.Collector.GetMetricList("resourceHealth")
@@ -100,7 +104,7 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -120,6 +124,7 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 
 			if resourceHealth.Properties.ReportedTime != nil {
 				resourceHealthReportTimeMetric.AddTime(prometheus.Labels{
+					"tenantID":       tenant.SubscriptionTenantID(subscription),
 					"subscriptionID": azureResource.Subscription,
 					"resourceID":     stringToStringLower(resourceId),
 					"resourceGroup":  azureResource.ResourceGroup,
@@ -128,6 +133,7 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 
 			if resourceHealth.Properties.RootCauseAttributionTime != nil {
 				resourceHealthRootCauseAttributionTimeMetric.AddTime(prometheus.Labels{
+					"tenantID":       tenant.SubscriptionTenantID(subscription),
 					"subscriptionID": azureResource.Subscription,
 					"resourceID":     stringToStringLower(resourceId),
 					"resourceGroup":  azureResource.ResourceGroup,
@@ -149,6 +155,7 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 						}
 
 						m.Logger().WithFields(log.Fields{
+							"tenantID":          tenant.SubscriptionTenantID(subscription),
 							"subscriptionID":    azureResource.Subscription,
 							"resourceID":        stringToStringLower(resourceId),
 							"resourceGroup":     azureResource.ResourceGroup,
@@ -156,12 +163,13 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 							"resourceHealth":    resourceHealthLogObject,
 						}).Info("unhealthy resource detected")
 
-						if opts.ResourceHealth.SummaryMaxLength > 0 {
-							summary = truncateStrings(to.String(resourceHealth.Properties.Summary), opts.ResourceHealth.SummaryMaxLength, "...")
+						if m.Opts().ResourceHealth.SummaryMaxLength > 0 {
+							summary = truncateStrings(to.String(resourceHealth.Properties.Summary), m.Opts().ResourceHealth.SummaryMaxLength, "...")
 						}
 					}
 
 					resourceHealthMetric.Add(prometheus.Labels{
+						"tenantID":            tenant.SubscriptionTenantID(subscription),
 						"subscriptionID":      azureResource.Subscription,
 						"resourceID":          stringToStringLower(resourceId),
 						"resourceGroup":       azureResource.ResourceGroup,
@@ -176,4 +184,6 @@ func (m *MetricsCollectorAzureRmHealth) collectSubscription(subscription *armsub
 			}
 		}
 	}
+
+	return nil
 }
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"fmt"
+
 	armauthorization "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorAzureRmIam struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		roleAssignmentCount *prometheus.GaugeVec
@@ -21,8 +22,8 @@ type MetricsCollectorAzureRmIam struct {
 	}
 }
 
-func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmIam) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.roleAssignmentCount = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -30,6 +31,7 @@ func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
 			Help: "Azure IAM RoleAssignment count",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 		},
 	)
@@ -41,6 +43,7 @@ func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
 			Help: "Azure IAM RoleAssignment information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"roleAssignmentID",
 			"resourceID",
@@ -57,6 +60,7 @@ func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
 			Help: "Azure IAM RoleDefinition information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"roleDefinitionID",
 			"name",
@@ -72,6 +76,7 @@ func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
 			Help: "Azure IAM Principal information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"principalID",
 			"principalName",
@@ -84,19 +89,21 @@ func (m *MetricsCollectorAzureRmIam) Setup(collector *collector.Collector) {
 func (m *MetricsCollectorAzureRmIam) Reset() {}
 
 func (m *MetricsCollectorAzureRmIam) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectRoleDefinitions(subscription, logger, callback)
-		m.collectRoleAssignments(subscription, logger, callback)
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		status.Check("roledefinitions", m.collectRoleDefinitions(tenant, subscription, logger, callback))
+		status.Check("roleassignments", m.collectRoleAssignments(tenant, subscription, logger, callback))
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
 }
 
-func (m *MetricsCollectorAzureRmIam) collectRoleDefinitions(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armauthorization.NewRoleDefinitionsClient(AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmIam) collectRoleDefinitions(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
+	client, err := armauthorization.NewRoleDefinitionsClient(tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	infoMetric := m.Collector.GetMetricList("roleDefinition")
@@ -106,7 +113,7 @@ func (m *MetricsCollectorAzureRmIam) collectRoleDefinitions(subscription *armsub
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -118,6 +125,7 @@ func (m *MetricsCollectorAzureRmIam) collectRoleDefinitions(subscription *armsub
 			azureResource, _ := armclient.ParseResourceId(resourceId)
 
 			infoLabels := prometheus.Labels{
+				"tenantID":         tenant.SubscriptionTenantID(subscription),
 				"subscriptionID":   azureResource.Subscription,
 				"roleDefinitionID": resourceId,
 				"name":             to.String(roleDefinition.Name),
@@ -127,14 +135,16 @@ func (m *MetricsCollectorAzureRmIam) collectRoleDefinitions(subscription *armsub
 			infoMetric.AddInfo(infoLabels)
 		}
 	}
+
+	return nil
 }
 
-func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
+func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
 	principalIdMap := map[string]string{}
 
-	client, err := armauthorization.NewRoleAssignmentsClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+	client, err := armauthorization.NewRoleAssignmentsClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	infoMetric := m.Collector.GetMetricList("roleAssignment")
@@ -147,7 +157,7 @@ func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(subscription *armsub
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -162,6 +172,7 @@ func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(subscription *armsub
 			azureResource, _ := armclient.ParseResourceId(resourceId)
 
 			infoLabels := prometheus.Labels{
+				"tenantID":         tenant.SubscriptionTenantID(subscription),
 				"subscriptionID":   azureResource.Subscription,
 				"roleAssignmentID": to.StringLower(roleAssignment.ID),
 				"roleDefinitionID": extractRoleDefinitionIdFromAzureId(to.StringLower(roleAssignment.Properties.RoleDefinitionID)),
@@ -175,18 +186,29 @@ func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(subscription *armsub
 		}
 	}
 
+	roleAssignmentCountMetric.Add(prometheus.Labels{
+		"tenantID":       tenant.SubscriptionTenantID(subscription),
+		"subscriptionID": to.StringLower(subscription.SubscriptionID),
+	}, count)
+
 	principalIdList := []string{}
 	for _, val := range principalIdMap {
 		principalIdList = append(principalIdList, val)
 	}
 
-	principalList, err := MsGraphClient.LookupPrincipalID(m.Context(), principalIdList...)
+	msGraphClient, err := tenant.MsGraphClient()
 	if err != nil {
-		logger.Panic(err)
+		return err
+	}
+
+	principalList, err := msGraphClient.LookupPrincipalID(m.Context(), principalIdList...)
+	if err != nil {
+		return fmt.Errorf("unable to lookup principals: %w", err)
 	}
 
 	for _, principal := range principalList {
 		principalMetric.AddInfo(prometheus.Labels{
+			"tenantID":       tenant.SubscriptionTenantID(subscription),
 			"subscriptionID": to.StringLower(subscription.SubscriptionID),
 			"principalID":    principal.ObjectID,
 			"principalName":  principal.DisplayName,
@@ -194,7 +216,5 @@ func (m *MetricsCollectorAzureRmIam) collectRoleAssignments(subscription *armsub
 		})
 	}
 
-	roleAssignmentCountMetric.Add(prometheus.Labels{
-		"subscriptionID": to.StringLower(subscription.SubscriptionID),
-	}, count)
+	return nil
 }
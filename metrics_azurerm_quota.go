@@ -1,32 +1,123 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	armmachinelearning "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/machinelearning/armmachinelearning/v3"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	cache "github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
+const (
+	quotaSqlApiVersion               = "2021-11-01"
+	quotaAppServiceApiVersion        = "2023-01-01"
+	quotaBatchApiVersion             = "2022-10-01"
+	quotaCosmosDbApiVersion          = "2022-11-15"
+	quotaContainerInstanceApiVersion = "2022-09-01"
+	quotaHDInsightApiVersion         = "2021-06-01"
+	quotaKubernetesApiVersion        = "2022-11-01"
+
+	// quotaLocationDiscoveryCacheTtl is the cache duration of the discovered locations of a subscription
+	quotaLocationDiscoveryCacheTtl = 6 * time.Hour
+)
+
+type (
+	// azureQuotaUsage is an usage of the resource provider usage apis which are not available in azure sdk
+	azureQuotaUsage struct {
+		Name         json.RawMessage `json:"name"`
+		CurrentValue *float64        `json:"currentValue"`
+		Limit        *float64        `json:"limit"`
+		Properties   *struct {
+			DisplayName  string   `json:"displayName"`
+			CurrentValue *float64 `json:"currentValue"`
+			Limit        *float64 `json:"limit"`
+		} `json:"properties"`
+	}
+
+	azureQuotaResource struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Location string `json:"location"`
+	}
+
+	azureQuotaBatchAccount struct {
+		azureQuotaResource
+		Properties struct {
+			DedicatedCoreQuota            *float64 `json:"dedicatedCoreQuota"`
+			LowPriorityCoreQuota          *float64 `json:"lowPriorityCoreQuota"`
+			PoolQuota                     *float64 `json:"poolQuota"`
+			ActiveJobAndJobScheduleQuota  *float64 `json:"activeJobAndJobScheduleQuota"`
+			DedicatedCoreQuotaPerVMFamily []struct {
+				Name      string   `json:"name"`
+				CoreQuota *float64 `json:"coreQuota"`
+			} `json:"dedicatedCoreQuotaPerVMFamily"`
+		} `json:"properties"`
+	}
+
+	azureQuotaKubernetesCluster struct {
+		azureQuotaResource
+		Properties struct {
+			AgentPoolProfiles []struct {
+				Name              string   `json:"name"`
+				Count             *float64 `json:"count"`
+				EnableAutoScaling bool     `json:"enableAutoScaling"`
+				MinCount          *float64 `json:"minCount"`
+				MaxCount          *float64 `json:"maxCount"`
+			} `json:"agentPoolProfiles"`
+		} `json:"properties"`
+	}
+)
+
 type MetricsCollectorAzureRmQuota struct {
-	collector.Processor
+	CollectorProcessor
+
+	// history is the daily history of the quota current values for the exhaustion forecast (nil if disabled)
+	history *QuotaHistory
+
+	// locationCache are the discovered locations of the subscriptions (--quota.location.discovery)
+	locationCache *cache.Cache
 
 	prometheus struct {
 		quota        *prometheus.GaugeVec
 		quotaCurrent *prometheus.GaugeVec
 		quotaLimit   *prometheus.GaugeVec
 		quotaUsage   *prometheus.GaugeVec
+
+		// Microsoft.Quota api
+		quotaAdjustable        *prometheus.GaugeVec
+		quotaRequestPending    *prometheus.GaugeVec
+		quotaRequest           *prometheus.GaugeVec
+		quotaRequestLimit      *prometheus.GaugeVec
+		quotaRequestSubmitTime *prometheus.GaugeVec
+
+		// exhaustion forecast
+		quotaGrowthRate        *prometheus.GaugeVec
+		quotaExhaustionSeconds *prometheus.GaugeVec
+
+		// limits of single resources (not subscription quotas)
+		batchAccountQuota              *prometheus.GaugeVec
+		cosmosDbAccountUsage           *prometheus.GaugeVec
+		cosmosDbAccountUsageLimit      *prometheus.GaugeVec
+		kubernetesAgentPoolNodes       *prometheus.GaugeVec
+		kubernetesAgentPoolAutoscaling *prometheus.GaugeVec
 	}
 }
 
-func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmQuota) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
+
+	m.locationCache = cache.New(quotaLocationDiscoveryCacheTtl, 10*time.Minute)
 
 	m.prometheus.quota = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -34,6 +125,7 @@ func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
 			Help: "Azure ResourceManager quota information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"location",
 			"scope",
@@ -48,6 +140,7 @@ func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
 			Help: "Azure ResourceManager quota current value",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"location",
 			"scope",
@@ -61,6 +154,7 @@ func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
 			Help: "Azure ResourceManager quota limit",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"location",
 			"scope",
@@ -74,6 +168,35 @@ func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
 			Help: "Azure ResourceManager quota usage in percent",
 		},
 		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"scope",
+			"quota",
+		},
+	)
+
+	m.prometheus.quotaAdjustable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_adjustable",
+			Help: "Azure ResourceManager quota limit can be increased by a quota request (Microsoft.Quota api)",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"scope",
+			"quota",
+		},
+	)
+
+	m.prometheus.quotaRequestPending = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_request_pending",
+			Help: "Azure ResourceManager quota limit requested by a pending quota request (Microsoft.Quota api)",
+		},
+		[]string{
+			"tenantID",
 			"subscriptionID",
 			"location",
 			"scope",
@@ -85,67 +208,401 @@ func (m *MetricsCollectorAzureRmQuota) Setup(collector *collector.Collector) {
 	m.Collector.RegisterMetricList("quotaCurrent", m.prometheus.quotaCurrent, true)
 	m.Collector.RegisterMetricList("quotaLimit", m.prometheus.quotaLimit, true)
 	m.Collector.RegisterMetricList("quotaUsage", m.prometheus.quotaUsage, true)
+	m.prometheus.quotaRequest = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_request_info",
+			Help: "Azure ResourceManager quota request information (Microsoft.Quota api)",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"scope",
+			"quota",
+			"requestID",
+			"state",
+		},
+	)
+
+	m.prometheus.quotaRequestLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_request_limit",
+			Help: "Azure ResourceManager quota limit requested by a quota request (Microsoft.Quota api)",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"scope",
+			"quota",
+			"requestID",
+		},
+	)
+
+	m.prometheus.quotaRequestSubmitTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_request_submit_timestamp_seconds",
+			Help: "Azure ResourceManager quota request submit time (Microsoft.Quota api)",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"scope",
+			"quota",
+			"requestID",
+		},
+	)
+
+	m.Collector.RegisterMetricList("quotaAdjustable", m.prometheus.quotaAdjustable, true)
+	m.Collector.RegisterMetricList("quotaRequestPending", m.prometheus.quotaRequestPending, true)
+	m.Collector.RegisterMetricList("quotaRequest", m.prometheus.quotaRequest, true)
+	m.Collector.RegisterMetricList("quotaRequestLimit", m.prometheus.quotaRequestLimit, true)
+	m.Collector.RegisterMetricList("quotaRequestSubmitTime", m.prometheus.quotaRequestSubmitTime, true)
+
+	m.prometheus.batchAccountQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_batch_account_quota",
+			Help: "Azure ResourceManager batch account quota (cores, pools and jobs of the account, current usage isn't available)",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"resourceID",
+			"quota",
+		},
+	)
+
+	m.prometheus.cosmosDbAccountUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_cosmosdb_account_usage",
+			Help: "Azure ResourceManager cosmos db account usage current value",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"resourceID",
+			"usage",
+			"usageName",
+		},
+	)
+
+	m.prometheus.cosmosDbAccountUsageLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_cosmosdb_account_usage_limit",
+			Help: "Azure ResourceManager cosmos db account usage limit",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"resourceID",
+			"usage",
+		},
+	)
+
+	m.prometheus.kubernetesAgentPoolNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_kubernetes_agentpool_nodes",
+			Help: "Azure ResourceManager AKS node pool node count",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"resourceID",
+			"agentPool",
+		},
+	)
+
+	m.prometheus.kubernetesAgentPoolAutoscaling = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_kubernetes_agentpool_autoscaling_nodes",
+			Help: "Azure ResourceManager AKS node pool min and max node count of the cluster autoscaler",
+		},
+		[]string{
+			"tenantID",
+			"subscriptionID",
+			"location",
+			"resourceID",
+			"agentPool",
+			"type",
+		},
+	)
+
+	m.Collector.RegisterMetricList("batchAccountQuota", m.prometheus.batchAccountQuota, true)
+	m.Collector.RegisterMetricList("cosmosDbAccountUsage", m.prometheus.cosmosDbAccountUsage, true)
+	m.Collector.RegisterMetricList("cosmosDbAccountUsageLimit", m.prometheus.cosmosDbAccountUsageLimit, true)
+	m.Collector.RegisterMetricList("kubernetesAgentPoolNodes", m.prometheus.kubernetesAgentPoolNodes, true)
+	m.Collector.RegisterMetricList("kubernetesAgentPoolAutoscaling", m.prometheus.kubernetesAgentPoolAutoscaling, true)
+
+	if m.Opts().Quota.Forecast {
+		m.prometheus.quotaGrowthRate = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "azurerm_quota_growth_rate",
+				Help: "Azure ResourceManager quota growth of the current value per day (linear regression of the daily values within the window)",
+			},
+			[]string{
+				"tenantID",
+				"subscriptionID",
+				"location",
+				"scope",
+				"quota",
+				"window",
+			},
+		)
+
+		m.prometheus.quotaExhaustionSeconds = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "azurerm_quota_exhaustion_seconds",
+				Help: "Azure ResourceManager quota estimated seconds until the limit is reached (based on the growth rate of the window)",
+			},
+			[]string{
+				"tenantID",
+				"subscriptionID",
+				"location",
+				"scope",
+				"quota",
+				"window",
+			},
+		)
+
+		m.Collector.RegisterMetricList("quotaGrowthRate", m.prometheus.quotaGrowthRate, true)
+		m.Collector.RegisterMetricList("quotaExhaustionSeconds", m.prometheus.quotaExhaustionSeconds, true)
+
+		m.history = newQuotaHistory()
+		if cachePath := m.Opts().GetCachePath("quota-history.json"); cachePath != nil {
+			if err := m.history.CacheLoad(*cachePath); err != nil {
+				m.Logger().Errorf("failed to load quota history: %v", err)
+			}
+		}
+	}
 }
 
 func (m *MetricsCollectorAzureRmQuota) Reset() {}
 
 func (m *MetricsCollectorAzureRmQuota) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
 
-		if registered, err := AzureClient.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.Compute"); registered {
-			m.collectAzureComputeUsage(subscription, logger, callback)
-		} else if err != nil {
-			logger.Error(err.Error())
+		locations, err := m.quotaLocations(tenant, subscription)
+		if !status.Check("locations", err) {
+			status.Finish()
+			return
 		}
-
-		if registered, err := AzureClient.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.Network"); registered {
-			m.collectAzureNetworkUsage(subscription, logger, callback)
-		} else if err != nil {
-			logger.Error(err.Error())
+		logger.Debugf("collecting quotas of locations: %v", strings.Join(locations, ", "))
+
+		// quota requests are collected with the Microsoft.Quota api for all providers
+		quotaApiRegistered := false
+		if m.Opts().Quota.RequestDays > 0 || len(m.Opts().Quota.Providers) > 0 {
+			registered, err := tenant.Client.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.Quota")
+			quotaApiRegistered = registered
+			if !registered {
+				status.Check("providers", err)
+			}
 		}
 
-		if registered, err := AzureClient.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.Storage"); registered {
-			m.collectAzureStorageUsage(subscription, logger, callback)
-		} else if err != nil {
-			logger.Error(err.Error())
-		}
+		for _, provider := range []struct {
+			name    string
+			api     string
+			collect func() error
+		}{
+			{"Microsoft.Compute", "compute.usages", func() error { return m.collectAzureComputeUsage(tenant, subscription, locations, logger, callback) }},
+			{"Microsoft.Network", "network.usages", func() error { return m.collectAzureNetworkUsage(tenant, subscription, locations, logger, callback) }},
+			{"Microsoft.Storage", "storage.usages", func() error { return m.collectAzureStorageUsage(tenant, subscription, locations, logger, callback) }},
+			{"Microsoft.MachineLearningServices", "machinelearning.usages", func() error {
+				return m.collectAzureMachineLearningUsage(tenant, subscription, locations, logger, callback)
+			}},
+			{"Microsoft.Sql", "sql.usages", func() error {
+				return m.collectAzureLocationUsage(tenant, subscription, locations, "Microsoft.Sql", quotaSqlApiVersion, "sql")
+			}},
+			{"Microsoft.Web", "appservice.usages", func() error {
+				return m.collectAzureLocationUsage(tenant, subscription, locations, "Microsoft.Web", quotaAppServiceApiVersion, "appservice")
+			}},
+			{"Microsoft.Batch", "batch.quotas", func() error { return m.collectAzureBatchUsage(tenant, subscription, locations) }},
+			{"Microsoft.DocumentDB", "cosmosdb.usages", func() error { return m.collectAzureCosmosDbUsage(tenant, subscription, locations) }},
+			{"Microsoft.ContainerInstance", "containerinstance.usages", func() error {
+				return m.collectAzureLocationUsage(tenant, subscription, locations, "Microsoft.ContainerInstance", quotaContainerInstanceApiVersion, "containerinstance")
+			}},
+			{"Microsoft.HDInsight", "hdinsight.usages", func() error {
+				return m.collectAzureLocationUsage(tenant, subscription, locations, "Microsoft.HDInsight", quotaHDInsightApiVersion, "hdinsight")
+			}},
+			{"Microsoft.ContainerService", "kubernetes.agentpools", func() error { return m.collectAzureKubernetesUsage(tenant, subscription, locations) }},
+		} {
+			// collected by the generic Microsoft.Quota API
+			if stringInSliceCI(provider.name, m.Opts().Quota.Providers) {
+				continue
+			}
 
-		if registered, err := AzureClient.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.Storage"); registered {
-			m.collectAzureStorageUsage(subscription, logger, callback)
-		} else if err != nil {
-			logger.Error(err.Error())
+			if registered, err := tenant.Client.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, provider.name); registered {
+				status.Check(provider.api, provider.collect())
+
+				if quotaApiRegistered && m.Opts().Quota.RequestDays > 0 {
+					status.Check("quota."+strings.ToLower(provider.name)+".requests", m.collectAzureQuotaRequests(tenant, subscription, locations, provider.name))
+				}
+			} else {
+				status.Check("providers", err)
+			}
 		}
 
-		if registered, err := AzureClient.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, "Microsoft.MachineLearningServices"); registered {
-			m.collectAzureMachineLearningUsage(subscription, logger, callback)
-		} else if err != nil {
-			logger.Error(err.Error())
+		if quotaApiRegistered {
+			for _, provider := range m.Opts().Quota.Providers {
+				if registered, err := tenant.Client.IsResourceProviderRegistered(m.Context(), *subscription.SubscriptionID, provider); registered {
+					status.Check("quota."+strings.ToLower(provider), m.collectAzureQuotaApiUsage(tenant, subscription, locations, provider))
+				} else {
+					status.Check("providers", err)
+				}
+			}
 		}
+
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
+	}
+
+	if m.history != nil {
+		m.collectForecast()
+	}
+}
+
+// collectForecast exports the growth rates and estimated exhaustion of the quotas and persists the history
+func (m *MetricsCollectorAzureRmQuota) collectForecast() {
+	growthRateMetric := m.Collector.GetMetricList("quotaGrowthRate")
+	exhaustionMetric := m.Collector.GetMetricList("quotaExhaustionSeconds")
+
+	for _, result := range m.history.Evaluate(time.Now()) {
+		labels := result.Labels
+		labels["window"] = fmt.Sprintf("%dd", result.Window)
+
+		growthRateMetric.Add(labels, result.GrowthRate)
+		if result.Exhaustion != nil {
+			exhaustionMetric.Add(labels, result.Exhaustion.Seconds())
+		}
+	}
+
+	if cachePath := m.Opts().GetCachePath("quota-history.json"); cachePath != nil {
+		if err := m.history.CacheSave(*cachePath); err != nil {
+			m.Logger().Errorf("failed to save quota history: %v", err)
+		}
 	}
 }
 
+// quotaLocations returns the locations of the subscription for quota collection, either the locations of the collector
+// (--azure.location) or the discovered locations (--quota.location.discovery), filtered by --quota.location.include/exclude
+func (m *MetricsCollectorAzureRmQuota) quotaLocations(tenant *AzureTenant, subscription *armsubscriptions.Subscription) ([]string, error) {
+	locations := []string{}
+	addLocation := func(location string) {
+		location = quotaLocationName(location)
+		if location == "" || location == "global" || stringInSliceCI(location, locations) {
+			return
+		}
+
+		if len(m.Opts().Quota.LocationInclude) > 0 && !stringInSliceCI(location, m.Opts().Quota.LocationInclude) {
+			return
+		}
+
+		if stringInSliceCI(location, m.Opts().Quota.LocationExclude) {
+			return
+		}
+
+		locations = append(locations, location)
+	}
+
+	switch m.Opts().Quota.LocationDiscovery {
+	case "resources", "all":
+		discoveredLocations, err := m.discoverLocations(tenant, subscription)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, location := range discoveredLocations {
+			addLocation(location)
+		}
+	default:
+		for _, location := range m.Opts().GetCollectorConfig(m.Collector.Name).GetLocations(m.Opts().Azure.Location) {
+			addLocation(location)
+		}
+	}
+
+	sort.Strings(locations)
+	return locations, nil
+}
+
+// discoverLocations returns the discovered locations of the subscription (cached for quotaLocationDiscoveryCacheTtl
+// as the resources mode lists all resources of the subscription)
+func (m *MetricsCollectorAzureRmQuota) discoverLocations(tenant *AzureTenant, subscription *armsubscriptions.Subscription) ([]string, error) {
+	discovery := m.Opts().Quota.LocationDiscovery
+	cacheKey := discovery + ":" + to.StringLower(subscription.SubscriptionID)
+	if cached, ok := m.locationCache.Get(cacheKey); ok {
+		return cached.([]string), nil
+	}
+
+	locations := []string{}
+	switch discovery {
+	case "resources":
+		client, err := armresources.NewClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		pager := client.NewListPager(nil)
+		for pager.More() {
+			result, err := pager.NextPage(m.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			for _, resource := range result.Value {
+				if location := to.String(resource.Location); !stringInSliceCI(location, locations) {
+					locations = append(locations, location)
+				}
+			}
+		}
+	case "all":
+		client, err := armsubscriptions.NewClient(tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		pager := client.NewListLocationsPager(*subscription.SubscriptionID, nil)
+		for pager.More() {
+			result, err := pager.NextPage(m.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			for _, location := range result.Value {
+				// logical locations (eg. "europe") don't have quotas
+				if location.Metadata != nil && location.Metadata.RegionType != nil && *location.Metadata.RegionType != armsubscriptions.RegionTypePhysical {
+					continue
+				}
+
+				locations = append(locations, to.String(location.Name))
+			}
+		}
+	}
+
+	m.locationCache.SetDefault(cacheKey, locations)
+	return locations, nil
+}
+
 // collectAzureComputeUsage collects compute usages
-func (m *MetricsCollectorAzureRmQuota) collectAzureComputeUsage(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armcompute.NewUsageClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmQuota) collectAzureComputeUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, logger *log.Entry, callback chan<- func()) error {
+	client, err := armcompute.NewUsageClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
-	quotaMetric := m.Collector.GetMetricList("quota")
-	quotaCurrentMetric := m.Collector.GetMetricList("quotaCurrent")
-	quotaLimitMetric := m.Collector.GetMetricList("quotaLimit")
-	quotaUsageMetric := m.Collector.GetMetricList("quotaUsage")
-
-	for _, location := range opts.Azure.Location {
+	for _, location := range locations {
 		pager := client.NewListPager(location, nil)
 
 		for pager.More() {
 			result, err := pager.NextPage(m.Context())
 			if err != nil {
-				logger.Panic(err)
+				return err
 			}
 
 			if result.Value == nil {
@@ -153,56 +610,30 @@ func (m *MetricsCollectorAzureRmQuota) collectAzureComputeUsage(subscription *ar
 			}
 
 			for _, resourceUsage := range result.Value {
-				quotaName := to.String(resourceUsage.Name.Value)
-				quotaNameLocalized := to.String(resourceUsage.Name.LocalizedValue)
 				currentValue := float64(to.Number(resourceUsage.CurrentValue))
 				limitValue := float64(to.Number(resourceUsage.Limit))
-
-				infoLabels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "compute",
-					"quota":          quotaName,
-					"quotaName":      quotaNameLocalized,
-				}
-
-				labels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "compute",
-					"quota":          quotaName,
-				}
-
-				quotaMetric.Add(infoLabels, 1)
-				quotaCurrentMetric.Add(labels, currentValue)
-				quotaLimitMetric.Add(labels, limitValue)
-				if limitValue != 0 {
-					quotaUsageMetric.Add(labels, currentValue/limitValue)
-				}
+				m.addQuotaMetrics(tenant, subscription, location, "compute", to.String(resourceUsage.Name.Value), to.String(resourceUsage.Name.LocalizedValue), &currentValue, &limitValue)
 			}
 		}
 	}
+
+	return nil
 }
 
-// collectAzureComputeUsage collects network usages
-func (m *MetricsCollectorAzureRmQuota) collectAzureNetworkUsage(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armnetwork.NewUsagesClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+// collectAzureNetworkUsage collects network usages
+func (m *MetricsCollectorAzureRmQuota) collectAzureNetworkUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, logger *log.Entry, callback chan<- func()) error {
+	client, err := armnetwork.NewUsagesClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
-	quotaMetric := m.Collector.GetMetricList("quota")
-	quotaCurrentMetric := m.Collector.GetMetricList("quotaCurrent")
-	quotaLimitMetric := m.Collector.GetMetricList("quotaLimit")
-	quotaUsageMetric := m.Collector.GetMetricList("quotaUsage")
-
-	for _, location := range opts.Azure.Location {
+	for _, location := range locations {
 		pager := client.NewListPager(location, nil)
 
 		for pager.More() {
 			result, err := pager.NextPage(m.Context())
 			if err != nil {
-				logger.Panic(err)
+				return err
 			}
 
 			if result.Value == nil {
@@ -210,56 +641,30 @@ func (m *MetricsCollectorAzureRmQuota) collectAzureNetworkUsage(subscription *ar
 			}
 
 			for _, resourceUsage := range result.Value {
-				quotaName := to.String(resourceUsage.Name.Value)
-				quotaNameLocalized := to.String(resourceUsage.Name.LocalizedValue)
 				currentValue := float64(to.Number(resourceUsage.CurrentValue))
 				limitValue := float64(to.Number(resourceUsage.Limit))
-
-				infoLabels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "network",
-					"quota":          quotaName,
-					"quotaName":      quotaNameLocalized,
-				}
-
-				labels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "network",
-					"quota":          quotaName,
-				}
-
-				quotaMetric.Add(infoLabels, 1)
-				quotaCurrentMetric.Add(labels, currentValue)
-				quotaLimitMetric.Add(labels, limitValue)
-				if limitValue != 0 {
-					quotaUsageMetric.Add(labels, currentValue/limitValue)
-				}
+				m.addQuotaMetrics(tenant, subscription, location, "network", to.String(resourceUsage.Name.Value), to.String(resourceUsage.Name.LocalizedValue), &currentValue, &limitValue)
 			}
 		}
 	}
+
+	return nil
 }
 
-// collectAzureComputeUsage collects storage usages
-func (m *MetricsCollectorAzureRmQuota) collectAzureStorageUsage(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armstorage.NewUsagesClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+// collectAzureStorageUsage collects storage usages
+func (m *MetricsCollectorAzureRmQuota) collectAzureStorageUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, logger *log.Entry, callback chan<- func()) error {
+	client, err := armstorage.NewUsagesClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
-	quotaMetric := m.Collector.GetMetricList("quota")
-	quotaCurrentMetric := m.Collector.GetMetricList("quotaCurrent")
-	quotaLimitMetric := m.Collector.GetMetricList("quotaLimit")
-	quotaUsageMetric := m.Collector.GetMetricList("quotaUsage")
-
-	for _, location := range opts.Azure.Location {
+	for _, location := range locations {
 		pager := client.NewListByLocationPager(location, nil)
 
 		for pager.More() {
 			result, err := pager.NextPage(m.Context())
 			if err != nil {
-				logger.Panic(err)
+				return err
 			}
 
 			if result.Value == nil {
@@ -267,56 +672,30 @@ func (m *MetricsCollectorAzureRmQuota) collectAzureStorageUsage(subscription *ar
 			}
 
 			for _, resourceUsage := range result.Value {
-				quotaName := to.String(resourceUsage.Name.Value)
-				quotaNameLocalized := to.String(resourceUsage.Name.LocalizedValue)
 				currentValue := float64(to.Number(resourceUsage.CurrentValue))
 				limitValue := float64(to.Number(resourceUsage.Limit))
-
-				infoLabels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "storage",
-					"quota":          quotaName,
-					"quotaName":      quotaNameLocalized,
-				}
-
-				labels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "storage",
-					"quota":          quotaName,
-				}
-
-				quotaMetric.Add(infoLabels, 1)
-				quotaCurrentMetric.Add(labels, currentValue)
-				quotaLimitMetric.Add(labels, limitValue)
-				if limitValue != 0 {
-					quotaUsageMetric.Add(labels, currentValue/limitValue)
-				}
+				m.addQuotaMetrics(tenant, subscription, location, "storage", to.String(resourceUsage.Name.Value), to.String(resourceUsage.Name.LocalizedValue), &currentValue, &limitValue)
 			}
 		}
 	}
+
+	return nil
 }
 
-// collectAzureComputeUsage collects machinelearning usages
-func (m *MetricsCollectorAzureRmQuota) collectAzureMachineLearningUsage(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armmachinelearning.NewUsagesClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+// collectAzureMachineLearningUsage collects machine learning usages
+func (m *MetricsCollectorAzureRmQuota) collectAzureMachineLearningUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, logger *log.Entry, callback chan<- func()) error {
+	client, err := armmachinelearning.NewUsagesClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
-	quotaMetric := m.Collector.GetMetricList("quota")
-	quotaCurrentMetric := m.Collector.GetMetricList("quotaCurrent")
-	quotaLimitMetric := m.Collector.GetMetricList("quotaLimit")
-	quotaUsageMetric := m.Collector.GetMetricList("quotaUsage")
-
-	for _, location := range opts.Azure.Location {
+	for _, location := range locations {
 		pager := client.NewListPager(location, nil)
 
 		for pager.More() {
 			result, err := pager.NextPage(m.Context())
 			if err != nil {
-				logger.Panic(err)
+				return err
 			}
 
 			if result.Value == nil {
@@ -324,33 +703,270 @@ func (m *MetricsCollectorAzureRmQuota) collectAzureMachineLearningUsage(subscrip
 			}
 
 			for _, resourceUsage := range result.Value {
-				quotaName := to.String(resourceUsage.Name.Value)
-				quotaNameLocalized := to.String(resourceUsage.Name.LocalizedValue)
 				currentValue := float64(to.Number(resourceUsage.CurrentValue))
 				limitValue := float64(to.Number(resourceUsage.Limit))
+				m.addQuotaMetrics(tenant, subscription, location, "machinelearningservices", to.String(resourceUsage.Name.Value), to.String(resourceUsage.Name.LocalizedValue), &currentValue, &limitValue)
+			}
+		}
+	}
 
-				infoLabels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "machinelearningservices",
-					"quota":          quotaName,
-					"quotaName":      quotaNameLocalized,
-				}
+	return nil
+}
 
-				labels := prometheus.Labels{
-					"subscriptionID": to.StringLower(subscription.SubscriptionID),
-					"location":       strings.ToLower(location),
-					"scope":          "machinelearningservices",
-					"quota":          quotaName,
-				}
+// collectAzureLocationUsage collects the usages of resource providers without azure sdk usage client
+// (/subscriptions/{id}/providers/{provider}/locations/{location}/usages)
+func (m *MetricsCollectorAzureRmQuota) collectAzureLocationUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, provider, apiVersion, scope string) error {
+	for _, location := range locations {
+		path := fmt.Sprintf("/subscriptions/%v/providers/%v/locations/%v/usages", *subscription.SubscriptionID, provider, location)
+		err := armListAll(m.Context(), tenant, path, apiVersion, func(value json.RawMessage) error {
+			resourceUsage := azureQuotaUsage{}
+			if err := json.Unmarshal(value, &resourceUsage); err != nil {
+				return err
+			}
+
+			quotaName, quotaNameLocalized, currentValue, limitValue := resourceUsage.Values()
+			m.addQuotaMetrics(tenant, subscription, location, scope, quotaName, quotaNameLocalized, &currentValue, &limitValue)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-				quotaMetric.Add(infoLabels, 1)
-				quotaCurrentMetric.Add(labels, currentValue)
-				quotaLimitMetric.Add(labels, limitValue)
-				if limitValue != 0 {
-					quotaUsageMetric.Add(labels, currentValue/limitValue)
+	return nil
+}
+
+// collectAzureBatchUsage collects the batch account quota of the locations, the core/pool/job quotas of the batch accounts
+// are exported as azurerm_batch_account_quota (no subscription quota, the usage isn't available in the resource manager api)
+func (m *MetricsCollectorAzureRmQuota) collectAzureBatchUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string) error {
+	accountCount := map[string]float64{}
+
+	path := fmt.Sprintf("/subscriptions/%v/providers/Microsoft.Batch/batchAccounts", *subscription.SubscriptionID)
+	err := armListAll(m.Context(), tenant, path, quotaBatchApiVersion, func(value json.RawMessage) error {
+		account := azureQuotaBatchAccount{}
+		if err := json.Unmarshal(value, &account); err != nil {
+			return err
+		}
+
+		location := quotaLocationName(account.Location)
+		if !stringInSliceCI(location, locations) {
+			return nil
+		}
+		accountCount[location]++
+
+		accountQuotas := map[string]*float64{
+			"dedicatedCores":            account.Properties.DedicatedCoreQuota,
+			"lowPriorityCores":          account.Properties.LowPriorityCoreQuota,
+			"pools":                     account.Properties.PoolQuota,
+			"activeJobsAndJobSchedules": account.Properties.ActiveJobAndJobScheduleQuota,
+		}
+		for _, family := range account.Properties.DedicatedCoreQuotaPerVMFamily {
+			accountQuotas[family.Name] = family.CoreQuota
+		}
+
+		for quotaName, limitValue := range accountQuotas {
+			if limitValue == nil {
+				continue
+			}
+
+			m.Collector.GetMetricList("batchAccountQuota").Add(prometheus.Labels{
+				"tenantID":       tenant.SubscriptionTenantID(subscription),
+				"subscriptionID": to.StringLower(subscription.SubscriptionID),
+				"location":       location,
+				"resourceID":     strings.ToLower(account.ID),
+				"quota":          quotaName,
+			}, *limitValue)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, location := range locations {
+		quota := struct {
+			AccountQuota *float64 `json:"accountQuota"`
+		}{}
+
+		path := fmt.Sprintf("/subscriptions/%v/providers/Microsoft.Batch/locations/%v/quotas", *subscription.SubscriptionID, location)
+		if err := armGet(m.Context(), tenant, path, quotaBatchApiVersion, &quota); err != nil {
+			return err
+		}
+
+		if quota.AccountQuota != nil {
+			currentValue := accountCount[quotaLocationName(location)]
+			m.addQuotaMetrics(tenant, subscription, location, "batch", "batchAccounts", "Batch accounts", &currentValue, quota.AccountQuota)
+		}
+	}
+
+	return nil
+}
+
+// collectAzureCosmosDbUsage collects the usages of the cosmos db accounts as azurerm_cosmosdb_account_usage*
+// (limits of the accounts, no subscription quotas)
+func (m *MetricsCollectorAzureRmQuota) collectAzureCosmosDbUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string) error {
+	accounts := []azureQuotaResource{}
+	path := fmt.Sprintf("/subscriptions/%v/providers/Microsoft.DocumentDB/databaseAccounts", *subscription.SubscriptionID)
+	err := armListAll(m.Context(), tenant, path, quotaCosmosDbApiVersion, func(value json.RawMessage) error {
+		account := azureQuotaResource{}
+		if err := json.Unmarshal(value, &account); err != nil {
+			return err
+		}
+
+		if stringInSliceCI(quotaLocationName(account.Location), locations) {
+			accounts = append(accounts, account)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		err := armListAll(m.Context(), tenant, account.ID+"/usages", quotaCosmosDbApiVersion, func(value json.RawMessage) error {
+			resourceUsage := azureQuotaUsage{}
+			if err := json.Unmarshal(value, &resourceUsage); err != nil {
+				return err
+			}
+
+			usageName, usageNameLocalized, currentValue, limitValue := resourceUsage.Values()
+
+			labels := prometheus.Labels{
+				"tenantID":       tenant.SubscriptionTenantID(subscription),
+				"subscriptionID": to.StringLower(subscription.SubscriptionID),
+				"location":       quotaLocationName(account.Location),
+				"resourceID":     strings.ToLower(account.ID),
+				"usage":          usageName,
+			}
+			usageLabels := copyPrometheusLabels(labels)
+			usageLabels["usageName"] = usageNameLocalized
+
+			m.Collector.GetMetricList("cosmosDbAccountUsage").Add(usageLabels, currentValue)
+			if limitValue > 0 {
+				m.Collector.GetMetricList("cosmosDbAccountUsageLimit").Add(labels, limitValue)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectAzureKubernetesUsage collects the node count and the autoscaler min/max count of the node pools of the AKS clusters
+// as azurerm_kubernetes_agentpool_* (settings of the clusters, no subscription quotas)
+func (m *MetricsCollectorAzureRmQuota) collectAzureKubernetesUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string) error {
+	path := fmt.Sprintf("/subscriptions/%v/providers/Microsoft.ContainerService/managedClusters", *subscription.SubscriptionID)
+	return armListAll(m.Context(), tenant, path, quotaKubernetesApiVersion, func(value json.RawMessage) error {
+		cluster := azureQuotaKubernetesCluster{}
+		if err := json.Unmarshal(value, &cluster); err != nil {
+			return err
+		}
+
+		location := quotaLocationName(cluster.Location)
+		if !stringInSliceCI(location, locations) {
+			return nil
+		}
+
+		for _, agentPool := range cluster.Properties.AgentPoolProfiles {
+			labels := prometheus.Labels{
+				"tenantID":       tenant.SubscriptionTenantID(subscription),
+				"subscriptionID": to.StringLower(subscription.SubscriptionID),
+				"location":       location,
+				"resourceID":     strings.ToLower(cluster.ID),
+				"agentPool":      agentPool.Name,
+			}
+
+			if agentPool.Count != nil {
+				m.Collector.GetMetricList("kubernetesAgentPoolNodes").Add(labels, *agentPool.Count)
+			}
+
+			if !agentPool.EnableAutoScaling {
+				continue
+			}
+
+			for countType, count := range map[string]*float64{"min": agentPool.MinCount, "max": agentPool.MaxCount} {
+				if count != nil {
+					autoscalingLabels := copyPrometheusLabels(labels)
+					autoscalingLabels["type"] = countType
+					m.Collector.GetMetricList("kubernetesAgentPoolAutoscaling").Add(autoscalingLabels, *count)
 				}
 			}
 		}
+
+		return nil
+	})
+}
+
+// addQuotaMetrics adds the quota metrics (current and limit are optional), usage is only added if both are set
+func (m *MetricsCollectorAzureRmQuota) addQuotaMetrics(tenant *AzureTenant, subscription *armsubscriptions.Subscription, location, scope, quotaName, quotaNameLocalized string, currentValue, limitValue *float64) {
+	labels := prometheus.Labels{
+		"tenantID":       tenant.SubscriptionTenantID(subscription),
+		"subscriptionID": to.StringLower(subscription.SubscriptionID),
+		"location":       strings.ToLower(location),
+		"scope":          scope,
+		"quota":          quotaName,
+	}
+
+	infoLabels := copyPrometheusLabels(labels)
+	infoLabels["quotaName"] = quotaNameLocalized
+
+	m.Collector.GetMetricList("quota").Add(infoLabels, 1)
+	if currentValue != nil {
+		m.Collector.GetMetricList("quotaCurrent").Add(labels, *currentValue)
+	}
+	if limitValue != nil {
+		m.Collector.GetMetricList("quotaLimit").Add(labels, *limitValue)
+	}
+	if currentValue != nil && limitValue != nil && *limitValue != 0 {
+		m.Collector.GetMetricList("quotaUsage").Add(labels, *currentValue / *limitValue)
+	}
+
+	if m.history != nil && currentValue != nil {
+		m.history.Add(time.Now(), labels, *currentValue, limitValue)
+	}
+}
+
+// Values returns name, localized name, current value and limit of the usage
+// (usages have either the name object or the name as string and the values inside properties, eg. Microsoft.Sql)
+func (u *azureQuotaUsage) Values() (quotaName, quotaNameLocalized string, currentValue, limitValue float64) {
+	name := struct {
+		Value          string `json:"value"`
+		LocalizedValue string `json:"localizedValue"`
+	}{}
+	if err := json.Unmarshal(u.Name, &name); err != nil {
+		// name is a string
+		_ = json.Unmarshal(u.Name, &name.Value)
+	}
+
+	quotaName = name.Value
+	quotaNameLocalized = name.LocalizedValue
+	currentValue = to.Float64(u.CurrentValue)
+	limitValue = to.Float64(u.Limit)
+
+	if u.Properties != nil {
+		if u.Properties.DisplayName != "" {
+			quotaNameLocalized = u.Properties.DisplayName
+		}
+		if u.Properties.CurrentValue != nil {
+			currentValue = *u.Properties.CurrentValue
+		}
+		if u.Properties.Limit != nil {
+			limitValue = *u.Properties.Limit
+		}
 	}
+
+	if quotaNameLocalized == "" {
+		quotaNameLocalized = quotaName
+	}
+
+	return
+}
+
+// quotaLocationName returns the location name (eg. "westeurope" for "West Europe")
+func quotaLocationName(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
 }
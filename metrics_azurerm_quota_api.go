@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	quotaApiVersion = "2023-02-01"
+)
+
+var (
+	// quotaApiScopes are the scope labels of the providers with dedicated usage collection,
+	// the generic Microsoft.Quota api keeps them so metrics don't change when switching (--quota.provider)
+	quotaApiScopes = map[string]string{
+		"microsoft.compute":                 "compute",
+		"microsoft.network":                 "network",
+		"microsoft.storage":                 "storage",
+		"microsoft.machinelearningservices": "machinelearningservices",
+		"microsoft.sql":                     "sql",
+		"microsoft.web":                     "appservice",
+		"microsoft.batch":                   "batch",
+		"microsoft.documentdb":              "cosmosdb",
+		"microsoft.containerinstance":       "containerinstance",
+		"microsoft.hdinsight":               "hdinsight",
+		"microsoft.containerservice":        "kubernetes",
+	}
+
+	// quotaApiPendingStates are the provisioning states of quota requests which are not finished yet
+	quotaApiPendingStates = []string{"Accepted", "InProgress"}
+)
+
+type (
+	azureQuotaApiName struct {
+		Value          string `json:"value"`
+		LocalizedValue string `json:"localizedValue"`
+	}
+
+	azureQuotaApiLimit struct {
+		LimitObjectType string   `json:"limitObjectType"`
+		Value           *float64 `json:"value"`
+	}
+
+	azureQuotaApiUsage struct {
+		Properties struct {
+			Name   azureQuotaApiName `json:"name"`
+			Usages struct {
+				Value *float64 `json:"value"`
+			} `json:"usages"`
+		} `json:"properties"`
+	}
+
+	azureQuotaApiQuota struct {
+		Properties struct {
+			Name              azureQuotaApiName  `json:"name"`
+			Limit             azureQuotaApiLimit `json:"limit"`
+			IsQuotaApplicable *bool              `json:"isQuotaApplicable"`
+		} `json:"properties"`
+	}
+
+	azureQuotaApiRequest struct {
+		Name       string `json:"name"`
+		Properties struct {
+			ProvisioningState string     `json:"provisioningState"`
+			RequestSubmitTime *time.Time `json:"requestSubmitTime"`
+			Value             []struct {
+				Name              azureQuotaApiName  `json:"name"`
+				ProvisioningState string             `json:"provisioningState"`
+				Limit             azureQuotaApiLimit `json:"limit"`
+			} `json:"value"`
+		} `json:"properties"`
+	}
+
+	// azureQuotaApiValue is the merged usage and quota of a quota name
+	azureQuotaApiValue struct {
+		name       azureQuotaApiName
+		current    *float64
+		limit      *float64
+		adjustable *bool
+	}
+)
+
+// collectAzureQuotaApiUsage collects the usages, limits and pending quota requests of a resource provider
+// with the generic Microsoft.Quota api (/{scope}/providers/Microsoft.Quota/{usages,quotas,quotaRequests})
+func (m *MetricsCollectorAzureRmQuota) collectAzureQuotaApiUsage(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, provider string) error {
+	scope := quotaApiScope(provider)
+
+	for _, location := range locations {
+		quotaScope := fmt.Sprintf("/subscriptions/%v/providers/%v/locations/%v", *subscription.SubscriptionID, provider, location)
+
+		quotaValues := map[string]*azureQuotaApiValue{}
+		quotaNames := []string{}
+		quotaValue := func(name azureQuotaApiName) *azureQuotaApiValue {
+			if _, exists := quotaValues[name.Value]; !exists {
+				quotaValues[name.Value] = &azureQuotaApiValue{name: name}
+				quotaNames = append(quotaNames, name.Value)
+			}
+			return quotaValues[name.Value]
+		}
+
+		err := armListAll(m.Context(), tenant, quotaScope+"/providers/Microsoft.Quota/usages", quotaApiVersion, func(value json.RawMessage) error {
+			usage := azureQuotaApiUsage{}
+			if err := json.Unmarshal(value, &usage); err != nil {
+				return err
+			}
+
+			quotaValue(usage.Properties.Name).current = usage.Properties.Usages.Value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = armListAll(m.Context(), tenant, quotaScope+"/providers/Microsoft.Quota/quotas", quotaApiVersion, func(value json.RawMessage) error {
+			quota := azureQuotaApiQuota{}
+			if err := json.Unmarshal(value, &quota); err != nil {
+				return err
+			}
+
+			entry := quotaValue(quota.Properties.Name)
+			entry.limit = quota.Properties.Limit.Value
+			entry.adjustable = quota.Properties.IsQuotaApplicable
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		pendingLimits, err := m.collectAzureQuotaApiRequests(tenant, subscription, location, provider)
+		if err != nil {
+			return err
+		}
+
+		for _, quotaName := range quotaNames {
+			entry := quotaValues[quotaName]
+			quotaNameLocalized := entry.name.LocalizedValue
+			if quotaNameLocalized == "" {
+				quotaNameLocalized = quotaName
+			}
+
+			m.addQuotaMetrics(tenant, subscription, location, scope, quotaName, quotaNameLocalized, entry.current, entry.limit)
+
+			labels := prometheus.Labels{
+				"tenantID":       tenant.SubscriptionTenantID(subscription),
+				"subscriptionID": to.StringLower(subscription.SubscriptionID),
+				"location":       strings.ToLower(location),
+				"scope":          scope,
+				"quota":          quotaName,
+			}
+
+			if entry.adjustable != nil {
+				m.Collector.GetMetricList("quotaAdjustable").AddBool(labels, *entry.adjustable)
+			}
+		}
+
+		m.addQuotaRequestPendingMetrics(tenant, subscription, location, scope, pendingLimits)
+	}
+
+	return nil
+}
+
+// collectAzureQuotaRequests collects the quota requests of a resource provider with dedicated usage collection,
+// providers which are not supported by the Microsoft.Quota api are skipped
+func (m *MetricsCollectorAzureRmQuota) collectAzureQuotaRequests(tenant *AzureTenant, subscription *armsubscriptions.Subscription, locations []string, provider string) error {
+	for _, location := range locations {
+		pendingLimits, err := m.collectAzureQuotaApiRequests(tenant, subscription, location, provider)
+		if err != nil {
+			if quotaApiNotSupported(err) {
+				m.Logger().Debugf("quota requests of %v are not supported by the Microsoft.Quota api: %v", provider, err)
+				return nil
+			}
+			return err
+		}
+
+		m.addQuotaRequestPendingMetrics(tenant, subscription, location, quotaApiScope(provider), pendingLimits)
+	}
+
+	return nil
+}
+
+// collectAzureQuotaApiRequests collects the quota requests of a resource provider and location (submitted within --quota.request.days),
+// returns the highest requested limit of the pending requests of each quota
+func (m *MetricsCollectorAzureRmQuota) collectAzureQuotaApiRequests(tenant *AzureTenant, subscription *armsubscriptions.Subscription, location, provider string) (map[string]float64, error) {
+	scope := quotaApiScope(provider)
+	quotaScope := fmt.Sprintf("/subscriptions/%v/providers/%v/locations/%v", *subscription.SubscriptionID, provider, location)
+	requestCutoff := time.Now().AddDate(0, 0, -m.Opts().Quota.RequestDays)
+
+	pendingLimits := map[string]float64{}
+	err := armListAll(m.Context(), tenant, quotaScope+"/providers/Microsoft.Quota/quotaRequests", quotaApiVersion, func(value json.RawMessage) error {
+		request := azureQuotaApiRequest{}
+		if err := json.Unmarshal(value, &request); err != nil {
+			return err
+		}
+
+		for _, subRequest := range request.Properties.Value {
+			// sub requests have their own state (eg. partially approved requests)
+			state := subRequest.ProvisioningState
+			if state == "" {
+				state = request.Properties.ProvisioningState
+			}
+
+			if m.Opts().Quota.RequestDays > 0 && request.Properties.RequestSubmitTime != nil && request.Properties.RequestSubmitTime.After(requestCutoff) {
+				m.addQuotaRequestMetrics(tenant, subscription, location, scope, request, subRequest.Name.Value, state, subRequest.Limit.Value)
+			}
+
+			if subRequest.Limit.Value == nil || !stringInSliceCI(state, quotaApiPendingStates) {
+				continue
+			}
+
+			if *subRequest.Limit.Value > pendingLimits[subRequest.Name.Value] {
+				pendingLimits[subRequest.Name.Value] = *subRequest.Limit.Value
+			}
+		}
+		return nil
+	})
+
+	return pendingLimits, err
+}
+
+// addQuotaRequestPendingMetrics adds the requested limits of the pending quota requests
+func (m *MetricsCollectorAzureRmQuota) addQuotaRequestPendingMetrics(tenant *AzureTenant, subscription *armsubscriptions.Subscription, location, scope string, pendingLimits map[string]float64) {
+	for quotaName, pendingLimit := range pendingLimits {
+		labels := prometheus.Labels{
+			"tenantID":       tenant.SubscriptionTenantID(subscription),
+			"subscriptionID": to.StringLower(subscription.SubscriptionID),
+			"location":       strings.ToLower(location),
+			"scope":          scope,
+			"quota":          quotaName,
+		}
+		m.Collector.GetMetricList("quotaRequestPending").Add(labels, pendingLimit)
+	}
+}
+
+// addQuotaRequestMetrics adds the state, requested limit and submit time of a quota (sub) request
+func (m *MetricsCollectorAzureRmQuota) addQuotaRequestMetrics(tenant *AzureTenant, subscription *armsubscriptions.Subscription, location, scope string, request azureQuotaApiRequest, quotaName, state string, limitValue *float64) {
+	labels := prometheus.Labels{
+		"tenantID":       tenant.SubscriptionTenantID(subscription),
+		"subscriptionID": to.StringLower(subscription.SubscriptionID),
+		"location":       strings.ToLower(location),
+		"scope":          scope,
+		"quota":          quotaName,
+		"requestID":      strings.ToLower(request.Name),
+	}
+
+	infoLabels := copyPrometheusLabels(labels)
+	infoLabels["state"] = state
+
+	m.Collector.GetMetricList("quotaRequest").AddInfo(infoLabels)
+	m.Collector.GetMetricList("quotaRequestSubmitTime").AddTime(labels, *request.Properties.RequestSubmitTime)
+	if limitValue != nil {
+		m.Collector.GetMetricList("quotaRequestLimit").Add(labels, *limitValue)
+	}
+}
+
+// quotaApiNotSupported checks if the Microsoft.Quota api doesn't support the resource provider
+func quotaApiNotSupported(err error) bool {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode == http.StatusBadRequest || responseErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// quotaApiScope returns the scope label of a resource provider (eg. "compute" for Microsoft.Compute)
+func quotaApiScope(provider string) string {
+	provider = strings.ToLower(provider)
+	if scope, exists := quotaApiScopes[provider]; exists {
+		return scope
+	}
+
+	return strings.TrimPrefix(provider, "microsoft.")
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/consumption/armconsumption"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	reservationApiVersion = "2022-11-01"
+	savingsPlanApiVersion = "2022-11-01"
+)
+
+type (
+	MetricsCollectorAzureRmReservation struct {
+		CollectorProcessor
+
+		prometheus struct {
+			reservationInfo        *prometheus.GaugeVec
+			reservationQuantity    *prometheus.GaugeVec
+			reservationHours       *prometheus.GaugeVec
+			reservationUtilization *prometheus.GaugeVec
+			reservationExpiry      *prometheus.GaugeVec
+			reservationRenew       *prometheus.GaugeVec
+
+			savingsPlanInfo        *prometheus.GaugeVec
+			savingsPlanCommitment  *prometheus.GaugeVec
+			savingsPlanUtilization *prometheus.GaugeVec
+			savingsPlanExpiry      *prometheus.GaugeVec
+			savingsPlanRenew       *prometheus.GaugeVec
+		}
+	}
+
+	// azureReservation is a reservation of the Microsoft.Capacity api (not available in azure sdk)
+	azureReservation struct {
+		ID       string `json:"id"`
+		Location string `json:"location"`
+		Sku      struct {
+			Name string `json:"name"`
+		} `json:"sku"`
+		Properties struct {
+			DisplayName          string                   `json:"displayName"`
+			ReservedResourceType string                   `json:"reservedResourceType"`
+			Quantity             *float64                 `json:"quantity"`
+			AppliedScopeType     string                   `json:"appliedScopeType"`
+			ProvisioningState    string                   `json:"provisioningState"`
+			Term                 string                   `json:"term"`
+			Renew                *bool                    `json:"renew"`
+			ExpiryDate           string                   `json:"expiryDate"`
+			ExpiryDateTime       string                   `json:"expiryDateTime"`
+			Utilization          *azureBenefitUtilization `json:"utilization"`
+		} `json:"properties"`
+	}
+
+	// azureSavingsPlan is a savings plan of the Microsoft.BillingBenefits api (not available in azure sdk)
+	azureSavingsPlan struct {
+		ID  string `json:"id"`
+		Sku struct {
+			Name string `json:"name"`
+		} `json:"sku"`
+		Properties struct {
+			DisplayName       string `json:"displayName"`
+			Term              string `json:"term"`
+			AppliedScopeType  string `json:"appliedScopeType"`
+			BillingScopeID    string `json:"billingScopeId"`
+			ProvisioningState string `json:"provisioningState"`
+			Renew             *bool  `json:"renew"`
+			ExpiryDateTime    string `json:"expiryDateTime"`
+			Commitment        *struct {
+				Grain        string   `json:"grain"`
+				CurrencyCode string   `json:"currencyCode"`
+				Amount       *float64 `json:"amount"`
+			} `json:"commitment"`
+			Utilization *azureBenefitUtilization `json:"utilization"`
+		} `json:"properties"`
+	}
+
+	// azureBenefitUtilization is the utilization of reservations and savings plans (eg. for the last 1, 7 and 30 days)
+	azureBenefitUtilization struct {
+		Aggregates []struct {
+			Grain     *float64 `json:"grain"`
+			GrainUnit string   `json:"grainUnit"`
+			Value     *float64 `json:"value"`
+			ValueUnit string   `json:"valueUnit"`
+		} `json:"aggregates"`
+	}
+)
+
+func (m *MetricsCollectorAzureRmReservation) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
+
+	reservationLabels := []string{
+		"tenantID",
+		"reservationOrderID",
+		"reservationID",
+	}
+
+	savingsPlanLabels := []string{
+		"tenantID",
+		"savingsPlanOrderID",
+		"savingsPlanID",
+	}
+
+	m.prometheus.reservationInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_info",
+			Help: "Azure Reservation information",
+		},
+		append(
+			append([]string{}, reservationLabels...),
+			"displayName",
+			"skuName",
+			"location",
+			"resourceType",
+			"appliedScopeType",
+			"provisioningState",
+			"term",
+		),
+	)
+	m.Collector.RegisterMetricList("reservationInfo", m.prometheus.reservationInfo, true)
+
+	m.prometheus.reservationQuantity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_quantity",
+			Help: "Azure Reservation quantity (purchased, reserved and used quantity of the current month)",
+		},
+		append(append([]string{}, reservationLabels...), "type"),
+	)
+	m.Collector.RegisterMetricList("reservationQuantity", m.prometheus.reservationQuantity, true)
+
+	m.prometheus.reservationHours = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_hours",
+			Help: "Azure Reservation reserved and used hours of the current month",
+		},
+		append(append([]string{}, reservationLabels...), "type"),
+	)
+	m.Collector.RegisterMetricList("reservationHours", m.prometheus.reservationHours, true)
+
+	m.prometheus.reservationUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_utilization_percentage",
+			Help: "Azure Reservation utilization in percentage by grain (eg. 7days or month)",
+		},
+		append(append([]string{}, reservationLabels...), "grain"),
+	)
+	m.Collector.RegisterMetricList("reservationUtilization", m.prometheus.reservationUtilization, true)
+
+	m.prometheus.reservationExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_expiry_timestamp_seconds",
+			Help: "Azure Reservation expiry timestamp",
+		},
+		reservationLabels,
+	)
+	m.Collector.RegisterMetricList("reservationExpiry", m.prometheus.reservationExpiry, true)
+
+	m.prometheus.reservationRenew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_reservation_renew",
+			Help: "Azure Reservation auto renew state",
+		},
+		reservationLabels,
+	)
+	m.Collector.RegisterMetricList("reservationRenew", m.prometheus.reservationRenew, true)
+
+	m.prometheus.savingsPlanInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_savingsplan_info",
+			Help: "Azure SavingsPlan information",
+		},
+		append(
+			append([]string{}, savingsPlanLabels...),
+			"displayName",
+			"skuName",
+			"appliedScopeType",
+			"billingScopeID",
+			"provisioningState",
+			"term",
+		),
+	)
+	m.Collector.RegisterMetricList("savingsPlanInfo", m.prometheus.savingsPlanInfo, true)
+
+	m.prometheus.savingsPlanCommitment = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_savingsplan_commitment",
+			Help: "Azure SavingsPlan commitment amount per grain (eg. Hourly)",
+		},
+		append(append([]string{}, savingsPlanLabels...), "currency", "grain"),
+	)
+	m.Collector.RegisterMetricList("savingsPlanCommitment", m.prometheus.savingsPlanCommitment, true)
+
+	m.prometheus.savingsPlanUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_savingsplan_utilization_percentage",
+			Help: "Azure SavingsPlan utilization in percentage by grain (eg. 7days)",
+		},
+		append(append([]string{}, savingsPlanLabels...), "grain"),
+	)
+	m.Collector.RegisterMetricList("savingsPlanUtilization", m.prometheus.savingsPlanUtilization, true)
+
+	m.prometheus.savingsPlanExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_savingsplan_expiry_timestamp_seconds",
+			Help: "Azure SavingsPlan expiry timestamp",
+		},
+		savingsPlanLabels,
+	)
+	m.Collector.RegisterMetricList("savingsPlanExpiry", m.prometheus.savingsPlanExpiry, true)
+
+	m.prometheus.savingsPlanRenew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_savingsplan_renew",
+			Help: "Azure SavingsPlan auto renew state",
+		},
+		savingsPlanLabels,
+	)
+	m.Collector.RegisterMetricList("savingsPlanRenew", m.prometheus.savingsPlanRenew, true)
+}
+
+func (m *MetricsCollectorAzureRmReservation) Reset() {}
+
+func (m *MetricsCollectorAzureRmReservation) Collect(callback chan<- func()) {
+	// reservations and savings plans are not related to subscriptions
+	status := newCollectorSubscriptionStatus(m.Collector, "", m.Logger())
+	defer status.Finish()
+
+	for _, tenant := range m.AzureTenants() {
+		status.Check("capacity.reservations", m.collectReservations(tenant))
+		status.Check("billingbenefits.savingsplans", m.collectSavingsPlans(tenant))
+	}
+}
+
+func (m *MetricsCollectorAzureRmReservation) collectReservations(tenant *AzureTenant) error {
+	summariesClient, err := armconsumption.NewReservationsSummariesClient(tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+	if err != nil {
+		return err
+	}
+
+	infoMetric := m.Collector.GetMetricList("reservationInfo")
+	quantityMetric := m.Collector.GetMetricList("reservationQuantity")
+	hoursMetric := m.Collector.GetMetricList("reservationHours")
+	utilizationMetric := m.Collector.GetMetricList("reservationUtilization")
+	expiryMetric := m.Collector.GetMetricList("reservationExpiry")
+	renewMetric := m.Collector.GetMetricList("reservationRenew")
+
+	reservationOrders := map[string]bool{}
+
+	err = armListAll(m.Context(), tenant, "/providers/Microsoft.Capacity/reservations", reservationApiVersion, func(value json.RawMessage) error {
+		reservation := azureReservation{}
+		if err := json.Unmarshal(value, &reservation); err != nil {
+			return err
+		}
+
+		labels := prometheus.Labels{
+			"tenantID":           tenant.TenantID,
+			"reservationOrderID": armResourceIDSegment(reservation.ID, "reservationOrders"),
+			"reservationID":      armResourceIDSegment(reservation.ID, "reservations"),
+		}
+		reservationOrders[labels["reservationOrderID"]] = true
+
+		infoLabels := copyPrometheusLabels(labels)
+		infoLabels["displayName"] = reservation.Properties.DisplayName
+		infoLabels["skuName"] = reservation.Sku.Name
+		infoLabels["location"] = stringToStringLower(reservation.Location)
+		infoLabels["resourceType"] = reservation.Properties.ReservedResourceType
+		infoLabels["appliedScopeType"] = reservation.Properties.AppliedScopeType
+		infoLabels["provisioningState"] = reservation.Properties.ProvisioningState
+		infoLabels["term"] = reservation.Properties.Term
+		infoMetric.AddInfo(infoLabels)
+
+		if reservation.Properties.Quantity != nil {
+			quantityLabels := copyPrometheusLabels(labels)
+			quantityLabels["type"] = "purchased"
+			quantityMetric.Add(quantityLabels, *reservation.Properties.Quantity)
+		}
+
+		addBenefitUtilizationMetrics(utilizationMetric, labels, reservation.Properties.Utilization)
+
+		expiryDate := reservation.Properties.ExpiryDateTime
+		if expiryDate == "" {
+			expiryDate = reservation.Properties.ExpiryDate
+		}
+		if expiry, ok := parseBenefitExpiry(expiryDate); ok {
+			expiryMetric.AddTime(labels, expiry)
+		}
+
+		if reservation.Properties.Renew != nil {
+			renewMetric.AddBool(labels, *reservation.Properties.Renew)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
+	}
+
+	// reserved and used quantity/hours of the current month (reservation summaries are only available per order)
+	for reservationOrderID := range reservationOrders {
+		summary, err := m.fetchReservationOrderSummary(summariesClient, reservationOrderID)
+		if err != nil {
+			// summaries are only details of the reservations, continue with the other orders
+			logger := m.Logger().WithFields(log.Fields{
+				"tenantID":           tenant.TenantID,
+				"reservationOrderID": reservationOrderID,
+			})
+			collectorWarningHandle(logger, m.Collector, "consumption.reservationsummaries", err)
+			continue
+		}
+
+		for reservationID, properties := range summary {
+			labels := prometheus.Labels{
+				"tenantID":           tenant.TenantID,
+				"reservationOrderID": reservationOrderID,
+				"reservationID":      reservationID,
+			}
+
+			if properties.AvgUtilizationPercentage != nil {
+				utilizationLabels := copyPrometheusLabels(labels)
+				utilizationLabels["grain"] = "month"
+				utilizationMetric.Add(utilizationLabels, *properties.AvgUtilizationPercentage)
+			}
+
+			for quantityType, value := range map[string]*float64{
+				"reserved": properties.TotalReservedQuantity,
+				"used":     properties.UsedQuantity,
+			} {
+				if value != nil {
+					quantityLabels := copyPrometheusLabels(labels)
+					quantityLabels["type"] = quantityType
+					quantityMetric.Add(quantityLabels, *value)
+				}
+			}
+
+			for hoursType, value := range map[string]*float64{
+				"reserved": properties.ReservedHours,
+				"used":     properties.UsedHours,
+			} {
+				if value != nil {
+					hoursLabels := copyPrometheusLabels(labels)
+					hoursLabels["type"] = hoursType
+					hoursMetric.Add(hoursLabels, *value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchReservationOrderSummary returns the latest monthly summary of each reservation of the reservation order
+func (m *MetricsCollectorAzureRmReservation) fetchReservationOrderSummary(client *armconsumption.ReservationsSummariesClient, reservationOrderID string) (map[string]*armconsumption.ReservationSummaryProperties, error) {
+	ret := map[string]*armconsumption.ReservationSummaryProperties{}
+
+	pager := client.NewListByReservationOrderPager(reservationOrderID, armconsumption.DatagrainMonthlyGrain, nil)
+	for pager.More() {
+		result, err := pager.NextPage(m.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range result.Value {
+			if row == nil || row.Properties == nil || row.Properties.ReservationID == nil {
+				continue
+			}
+
+			reservationID := to.StringLower(row.Properties.ReservationID)
+			if current, exists := ret[reservationID]; exists && current.UsageDate != nil {
+				if row.Properties.UsageDate == nil || !row.Properties.UsageDate.After(*current.UsageDate) {
+					continue
+				}
+			}
+			ret[reservationID] = row.Properties
+		}
+	}
+
+	return ret, nil
+}
+
+func (m *MetricsCollectorAzureRmReservation) collectSavingsPlans(tenant *AzureTenant) error {
+	infoMetric := m.Collector.GetMetricList("savingsPlanInfo")
+	commitmentMetric := m.Collector.GetMetricList("savingsPlanCommitment")
+	utilizationMetric := m.Collector.GetMetricList("savingsPlanUtilization")
+	expiryMetric := m.Collector.GetMetricList("savingsPlanExpiry")
+	renewMetric := m.Collector.GetMetricList("savingsPlanRenew")
+
+	err := armListAll(m.Context(), tenant, "/providers/Microsoft.BillingBenefits/savingsPlans", savingsPlanApiVersion, func(value json.RawMessage) error {
+		savingsPlan := azureSavingsPlan{}
+		if err := json.Unmarshal(value, &savingsPlan); err != nil {
+			return err
+		}
+
+		labels := prometheus.Labels{
+			"tenantID":           tenant.TenantID,
+			"savingsPlanOrderID": armResourceIDSegment(savingsPlan.ID, "savingsPlanOrders"),
+			"savingsPlanID":      armResourceIDSegment(savingsPlan.ID, "savingsPlans"),
+		}
+
+		infoLabels := copyPrometheusLabels(labels)
+		infoLabels["displayName"] = savingsPlan.Properties.DisplayName
+		infoLabels["skuName"] = savingsPlan.Sku.Name
+		infoLabels["appliedScopeType"] = savingsPlan.Properties.AppliedScopeType
+		infoLabels["billingScopeID"] = stringToStringLower(savingsPlan.Properties.BillingScopeID)
+		infoLabels["provisioningState"] = savingsPlan.Properties.ProvisioningState
+		infoLabels["term"] = savingsPlan.Properties.Term
+		infoMetric.AddInfo(infoLabels)
+
+		if commitment := savingsPlan.Properties.Commitment; commitment != nil && commitment.Amount != nil {
+			commitmentLabels := copyPrometheusLabels(labels)
+			commitmentLabels["currency"] = commitment.CurrencyCode
+			commitmentLabels["grain"] = commitment.Grain
+			commitmentMetric.Add(commitmentLabels, *commitment.Amount)
+		}
+
+		addBenefitUtilizationMetrics(utilizationMetric, labels, savingsPlan.Properties.Utilization)
+
+		if expiry, ok := parseBenefitExpiry(savingsPlan.Properties.ExpiryDateTime); ok {
+			expiryMetric.AddTime(labels, expiry)
+		}
+
+		if savingsPlan.Properties.Renew != nil {
+			renewMetric.AddBool(labels, *savingsPlan.Properties.Renew)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
+	}
+
+	return nil
+}
+
+// addBenefitUtilizationMetrics adds the utilization aggregates (percentage) with grain label (eg. "7days")
+func addBenefitUtilizationMetrics(metricList *CollectorMetricList, labels prometheus.Labels, utilization *azureBenefitUtilization) {
+	if utilization == nil {
+		return
+	}
+
+	for _, aggregate := range utilization.Aggregates {
+		if aggregate.Grain == nil || aggregate.Value == nil || !strings.EqualFold(aggregate.ValueUnit, "percentage") {
+			continue
+		}
+
+		utilizationLabels := copyPrometheusLabels(labels)
+		utilizationLabels["grain"] = fmt.Sprintf("%v%v", *aggregate.Grain, stringToStringLower(aggregate.GrainUnit))
+		metricList.Add(utilizationLabels, *aggregate.Value)
+	}
+}
+
+// parseBenefitExpiry parses the expiry of reservations and savings plans (date or datetime)
+func parseBenefitExpiry(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if expiry, err := time.Parse(layout, value); err == nil {
+			return expiry.UTC(), true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -6,12 +6,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorAzureRmResources struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		resource      *prometheus.GaugeVec
@@ -19,8 +18,8 @@ type MetricsCollectorAzureRmResources struct {
 	}
 }
 
-func (m *MetricsCollectorAzureRmResources) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmResources) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.resource = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -31,6 +30,7 @@ func (m *MetricsCollectorAzureRmResources) Setup(collector *collector.Collector)
 			[]string{
 				"resourceID",
 				"resourceName",
+				"tenantID",
 				"subscriptionID",
 				"resourceGroup",
 				"resourceType",
@@ -38,7 +38,7 @@ func (m *MetricsCollectorAzureRmResources) Setup(collector *collector.Collector)
 				"location",
 				"provisioningState",
 			},
-			opts.Azure.ResourceTags,
+			m.Opts().Azure.ResourceTags,
 		),
 	)
 	m.Collector.RegisterMetricList("resource", m.prometheus.resource, true)
@@ -51,12 +51,13 @@ func (m *MetricsCollectorAzureRmResources) Setup(collector *collector.Collector)
 		armclient.AddResourceTagsToPrometheusLabelsDefinition(
 			[]string{
 				"resourceID",
+				"tenantID",
 				"subscriptionID",
 				"resourceGroup",
 				"location",
 				"provisioningState",
 			},
-			opts.Azure.ResourceGroupTags,
+			m.Opts().Azure.ResourceGroupTags,
 		),
 	)
 	m.Collector.RegisterMetricList("resourceGroup", m.prometheus.resourceGroup, true)
@@ -65,21 +66,22 @@ func (m *MetricsCollectorAzureRmResources) Setup(collector *collector.Collector)
 func (m *MetricsCollectorAzureRmResources) Reset() {}
 
 func (m *MetricsCollectorAzureRmResources) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectAzureResourceGroup(subscription, logger, callback)
-		m.collectAzureResources(subscription, logger, callback)
-
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		status.Check("resourcegroups", m.collectAzureResourceGroup(tenant, subscription, logger, callback))
+		status.Check("resources", m.collectAzureResources(tenant, subscription, logger, callback))
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
 }
 
 // Collect Azure ResourceGroup metrics
-func (m *MetricsCollectorAzureRmResources) collectAzureResourceGroup(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armresources.NewResourceGroupsClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmResources) collectAzureResourceGroup(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
+	client, err := armresources.NewResourceGroupsClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	infoMetric := m.Collector.GetMetricList("resourceGroup")
@@ -89,7 +91,7 @@ func (m *MetricsCollectorAzureRmResources) collectAzureResourceGroup(subscriptio
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -102,21 +104,24 @@ func (m *MetricsCollectorAzureRmResources) collectAzureResourceGroup(subscriptio
 
 			infoLabels := prometheus.Labels{
 				"resourceID":        to.StringLower(resourceGroup.ID),
+				"tenantID":          tenant.SubscriptionTenantID(subscription),
 				"subscriptionID":    azureResource.Subscription,
 				"resourceGroup":     azureResource.ResourceGroup,
 				"location":          to.StringLower(resourceGroup.Location),
 				"provisioningState": to.StringLower(resourceGroup.Properties.ProvisioningState),
 			}
-			infoLabels = armclient.AddResourceTagsToPrometheusLabels(infoLabels, resourceGroup.Tags, opts.Azure.ResourceGroupTags)
+			infoLabels = armclient.AddResourceTagsToPrometheusLabels(infoLabels, resourceGroup.Tags, m.Opts().Azure.ResourceGroupTags)
 			infoMetric.AddInfo(infoLabels)
 		}
 	}
+
+	return nil
 }
 
-func (m *MetricsCollectorAzureRmResources) collectAzureResources(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armresources.NewClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmResources) collectAzureResources(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
+	client, err := armresources.NewClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	resourceMetric := m.Collector.GetMetricList("resource")
@@ -126,7 +131,7 @@ func (m *MetricsCollectorAzureRmResources) collectAzureResources(subscription *a
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -138,6 +143,7 @@ func (m *MetricsCollectorAzureRmResources) collectAzureResources(subscription *a
 			azureResource, _ := armclient.ParseResourceId(resourceId)
 
 			infoLabels := prometheus.Labels{
+				"tenantID":          tenant.SubscriptionTenantID(subscription),
 				"subscriptionID":    azureResource.Subscription,
 				"resourceID":        stringToStringLower(resourceId),
 				"resourceName":      azureResource.ResourceName,
@@ -147,8 +153,10 @@ func (m *MetricsCollectorAzureRmResources) collectAzureResources(subscription *a
 				"location":          to.StringLower(resource.Location),
 				"provisioningState": to.StringLower(resource.ProvisioningState),
 			}
-			infoLabels = armclient.AddResourceTagsToPrometheusLabels(infoLabels, resource.Tags, opts.Azure.ResourceTags)
+			infoLabels = armclient.AddResourceTagsToPrometheusLabels(infoLabels, resource.Tags, m.Opts().Azure.ResourceTags)
 			resourceMetric.AddInfo(infoLabels)
 		}
 	}
+
+	return nil
 }
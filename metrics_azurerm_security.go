@@ -7,12 +7,11 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/security/armsecurity"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorAzureRmSecurity struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		securitycenterCompliance *prometheus.GaugeVec
@@ -20,8 +19,8 @@ type MetricsCollectorAzureRmSecurity struct {
 	}
 }
 
-func (m *MetricsCollectorAzureRmSecurity) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorAzureRmSecurity) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.securitycenterCompliance = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -29,6 +28,7 @@ func (m *MetricsCollectorAzureRmSecurity) Setup(collector *collector.Collector)
 			Help: "Azure Audit SecurityCenter compliance status",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"assessmentType",
 		},
@@ -41,6 +41,7 @@ func (m *MetricsCollectorAzureRmSecurity) Setup(collector *collector.Collector)
 			Help: "Azure Audit Advisor recommendation",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"category",
 			"resourceType",
@@ -57,19 +58,21 @@ func (m *MetricsCollectorAzureRmSecurity) Setup(collector *collector.Collector)
 func (m *MetricsCollectorAzureRmSecurity) Reset() {}
 
 func (m *MetricsCollectorAzureRmSecurity) Collect(callback chan<- func()) {
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *log.Entry) {
-		m.collectAzureSecurityCompliance(subscription, logger, callback)
+	err := m.SubscriptionsIterator().ForEachAsync(m.Logger(), func(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry) {
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), logger)
+		status.Check("security.compliances", m.collectAzureSecurityCompliance(tenant, subscription, logger, callback))
 		// m.collectAzureAdvisorRecommendations(subscription, logger, callback)
+		status.Finish()
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
 	}
 }
 
-func (m *MetricsCollectorAzureRmSecurity) collectAzureSecurityCompliance(subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) {
-	client, err := armsecurity.NewCompliancesClient(AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+func (m *MetricsCollectorAzureRmSecurity) collectAzureSecurityCompliance(tenant *AzureTenant, subscription *armsubscriptions.Subscription, logger *log.Entry, callback chan<- func()) error {
+	client, err := armsecurity.NewCompliancesClient(tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
 	if err != nil {
-		logger.Panic(err)
+		return err
 	}
 
 	infoMetric := m.Collector.GetMetricList("securitycenterCompliance")
@@ -81,7 +84,7 @@ func (m *MetricsCollectorAzureRmSecurity) collectAzureSecurityCompliance(subscri
 	for pager.More() {
 		result, err := pager.NextPage(m.Context())
 		if err != nil {
-			logger.Panic(err)
+			return err
 		}
 
 		if result.Value == nil {
@@ -100,13 +103,13 @@ func (m *MetricsCollectorAzureRmSecurity) collectAzureSecurityCompliance(subscri
 	if lastReportName != "" {
 		report, err := client.Get(m.Context(), *subscription.ID, lastReportName, nil)
 		if err != nil {
-			logger.Error(err)
-			return
+			return err
 		}
 
 		if report.Properties.AssessmentResult != nil {
 			for _, result := range report.Properties.AssessmentResult {
 				infoLabels := prometheus.Labels{
+					"tenantID":       tenant.SubscriptionTenantID(subscription),
 					"subscriptionID": to.StringLower(subscription.SubscriptionID),
 					"assessmentType": to.StringLower(result.SegmentType),
 				}
@@ -114,6 +117,8 @@ func (m *MetricsCollectorAzureRmSecurity) collectAzureSecurityCompliance(subscri
 			}
 		}
 	}
+
+	return nil
 }
 
 //
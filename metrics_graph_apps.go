@@ -1,18 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 
 	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
 	"github.com/microsoftgraph/msgraph-sdk-go/applications"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorGraphApps struct {
-	collector.Processor
+	CollectorProcessor
 
 	prometheus struct {
 		apps            *prometheus.GaugeVec
@@ -20,8 +20,8 @@ type MetricsCollectorGraphApps struct {
 	}
 }
 
-func (m *MetricsCollectorGraphApps) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorGraphApps) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.prometheus.apps = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -29,6 +29,7 @@ func (m *MetricsCollectorGraphApps) Setup(collector *collector.Collector) {
 			Help: "Azure GraphQL applications information",
 		},
 		[]string{
+			"tenantID",
 			"appAppID",
 			"appObjectID",
 			"appDisplayName",
@@ -42,6 +43,7 @@ func (m *MetricsCollectorGraphApps) Setup(collector *collector.Collector) {
 			Help: "Azure GraphQL application credentials status",
 		},
 		[]string{
+			"tenantID",
 			"appAppID",
 			"credentialName",
 			"credentialID",
@@ -55,24 +57,39 @@ func (m *MetricsCollectorGraphApps) Setup(collector *collector.Collector) {
 func (m *MetricsCollectorGraphApps) Reset() {}
 
 func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
+	// graph applications are not related to subscriptions
+	status := newCollectorSubscriptionStatus(m.Collector, "", m.Logger())
+	defer status.Finish()
+
+	for _, tenant := range m.AzureTenants() {
+		status.Check("graph.applications", m.collectTenant(tenant))
+	}
+}
+
+func (m *MetricsCollectorGraphApps) collectTenant(tenant *AzureTenant) error {
+	msGraphClient, err := tenant.MsGraphClient()
+	if err != nil {
+		return err
+	}
+
 	opts := applications.ApplicationsRequestBuilderGetRequestConfiguration{
 		Headers: nil,
 		Options: nil,
 		QueryParameters: &applications.ApplicationsRequestBuilderGetQueryParameters{
-			Filter: &opts.Graph.ApplicationFilter,
+			Filter: &m.Opts().Graph.ApplicationFilter,
 		},
 	}
-	result, err := MsGraphClient.ServiceClient().Applications().Get(m.Context(), &opts)
+	result, err := msGraphClient.ServiceClient().Applications().Get(m.Context(), &opts)
 	if err != nil {
-		m.Logger().Panic(err)
+		return fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
 	}
 
 	appsMetrics := m.Collector.GetMetricList("apps")
 	appsCredentialMetrics := m.Collector.GetMetricList("appsCredentials")
 
-	pageIterator, err := msgraphcore.NewPageIterator(result, MsGraphClient.RequestAdapter(), models.CreateApplicationCollectionResponseFromDiscriminatorValue)
+	pageIterator, err := msgraphcore.NewPageIterator(result, msGraphClient.RequestAdapter(), models.CreateApplicationCollectionResponseFromDiscriminatorValue)
 	if err != nil {
-		m.Logger().Panic(err)
+		return fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
 	}
 
 	err = pageIterator.Iterate(m.Context(), func(pageItem interface{}) bool {
@@ -82,6 +99,7 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 		objId := to.StringLower(application.GetId())
 
 		appsMetrics.AddInfo(prometheus.Labels{
+			"tenantID":       tenant.TenantID,
 			"appAppID":       appId,
 			"appObjectID":    objId,
 			"appDisplayName": to.String(application.GetDisplayName()),
@@ -91,6 +109,7 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 			credential.GetDisplayName()
 			if credential.GetStartDateTime() != nil {
 				appsCredentialMetrics.AddTime(prometheus.Labels{
+					"tenantID":       tenant.TenantID,
 					"appAppID":       appId,
 					"credentialName": to.String(credential.GetDisplayName()),
 					"credentialID":   strings.ToLower(credential.GetKeyId().String()),
@@ -101,6 +120,7 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 
 			if credential.GetEndDateTime() != nil {
 				appsCredentialMetrics.AddTime(prometheus.Labels{
+					"tenantID":       tenant.TenantID,
 					"appAppID":       appId,
 					"credentialName": to.String(credential.GetDisplayName()),
 					"credentialID":   strings.ToLower(credential.GetKeyId().String()),
@@ -114,6 +134,7 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 			credential.GetDisplayName()
 			if credential.GetStartDateTime() != nil {
 				appsCredentialMetrics.AddTime(prometheus.Labels{
+					"tenantID":       tenant.TenantID,
 					"appAppID":       appId,
 					"credentialName": to.String(credential.GetDisplayName()),
 					"credentialID":   strings.ToLower(credential.GetKeyId().String()),
@@ -124,6 +145,7 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 
 			if credential.GetEndDateTime() != nil {
 				appsCredentialMetrics.AddTime(prometheus.Labels{
+					"tenantID":       tenant.TenantID,
 					"appAppID":       appId,
 					"credentialName": to.String(credential.GetDisplayName()),
 					"credentialID":   strings.ToLower(credential.GetKeyId().String()),
@@ -136,6 +158,8 @@ func (m *MetricsCollectorGraphApps) Collect(callback chan<- func()) {
 		return true
 	})
 	if err != nil {
-		m.Logger().Panic(err)
+		return fmt.Errorf(`tenant "%v": %w`, tenant.TenantID, err)
 	}
+
+	return nil
 }
@@ -7,12 +7,11 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
 )
 
 type MetricsCollectorPortscanner struct {
-	collector.Processor
+	CollectorProcessor
 
 	portscanner *Portscanner
 
@@ -24,13 +23,13 @@ type MetricsCollectorPortscanner struct {
 	}
 }
 
-func (m *MetricsCollectorPortscanner) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+func (m *MetricsCollectorPortscanner) Setup(collector *Collector) {
+	m.CollectorProcessor.Setup(collector)
 
 	m.portscanner = &Portscanner{}
 	m.portscanner.Init()
 
-	cachePath := opts.GetCachePath("portscan.json")
+	cachePath := m.Opts().GetCachePath("portscan.json")
 
 	m.prometheus.publicIpInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -38,6 +37,7 @@ func (m *MetricsCollectorPortscanner) Setup(collector *collector.Collector) {
 			Help: "Azure ResourceManager public ip resource information",
 		},
 		[]string{
+			"tenantID",
 			"subscriptionID",
 			"resourceID",
 			"resourceGroup",
@@ -87,10 +87,10 @@ func (m *MetricsCollectorPortscanner) Setup(collector *collector.Collector) {
 		m.Logger().Infof(
 			"starting for %v IPs (parallel:%v, threads per run:%v, timeout:%vs, portranges:%v)",
 			len(c.PublicIps),
-			opts.Portscan.Parallel,
-			opts.Portscan.Threads,
-			opts.Portscan.Timeout,
-			portscanPortRange,
+			m.Opts().Portscan.Parallel,
+			m.Opts().Portscan.Threads,
+			m.Opts().Portscan.Timeout,
+			m.RunConfig().PortscanPortRange,
 		)
 
 		m.prometheus.publicIpPortscanStatus.Reset()
@@ -150,49 +150,36 @@ func (m *MetricsCollectorPortscanner) Reset() {
 }
 
 func (m *MetricsCollectorPortscanner) Collect(callback chan<- func()) {
-	subscriptionList, err := AzureSubscriptionsIterator.ListSubscriptions()
+	subscriptionList, err := m.SubscriptionsIterator().ListSubscriptions()
 	if err != nil {
-		m.Logger().Panic(err)
+		collectorErrorHandle(m.Logger(), m.Collector, "", "subscriptions", err)
+		return
 	}
 
 	publicIpList := m.fetchPublicIpAdresses(subscriptionList)
 	m.portscanner.SetAzurePublicIpList(publicIpList)
 
 	if len(publicIpList) > 0 {
-		m.portscanner.Start()
+		m.portscanner.Start(m.RunConfig())
 	}
 }
 
-func (m *MetricsCollectorPortscanner) fetchPublicIpAdresses(subscriptions map[string]*armsubscriptions.Subscription) (pipList []*armnetwork.PublicIPAddress) {
+func (m *MetricsCollectorPortscanner) fetchPublicIpAdresses(subscriptions []AzureTenantSubscription) (pipList []*armnetwork.PublicIPAddress) {
 	m.Logger().Info("collecting public ips")
 
-	for _, val := range subscriptions {
-		subscription := val
+	subscriptionTenantIDs := map[string]string{}
+	for _, row := range subscriptions {
+		tenant := row.Tenant
+		subscription := row.Subscription
 		contextLogger := m.Logger().WithField("azureSubscription", subscription)
+		subscriptionTenantIDs[to.StringLower(subscription.SubscriptionID)] = tenant.SubscriptionTenantID(subscription)
 
-		client, err := armnetwork.NewPublicIPAddressesClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
-		if err != nil {
-			contextLogger.Panic(err)
-		}
-
-		pager := client.NewListAllPager(nil)
-
-		for pager.More() {
-			result, err := pager.NextPage(m.Context())
-			if err != nil {
-				contextLogger.Panic(err)
-			}
-
-			if result.Value == nil {
-				continue
-			}
-
-			for _, publicIp := range result.Value {
-				if publicIp.Properties.IPAddress != nil {
-					pipList = append(pipList, publicIp)
-				}
-			}
+		status := newCollectorSubscriptionStatus(m.Collector, to.StringLower(subscription.SubscriptionID), contextLogger)
+		subscriptionPipList, err := m.fetchSubscriptionPublicIpAdresses(tenant, subscription)
+		if status.Check("network.publicipaddresses", err) {
+			pipList = append(pipList, subscriptionPipList...)
 		}
+		status.Finish()
 	}
 
 	m.prometheus.publicIpInfo.Reset()
@@ -201,6 +188,7 @@ func (m *MetricsCollectorPortscanner) fetchPublicIpAdresses(subscriptions map[st
 		azureResource, _ := armclient.ParseResourceId(resourceId)
 
 		m.prometheus.publicIpInfo.With(prometheus.Labels{
+			"tenantID":         subscriptionTenantIDs[azureResource.Subscription],
 			"subscriptionID":   azureResource.Subscription,
 			"resourceID":       to.StringLower(pip.ID),
 			"resourceGroup":    azureResource.ResourceGroup,
@@ -212,3 +200,31 @@ func (m *MetricsCollectorPortscanner) fetchPublicIpAdresses(subscriptions map[st
 
 	return pipList
 }
+
+func (m *MetricsCollectorPortscanner) fetchSubscriptionPublicIpAdresses(tenant *AzureTenant, subscription *armsubscriptions.Subscription) (pipList []*armnetwork.PublicIPAddress, err error) {
+	client, err := armnetwork.NewPublicIPAddressesClient(*subscription.SubscriptionID, tenant.Client.GetCred(), tenant.Client.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	pager := client.NewListAllPager(nil)
+
+	for pager.More() {
+		result, err := pager.NextPage(m.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Value == nil {
+			continue
+		}
+
+		for _, publicIp := range result.Value {
+			if publicIp.Properties.IPAddress != nil {
+				pipList = append(pipList, publicIp)
+			}
+		}
+	}
+
+	return pipList, nil
+}
@@ -2,8 +2,16 @@ package main
 
 import (
 	"regexp"
+	"sort"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// dayFormat is the format of the days of daily histories (costs anomalies and quota history)
+	dayFormat = "2006-01-02"
 )
 
 var (
@@ -40,3 +48,52 @@ func truncateStrings(s string, n int, suffix string) string {
 	}
 	return s[:n] + suffix
 }
+
+func stringInSliceCI(val string, list []string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// armResourceIDSegment returns the (lowercase) value after the segment of the resource id
+// (eg. "reservationOrders" for /providers/microsoft.capacity/reservationOrders/{id})
+func armResourceIDSegment(resourceId, segment string) string {
+	parts := strings.Split(strings.Trim(resourceId, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], segment) {
+			return strings.ToLower(parts[i+1])
+		}
+	}
+
+	return ""
+}
+
+// labelsKey builds a key from the labels (only the labels of names if set)
+func labelsKey(labels map[string]string, names []string) string {
+	if names == nil {
+		for name := range labels {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	parts := []string{}
+	for _, name := range names {
+		parts = append(parts, name+"="+labels[name])
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func copyPrometheusLabels(labels prometheus.Labels) prometheus.Labels {
+	ret := prometheus.Labels{}
+	for name, value := range labels {
+		ret[name] = value
+	}
+
+	return ret
+}
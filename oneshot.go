@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	oneshotMetricFamily struct {
+		Name    string          `json:"name"`
+		Help    string          `json:"help"`
+		Type    string          `json:"type"`
+		Metrics []oneshotMetric `json:"metrics"`
+	}
+
+	oneshotMetric struct {
+		Labels      map[string]string  `json:"labels"`
+		Value       *float64           `json:"value,omitempty"`
+		SampleCount *uint64            `json:"sampleCount,omitempty"`
+		SampleSum   *float64           `json:"sampleSum,omitempty"`
+		Buckets     map[string]uint64  `json:"buckets,omitempty"`
+		Quantiles   map[string]float64 `json:"quantiles,omitempty"`
+	}
+)
+
+// runOneshot runs the collectors once and writes the metrics, returns the exit code
+func runOneshot() int {
+	opts := currentOpts()
+	runErrors, err := collectorManager.RunOnce(currentConfig(), opts.Oneshot.Collectors)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	metricFamilies, err := collectorManager.GatherCollectors()
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	if err := oneshotWriteOutput(opts.Oneshot.Output, opts.Oneshot.Format, metricFamilies); err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	if len(runErrors) > 0 {
+		collectorNameList := []string{}
+		for collectorName := range runErrors {
+			collectorNameList = append(collectorNameList, collectorName)
+		}
+		sort.Strings(collectorNameList)
+
+		for _, collectorName := range collectorNameList {
+			log.WithField("collector", collectorName).Errorf("collector run failed: %v", runErrors[collectorName])
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func oneshotWriteOutput(path, format string, metricFamilies []*dto.MetricFamily) (err error) {
+	var writer io.Writer = os.Stdout
+	if path != "" && path != "-" {
+		file, err := os.Create(path) // #nosec G304 path is set by the user
+		if err != nil {
+			return fmt.Errorf(`unable to create output file "%v": %w`, path, err)
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		writer = file
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(oneshotConvertMetricFamilies(metricFamilies))
+	case "openmetrics":
+		return oneshotEncodeMetricFamilies(writer, expfmt.FmtOpenMetrics, metricFamilies)
+	default:
+		return oneshotEncodeMetricFamilies(writer, expfmt.FmtText, metricFamilies)
+	}
+}
+
+func oneshotEncodeMetricFamilies(writer io.Writer, format expfmt.Format, metricFamilies []*dto.MetricFamily) error {
+	encoder := expfmt.NewEncoder(writer, format)
+	for _, metricFamily := range metricFamilies {
+		if err := encoder.Encode(metricFamily); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func oneshotConvertMetricFamilies(metricFamilies []*dto.MetricFamily) []oneshotMetricFamily {
+	list := []oneshotMetricFamily{}
+
+	for _, metricFamily := range metricFamilies {
+		family := oneshotMetricFamily{
+			Name:    metricFamily.GetName(),
+			Help:    metricFamily.GetHelp(),
+			Type:    metricFamily.GetType().String(),
+			Metrics: []oneshotMetric{},
+		}
+
+		for _, row := range metricFamily.GetMetric() {
+			metric := oneshotMetric{
+				Labels: map[string]string{},
+			}
+
+			for _, label := range row.GetLabel() {
+				metric.Labels[label.GetName()] = label.GetValue()
+			}
+
+			switch {
+			case row.Gauge != nil:
+				metric.Value = row.Gauge.Value
+			case row.Counter != nil:
+				metric.Value = row.Counter.Value
+			case row.Untyped != nil:
+				metric.Value = row.Untyped.Value
+			case row.Summary != nil:
+				metric.SampleCount = row.Summary.SampleCount
+				metric.SampleSum = row.Summary.SampleSum
+				metric.Quantiles = map[string]float64{}
+				for _, quantile := range row.Summary.GetQuantile() {
+					metric.Quantiles[fmt.Sprintf("%v", quantile.GetQuantile())] = quantile.GetValue()
+				}
+			case row.Histogram != nil:
+				metric.SampleCount = row.Histogram.SampleCount
+				metric.SampleSum = row.Histogram.SampleSum
+				metric.Buckets = map[string]uint64{}
+				for _, bucket := range row.Histogram.GetBucket() {
+					metric.Buckets[fmt.Sprintf("%v", bucket.GetUpperBound())] = bucket.GetCumulativeCount()
+				}
+			}
+
+			family.Metrics = append(family.Metrics, metric)
+		}
+
+		list = append(list, family)
+	}
+
+	return list
+}
@@ -143,7 +143,9 @@ func (c *Portscanner) pushResults() {
 	}
 }
 
-func (c *Portscanner) Start() {
+// Start scans the public ips with the portscan settings and port ranges of the config
+func (c *Portscanner) Start(runtimeConfig *RuntimeConfig) {
+	opts := runtimeConfig.Opts
 	portscanTimeout := time.Duration(opts.Portscan.Timeout) * time.Second
 
 	c.Callbacks.StartupScan(c)
@@ -160,7 +162,7 @@ func (c *Portscanner) Start() {
 
 			c.Callbacks.StartScanIpAdress(c, pip)
 
-			results, elapsed := c.scanIp(pip, portscanTimeout)
+			results, elapsed := c.scanIp(pip, portscanTimeout, opts.Portscan.Threads, runtimeConfig.PortscanPortRange)
 
 			c.Callbacks.FinishScanIpAdress(c, pip, elapsed)
 
@@ -178,7 +180,7 @@ func (c *Portscanner) Start() {
 	c.Callbacks.FinishScan(c)
 }
 
-func (c *Portscanner) scanIp(pip armnetwork.PublicIPAddress, portscanTimeout time.Duration) (result []PortscannerResult, elapsed float64) {
+func (c *Portscanner) scanIp(pip armnetwork.PublicIPAddress, portscanTimeout time.Duration, threads int, portRanges []Portrange) (result []PortscannerResult, elapsed float64) {
 	ipAddress := to.String(pip.Properties.IPAddress)
 	startTime := time.Now().Unix()
 
@@ -189,9 +191,9 @@ func (c *Portscanner) scanIp(pip armnetwork.PublicIPAddress, portscanTimeout tim
 		return
 	}
 
-	ps := scanner.NewPortScanner(ipAddress, portscanTimeout, opts.Portscan.Threads)
+	ps := scanner.NewPortScanner(ipAddress, portscanTimeout, threads)
 
-	for _, portrange := range portscanPortRange {
+	for _, portrange := range portRanges {
 		openedPorts := ps.GetOpenedPort(portrange.FirstPort, portrange.LastPort)
 
 		for _, port := range openedPorts {
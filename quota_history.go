@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// quotaHistoryWindows are the windows (days) of the growth rates
+	quotaHistoryWindows = []int{7, 30}
+)
+
+type (
+	// QuotaHistory is the daily history of the current values of the quotas,
+	// used to forecast the exhaustion of quotas (persisted in --cache.path)
+	QuotaHistory struct {
+		lock sync.Mutex
+
+		Series map[string]*QuotaHistorySeries `json:"series"`
+	}
+
+	// QuotaHistorySeries is the history of a quota (subscription, location, scope and quota)
+	QuotaHistorySeries struct {
+		Labels map[string]string `json:"labels"`
+		Limit  *float64          `json:"limit"`
+
+		// Values contains the latest current value of each day
+		Values map[string]float64 `json:"values"`
+	}
+
+	// QuotaHistoryResult is the growth rate of a quota within a window and the estimated exhaustion based on it
+	QuotaHistoryResult struct {
+		Labels prometheus.Labels
+		Window int
+
+		// GrowthRate is the growth of the current value per day
+		GrowthRate float64
+
+		// Exhaustion is the estimated time until the limit is reached (nil if the quota isn't growing or has no limit)
+		Exhaustion *time.Duration
+	}
+)
+
+func newQuotaHistory() *QuotaHistory {
+	return &QuotaHistory{
+		Series: map[string]*QuotaHistorySeries{},
+	}
+}
+
+func (h *QuotaHistory) CacheLoad(path string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err := cacheRestoreFromPath(path, h); err != nil {
+		return err
+	}
+
+	if h.Series == nil {
+		h.Series = map[string]*QuotaHistorySeries{}
+	}
+
+	return nil
+}
+
+func (h *QuotaHistory) CacheSave(path string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return cacheSaveToPath(path, h)
+}
+
+// Add sets the current value of the day and the latest limit of the quota
+func (h *QuotaHistory) Add(now time.Time, labels prometheus.Labels, currentValue float64, limitValue *float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	seriesKey := labelsKey(labels, nil)
+	series, exists := h.Series[seriesKey]
+	if !exists {
+		series = &QuotaHistorySeries{
+			Labels: copyPrometheusLabels(labels),
+			Values: map[string]float64{},
+		}
+		h.Series[seriesKey] = series
+	}
+
+	series.Limit = limitValue
+	series.Values[now.Format(dayFormat)] = currentValue
+}
+
+// Evaluate calculates the growth rates (linear regression of the daily values) of the windows and the estimated
+// exhaustion of the quotas collected today. Days older than the largest window are removed from the history.
+func (h *QuotaHistory) Evaluate(now time.Time) (results []QuotaHistoryResult) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	today := now.Format(dayFormat)
+	cutoffDate := now.AddDate(0, 0, -quotaHistoryWindows[len(quotaHistoryWindows)-1]).Format(dayFormat)
+
+	for seriesKey, series := range h.Series {
+		for date := range series.Values {
+			if date < cutoffDate {
+				delete(series.Values, date)
+			}
+		}
+
+		if len(series.Values) == 0 {
+			delete(h.Series, seriesKey)
+			continue
+		}
+
+		// quota wasn't collected today (eg. removed location)
+		currentValue, exists := series.Values[today]
+		if !exists {
+			continue
+		}
+
+		for _, window := range quotaHistoryWindows {
+			growthRate, ok := series.growthRate(now, window)
+			if !ok {
+				continue
+			}
+
+			result := QuotaHistoryResult{
+				Labels:     copyPrometheusLabels(series.Labels),
+				Window:     window,
+				GrowthRate: growthRate,
+			}
+
+			if series.Limit != nil && *series.Limit > 0 {
+				switch {
+				case currentValue >= *series.Limit:
+					exhaustion := time.Duration(0)
+					result.Exhaustion = &exhaustion
+				case growthRate > 0:
+					exhaustion := time.Duration((*series.Limit - currentValue) / growthRate * float64(24*time.Hour))
+					result.Exhaustion = &exhaustion
+				}
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return
+}
+
+// growthRate returns the slope (per day) of the least squares line of the daily values within the window,
+// at least a quarter of the days (min two) are needed
+func (s *QuotaHistorySeries) growthRate(now time.Time, window int) (float64, bool) {
+	windowStartDate := now.AddDate(0, 0, -window).Format(dayFormat)
+
+	dates := []string{}
+	for date := range s.Values {
+		if date >= windowStartDate {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+
+	minSamples := window / 4
+	if minSamples < 2 {
+		minSamples = 2
+	}
+	if len(dates) < minSamples {
+		return 0, false
+	}
+
+	firstDay, err := time.Parse(dayFormat, dates[0])
+	if err != nil {
+		return 0, false
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, date := range dates {
+		day, err := time.Parse(dayFormat, date)
+		if err != nil {
+			return 0, false
+		}
+
+		// days since the first value
+		x := day.Sub(firstDay).Hours() / 24
+		y := s.Values[date]
+
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	count := float64(len(dates))
+	denominator := count*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+
+	return (count*sumXY - sumX*sumY) / denominator, true
+}
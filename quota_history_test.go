@@ -0,0 +1,236 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestQuotaHistorySeriesGrowthRate(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		values map[int]float64
+		window int
+		rate   float64
+		ok     bool
+	}{
+		{
+			name:   "linear growth",
+			values: map[int]float64{6: 0, 5: 2, 4: 4, 3: 6, 2: 8, 1: 10, 0: 12},
+			window: 7,
+			rate:   2,
+			ok:     true,
+		},
+		{
+			name:   "constant values",
+			values: map[int]float64{3: 5, 2: 5, 1: 5, 0: 5},
+			window: 7,
+			rate:   0,
+			ok:     true,
+		},
+		{
+			name:   "decreasing values",
+			values: map[int]float64{2: 30, 1: 20, 0: 10},
+			window: 7,
+			rate:   -10,
+			ok:     true,
+		},
+		{
+			name:   "days with gaps",
+			values: map[int]float64{6: 0, 3: 6, 0: 12},
+			window: 7,
+			rate:   2,
+			ok:     true,
+		},
+		{
+			name:   "least squares line",
+			values: map[int]float64{3: 1, 2: 3, 1: 2, 0: 4},
+			window: 7,
+			rate:   0.8,
+			ok:     true,
+		},
+		{
+			name:   "values outside of the window are ignored",
+			values: map[int]float64{20: 1000, 1: 10, 0: 11},
+			window: 7,
+			rate:   1,
+			ok:     true,
+		},
+		{
+			name:   "single value",
+			values: map[int]float64{0: 10},
+			window: 7,
+			ok:     false,
+		},
+		{
+			name:   "not enough values for the window",
+			values: map[int]float64{5: 1, 4: 2, 3: 3, 2: 4, 1: 5, 0: 6},
+			window: 30,
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			series := &QuotaHistorySeries{Values: map[string]float64{}}
+			for daysAgo, value := range test.values {
+				series.Values[now.AddDate(0, 0, -daysAgo).Format(dayFormat)] = value
+			}
+
+			rate, ok := series.growthRate(now, test.window)
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v (rate %v)", test.ok, ok, rate)
+			}
+
+			if ok && math.Abs(rate-test.rate) > 0.0001 {
+				t.Errorf("expected growth rate %v, got %v", test.rate, rate)
+			}
+		})
+	}
+}
+
+func TestQuotaHistoryEvaluate(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+	limit := func(value float64) *float64 {
+		return &value
+	}
+	exhaustion := func(duration time.Duration) *time.Duration {
+		return &duration
+	}
+
+	tests := []struct {
+		name       string
+		values     map[int]float64
+		limit      *float64
+		results    int
+		exhaustion *time.Duration
+	}{
+		{
+			name:       "growing quota",
+			values:     map[int]float64{2: 40, 1: 45, 0: 50},
+			limit:      limit(100),
+			results:    1,
+			exhaustion: exhaustion(10 * 24 * time.Hour),
+		},
+		{
+			name:       "exhausted quota",
+			values:     map[int]float64{2: 80, 1: 90, 0: 100},
+			limit:      limit(100),
+			results:    1,
+			exhaustion: exhaustion(0),
+		},
+		{
+			name:       "exceeded quota without growth",
+			values:     map[int]float64{2: 120, 1: 120, 0: 110},
+			limit:      limit(100),
+			results:    1,
+			exhaustion: exhaustion(0),
+		},
+		{
+			name:       "constant quota",
+			values:     map[int]float64{2: 50, 1: 50, 0: 50},
+			limit:      limit(100),
+			results:    1,
+			exhaustion: nil,
+		},
+		{
+			name:       "shrinking quota",
+			values:     map[int]float64{2: 60, 1: 55, 0: 50},
+			limit:      limit(100),
+			results:    1,
+			exhaustion: nil,
+		},
+		{
+			name:       "quota without limit",
+			values:     map[int]float64{2: 40, 1: 45, 0: 50},
+			limit:      nil,
+			results:    1,
+			exhaustion: nil,
+		},
+		{
+			name:       "quota with zero limit",
+			values:     map[int]float64{2: 40, 1: 45, 0: 50},
+			limit:      limit(0),
+			results:    1,
+			exhaustion: nil,
+		},
+		{
+			name:    "quota not collected today",
+			values:  map[int]float64{3: 40, 2: 45, 1: 50},
+			limit:   limit(100),
+			results: 0,
+		},
+		{
+			name:    "not enough values",
+			values:  map[int]float64{0: 50},
+			limit:   limit(100),
+			results: 0,
+		},
+	}
+
+	labels := prometheus.Labels{
+		"subscriptionID": "subscription",
+		"location":       "westeurope",
+		"scope":          "compute",
+		"quota":          "cores",
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := newQuotaHistory()
+			for daysAgo, value := range test.values {
+				history.Add(now.AddDate(0, 0, -daysAgo), labels, value, test.limit)
+			}
+
+			// only the 7 day window has enough values
+			results := history.Evaluate(now)
+			if len(results) != test.results {
+				t.Fatalf("expected %d results, got %+v", test.results, results)
+			}
+
+			if test.results == 0 {
+				return
+			}
+
+			result := results[0]
+			if result.Window != 7 || result.Labels["quota"] != "cores" {
+				t.Errorf("expected 7 day window of quota cores, got %+v", result)
+			}
+
+			switch {
+			case test.exhaustion == nil && result.Exhaustion != nil:
+				t.Errorf("expected no exhaustion, got %v", *result.Exhaustion)
+			case test.exhaustion != nil && result.Exhaustion == nil:
+				t.Errorf("expected exhaustion %v, got none", *test.exhaustion)
+			case test.exhaustion != nil && *result.Exhaustion != *test.exhaustion:
+				t.Errorf("expected exhaustion %v, got %v", *test.exhaustion, *result.Exhaustion)
+			}
+		})
+	}
+}
+
+func TestQuotaHistoryEvaluateCleanup(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	history := newQuotaHistory()
+	history.Add(now.AddDate(0, 0, -40), prometheus.Labels{"quota": "removed"}, 10, nil)
+	history.Add(now.AddDate(0, 0, -40), prometheus.Labels{"quota": "cores"}, 10, nil)
+	history.Add(now.AddDate(0, 0, -30), prometheus.Labels{"quota": "cores"}, 20, nil)
+	history.Add(now, prometheus.Labels{"quota": "cores"}, 30, nil)
+
+	history.Evaluate(now)
+
+	if len(history.Series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(history.Series))
+	}
+
+	for _, series := range history.Series {
+		if len(series.Values) != 2 {
+			t.Errorf("expected 2 values, got %v", series.Values)
+		}
+	}
+}
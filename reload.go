@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/webdevops/azure-resourcemanager-exporter/config"
+)
+
+var (
+	configReloadLock sync.Mutex
+
+	prometheusConfigReload struct {
+		successful       prometheus.Gauge
+		successTimestamp prometheus.Gauge
+	}
+)
+
+// initConfigReload handles config reloads triggered by SIGHUP or by changes of the config file
+// (the web config is read by the exporter-toolkit on each request and TLS handshake)
+func initConfigReload() {
+	prometheusConfigReload.successful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_exporter_config_last_reload_successful",
+			Help: "Azure ResourceManager exporter: whether the last config reload was successful",
+		},
+	)
+	prometheus.MustRegister(prometheusConfigReload.successful)
+
+	prometheusConfigReload.successTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Azure ResourceManager exporter: timestamp of the last successful config reload",
+		},
+	)
+	prometheus.MustRegister(prometheusConfigReload.successTimestamp)
+
+	prometheusConfigReload.successful.Set(1)
+	prometheusConfigReload.successTimestamp.SetToCurrentTime()
+
+	go func() {
+		signalChannel := make(chan os.Signal, 1)
+		signal.Notify(signalChannel, syscall.SIGHUP)
+		for range signalChannel {
+			log.Info("received SIGHUP, reloading config")
+			reloadConfig() // nolint:errcheck
+		}
+	}()
+
+	opts := currentOpts()
+	if opts.Config.Path != "" && opts.Config.WatchInterval.Seconds() > 0 {
+		log.Infof("watching config file %v for changes (interval %v)", opts.Config.Path, opts.Config.WatchInterval.String())
+		go watchConfigFile(opts.Config.Path)
+	}
+}
+
+// watchConfigFile reloads the config if the content of the config file changes
+func watchConfigFile(path string) {
+	lastChecksum, _ := configFileChecksum(path)
+
+	for {
+		interval := currentOpts().Config.WatchInterval
+
+		if interval.Seconds() <= 0 {
+			log.Infof("config file watch disabled")
+			return
+		}
+
+		time.Sleep(interval)
+
+		checksum, err := configFileChecksum(path)
+		if err != nil {
+			log.Warnf("unable to check config file for changes: %v", err)
+			continue
+		}
+
+		if checksum != lastChecksum {
+			lastChecksum = checksum
+			log.Infof("config file %v changed, reloading config", path)
+			reloadConfig() // nolint:errcheck
+		}
+	}
+}
+
+func configFileChecksum(path string) (string, error) {
+	content, err := os.ReadFile(path) // #nosec G304 path is set by the user
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
+
+// reloadConfig parses flags, env vars and config file again and applies the new config
+// if the new config is invalid the previous config is kept
+func reloadConfig() error {
+	configReloadLock.Lock()
+	defer configReloadLock.Unlock()
+
+	if err := applyConfig(); err != nil {
+		log.Errorf("config reload failed, keeping previous config: %v", err)
+		prometheusConfigReload.successful.Set(0)
+		return err
+	}
+
+	log.Info("config reloaded")
+	prometheusConfigReload.successful.Set(1)
+	prometheusConfigReload.successTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// applyConfig creates the new config, recreates the collectors with changed settings and replaces the live config
+// (running collector runs keep using their config snapshot)
+func applyConfig() error {
+	newOpts := &config.Opts{}
+	parser, err := argparserParse(newOpts)
+	if err != nil {
+		return err
+	}
+
+	previousConfig := currentConfig()
+	newConfig := &RuntimeConfig{
+		Opts:         newOpts,
+		AzureTenants: previousConfig.AzureTenants,
+	}
+
+	if newOpts.Portscan.Enabled {
+		if newConfig.PortscanPortRange, err = argparserParsePortrange(newOpts); err != nil {
+			return err
+		}
+	}
+
+	if newOpts.Server != previousConfig.Opts.Server {
+		log.Warn("server settings changed (bind address, timeouts, web config path or bearer token), restart needed to apply them")
+	}
+
+	if !reflect.DeepEqual(previousConfig.Opts.Azure, newOpts.Azure) || !reflect.DeepEqual(previousConfig.Opts.Tenants, newOpts.Tenants) {
+		log.Infof("azure settings changed, reconnecting")
+		if newConfig.AzureTenants, err = reloadAzureConnection(newOpts); err != nil {
+			return err
+		}
+	}
+
+	if err := collectorManager.Apply(newConfig); err != nil {
+		return err
+	}
+
+	liveConfig.Store(newConfig)
+	argparser = parser
+	initLogger()
+	log.Info(string(newOpts.GetJson()))
+
+	return nil
+}
+
+func reloadAzureConnection(opts *config.Opts) (tenants []*AzureTenant, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case *log.Entry:
+				err = fmt.Errorf("unable to init Azure connection: %v", v.Message)
+			default:
+				err = fmt.Errorf("unable to init Azure connection: %v", v)
+			}
+		}
+	}()
+
+	// ms graph connections are created again by the collectors if needed
+	return newAzureTenants(opts)
+}